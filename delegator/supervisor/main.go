@@ -7,10 +7,13 @@ import (
 	"net"
 	"net/rpc"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -55,7 +58,8 @@ type serviceParams struct {
 
 	currentCgroup string
 
-	req *scriptspb.WorkerExecutionRequest
+	sandboxReq *scriptspb.WorkerSandboxRequest
+	req        *scriptspb.WorkerExecutionRequest
 }
 
 var serviceFactories map[string]serviceFactory = map[string]serviceFactory{
@@ -76,7 +80,7 @@ var serviceFactories map[string]serviceFactory = map[string]serviceFactory{
 	},
 
 	"Supervisor": func(ctx context.Context, account *accountspb.Traits, params serviceParams) (interface{}, error) {
-		return supervisorservice.New(ctx, params.currentCgroup, params.req.OwnerName, scriptspb.NewScriptsClient(params.executorConn), params.req.Config, params.req.Context), nil
+		return supervisorservice.New(ctx, params.currentCgroup, params.req.OwnerName, scriptspb.NewScriptsClient(params.executorConn), params.sandboxReq, params.req.Config, params.req.Context), nil
 	},
 }
 
@@ -84,9 +88,11 @@ var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
 var (
 	scriptMountDir        string = "/mnt/scripts"
+	bundleMountDir               = "/mnt/bundle"
 	privateMountDir              = "/mnt/private"
 	legacyPrivateMountDir        = "/mnt/storage"
 	k4LibraryMountDir            = "/usr/lib/k4"
+	depsMountDir                 = "/usr/lib/k4/deps"
 )
 
 var marshaler = jsonpb.Marshaler{
@@ -213,9 +219,14 @@ func applyCgroups(traits *accountspb.Traits, currentCgroup string) error {
 	return nil
 }
 
-func applyRestrictions(traits *accountspb.Traits, currentCgroup string) error {
+func applyRestrictions(traits *accountspb.Traits, profile *scriptspb.SandboxProfile, currentCgroup string) error {
 	applyRlimits(traits)
 
+	// The cgroups were already set up for the sandbox profile, which normally matches the traits.
+	if traits.MemoryLimit == profile.MemoryLimit && traits.CpuShares == profile.CpuShares && traits.BlkioWeight == profile.BlkioWeight {
+		return nil
+	}
+
 	if err := applyCgroups(traits, currentCgroup); err != nil {
 		return err
 	}
@@ -223,9 +234,29 @@ func applyRestrictions(traits *accountspb.Traits, currentCgroup string) error {
 	return nil
 }
 
+func writeResult(childStatus *os.File, result *scriptspb.WorkerExecutionResult) error {
+	glog.Infof("Result: %s", result)
+
+	raw, err := proto.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	if _, err := childStatus.Write(raw); err != nil {
+		return err
+	}
+
+	return childStatus.Close()
+}
+
 func main() {
 	flag.Parse()
 
+	// The executor may cancel the execution at any point, including while this supervisor is still waiting for or
+	// setting up its execution, so SIGTERM must never get its default action.
+	cancelSignals := make(chan os.Signal, 1)
+	signal.Notify(cancelSignals, syscall.SIGTERM)
+
 	glog.Infof("Hello! I'm a supervisor and my parent cgroup is %s!", *parentCgroup)
 
 	ctx := context.Background()
@@ -235,6 +266,47 @@ func main() {
 	childStderr := os.NewFile(5, "child stderr")
 	childStatus := os.NewFile(6, "child status")
 	supervisorRequestFile := os.NewFile(7, "supervisor execution request")
+	sandboxRequestFile := os.NewFile(8, "supervisor sandbox request")
+
+	rawSandboxReq, err := ioutil.ReadAll(sandboxRequestFile)
+	if err != nil {
+		glog.Error(err)
+		os.Exit(1)
+	}
+
+	sandboxReq := &scriptspb.WorkerSandboxRequest{}
+	if err := proto.Unmarshal(rawSandboxReq, sandboxReq); err != nil {
+		glog.Error(err)
+		os.Exit(1)
+	}
+
+	glog.Infof("Sandbox request from executor: %s", sandboxReq)
+
+	// Everything up to reading the execution request only depends on the sandbox profile, so it can happen before this
+	// supervisor is assigned an execution.
+	currentCgroup := filepath.Join(*parentCgroup, strconv.Itoa(os.Getpid()))
+
+	if err := applyCgroups(&accountspb.Traits{
+		MemoryLimit: sandboxReq.Profile.MemoryLimit,
+		CpuShares:   sandboxReq.Profile.CpuShares,
+		BlkioWeight: sandboxReq.Profile.BlkioWeight,
+	}, currentCgroup); err != nil {
+		glog.Error(err)
+		os.Exit(1)
+	}
+
+	factory, err := libcontainer.New(sandboxReq.ContainersPath, libcontainer.RootlessCgroups, libcontainer.InitArgs(sandboxReq.NsenternetPath, os.Args[0], "init"))
+	if err != nil {
+		glog.Error(err)
+		os.Exit(1)
+	}
+
+	rootfsPath := filepath.Join(sandboxReq.RootfsesPath, strconv.Itoa(os.Getpid()))
+	if err := os.Mkdir(rootfsPath, 0755); err != nil {
+		glog.Error(err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(rootfsPath)
 
 	rawReq, err := ioutil.ReadAll(supervisorRequestFile)
 	if err != nil {
@@ -248,6 +320,10 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Take the secrets out of the request, so they are neither logged nor handed to any services.
+	secrets := req.Secrets
+	req.Secrets = nil
+
 	glog.Infof("Execution request from executor: %s", req)
 
 	if !nameRegexp.MatchString(req.OwnerName) || !nameRegexp.MatchString(req.Name) {
@@ -279,26 +355,17 @@ func main() {
 	}
 	glog.Infof("Owner account traits: %s", accountResp)
 
-	traits := accountResp.Traits
-	currentCgroup := filepath.Join(*parentCgroup, strconv.Itoa(os.Getpid()))
-
-	if err := applyRestrictions(traits, currentCgroup); err != nil {
-		glog.Error(err)
+	if accountResp.Suspended {
+		glog.Error("owner account is suspended")
 		os.Exit(1)
 	}
 
-	factory, err := libcontainer.New(req.Config.ContainersPath, libcontainer.RootlessCgroups, libcontainer.InitArgs(req.Config.NsenternetPath, os.Args[0], "init"))
-	if err != nil {
-		glog.Error(err)
-		os.Exit(1)
-	}
+	traits := accountResp.Traits
 
-	rootfsPath := filepath.Join(req.Config.RootfsesPath, strconv.Itoa(os.Getpid()))
-	if err := os.Mkdir(rootfsPath, 0755); err != nil {
+	if err := applyRestrictions(traits, sandboxReq.Profile, currentCgroup); err != nil {
 		glog.Error(err)
 		os.Exit(1)
 	}
-	defer os.RemoveAll(rootfsPath)
 
 	config := &configs.Config{
 		Rootfs:            rootfsPath,
@@ -430,6 +497,53 @@ func main() {
 		}
 	}
 
+	entrypoint := filepath.Join(scriptMountDir, req.Name)
+	if req.BundleEntrypoint != "" {
+		entrypoint = filepath.Join(bundleMountDir, req.BundleEntrypoint)
+		if !strings.HasPrefix(entrypoint, bundleMountDir+"/") {
+			glog.Error("invalid bundle entrypoint")
+			os.Exit(1)
+		}
+
+		config.Mounts = append(config.Mounts, &configs.Mount{
+			Device:      "bind",
+			Source:      filepath.Join(req.Config.StorageRootPath, req.OwnerName, "scripts", ".bundles", req.Name),
+			Destination: bundleMountDir,
+			Flags:       unix.MS_NOSUID | unix.MS_NODEV | unix.MS_BIND | unix.MS_REC | unix.MS_RDONLY,
+		})
+	}
+
+	if len(req.Dependency) > 0 {
+		// The library is mounted read-only, so mountpoints for dependencies are made in a tmpfs over its (empty) deps
+		// directory. The tmpfs is only remounted read-only after they are created.
+		config.Mounts = append(config.Mounts, &configs.Mount{
+			Device:      "tmpfs",
+			Source:      "tmpfs",
+			Destination: depsMountDir,
+			Flags:       unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC | unix.MS_RDONLY,
+			Data:        "size=64k,mode=755",
+		})
+	}
+
+	for _, dep := range req.Dependency {
+		if !nameRegexp.MatchString(dep.OwnerName) || !nameRegexp.MatchString(dep.Name) {
+			glog.Error("invalid dependency name")
+			os.Exit(1)
+		}
+
+		source := filepath.Join(req.Config.StorageRootPath, dep.OwnerName, "scripts", dep.Name)
+		if dep.Bundle {
+			source = filepath.Join(req.Config.StorageRootPath, dep.OwnerName, "scripts", ".bundles", dep.Name)
+		}
+
+		config.Mounts = append(config.Mounts, &configs.Mount{
+			Device:      "bind",
+			Source:      source,
+			Destination: filepath.Join(depsMountDir, dep.OwnerName, dep.Name),
+			Flags:       unix.MS_NOSUID | unix.MS_NODEV | unix.MS_BIND | unix.MS_REC | unix.MS_RDONLY,
+		})
+	}
+
 	if traits.TmpfsSize > 0 {
 		config.Mounts = append(config.Mounts, &configs.Mount{
 			Device:      "tmpfs",
@@ -479,6 +593,7 @@ func main() {
 		executorConn: executorConn,
 
 		currentCgroup: currentCgroup,
+		sandboxReq:    sandboxReq,
 		req:           req,
 	}
 
@@ -510,13 +625,25 @@ func main() {
 		os.Exit(1)
 	}
 
+	env := []string{
+		fmt.Sprintf("K4_CONTEXT=%s", jsonK4Context),
+	}
+
+	secretNames := make([]string, 0, len(secrets))
+	for name := range secrets {
+		secretNames = append(secretNames, name)
+	}
+	sort.Strings(secretNames)
+
+	for _, name := range secretNames {
+		env = append(env, fmt.Sprintf("K4_SECRET_%s=%s", name, secrets[name]))
+	}
+
 	process := &libcontainer.Process{
 		Args: []string{
-			"/bin/sh", "-c", shellquote.Join("exec", filepath.Join(scriptMountDir, req.Name)),
-		},
-		Env: []string{
-			fmt.Sprintf("K4_CONTEXT=%s", jsonK4Context),
+			"/bin/sh", "-c", shellquote.Join("exec", entrypoint),
 		},
+		Env:    env,
 		Cwd:    privateMountDir,
 		Stdin:  childStdin,
 		Stdout: childStdout,
@@ -526,6 +653,21 @@ func main() {
 		},
 	}
 
+	select {
+	case <-cancelSignals:
+		glog.Info("Cancelled before the execution started")
+		if err := writeResult(childStatus, &scriptspb.WorkerExecutionResult{
+			Cancelled:    true,
+			OutputParams: &scriptspb.OutputParams{},
+			Timings:      &scriptspb.WorkerExecutionResult_Timings{},
+		}); err != nil {
+			glog.Error(err)
+			os.Exit(1)
+		}
+		return
+	default:
+	}
+
 	startTime := time.Now()
 
 	if err := container.Run(process); err != nil {
@@ -536,12 +678,16 @@ func main() {
 
 	done := make(chan struct{})
 	timeLimitExceeded := false
+	cancelled := false
 
 	go func() {
 		select {
 		case <-time.After(time.Duration(traits.TimeLimitSeconds) * time.Second):
 			process.Signal(os.Kill)
 			timeLimitExceeded = true
+		case <-cancelSignals:
+			process.Signal(os.Kill)
+			cancelled = true
 		case <-done:
 		}
 	}()
@@ -563,6 +709,7 @@ func main() {
 	result := &scriptspb.WorkerExecutionResult{
 		WaitStatus:        uint32(waitStatus),
 		TimeLimitExceeded: timeLimitExceeded,
+		Cancelled:         cancelled,
 		OutputParams:      outputParams,
 		Timings: &scriptspb.WorkerExecutionResult_Timings{
 			RealNanos:   uint64((endTime.Sub(startTime)) / time.Nanosecond),
@@ -571,20 +718,7 @@ func main() {
 		},
 	}
 
-	glog.Infof("Result: %s", result)
-
-	raw, err := proto.Marshal(result)
-	if err != nil {
-		glog.Error(err)
-		os.Exit(1)
-	}
-
-	if _, err := childStatus.Write(raw); err != nil {
-		glog.Error(err)
-		os.Exit(1)
-	}
-
-	if err := childStatus.Close(); err != nil {
+	if err := writeResult(childStatus, result); err != nil {
 		glog.Error(err)
 		os.Exit(1)
 	}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strings"
 	"sync"
 	"syscall"
 
@@ -38,11 +39,12 @@ type Service struct {
 
 	scriptsClient scriptspb.ScriptsClient
 
-	config  *scriptspb.WorkerExecutionRequest_Configuration
-	context *scriptspb.Context
+	sandboxReq *scriptspb.WorkerSandboxRequest
+	config     *scriptspb.WorkerExecutionRequest_Configuration
+	context    *scriptspb.Context
 }
 
-func New(ctx context.Context, currentCgroup string, currentOwnerName string, scriptsClient scriptspb.ScriptsClient, config *scriptspb.WorkerExecutionRequest_Configuration, context *scriptspb.Context) *Service {
+func New(ctx context.Context, currentCgroup string, currentOwnerName string, scriptsClient scriptspb.ScriptsClient, sandboxReq *scriptspb.WorkerSandboxRequest, config *scriptspb.WorkerExecutionRequest_Configuration, context *scriptspb.Context) *Service {
 	return &Service{
 		ctx: ctx,
 
@@ -53,11 +55,42 @@ func New(ctx context.Context, currentCgroup string, currentOwnerName string, scr
 
 		scriptsClient: scriptsClient,
 
-		config:  config,
-		context: context,
+		sandboxReq: sandboxReq,
+		config:     config,
+		context:    context,
 	}
 }
 
+func (s *Service) resolveDependency(ownerName string, qualifiedName string) (*scriptspb.WorkerExecutionRequest_Dependency, error) {
+	parts := strings.SplitN(qualifiedName, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("dependency %s not available", qualifiedName)
+	}
+
+	metaResp, err := s.scriptsClient.GetMeta(s.ctx, &scriptspb.GetMetaRequest{
+		OwnerName: parts[0],
+		Name:      parts[1],
+	})
+	if err != nil {
+		switch grpc.Code(err) {
+		case codes.NotFound, codes.InvalidArgument:
+			return nil, fmt.Errorf("dependency %s not available", qualifiedName)
+		default:
+			return nil, err
+		}
+	}
+
+	if metaResp.Meta.Visibility == scriptspb.Visibility_UNPUBLISHED && parts[0] != ownerName {
+		return nil, fmt.Errorf("dependency %s not available", qualifiedName)
+	}
+
+	return &scriptspb.WorkerExecutionRequest_Dependency{
+		OwnerName: parts[0],
+		Name:      parts[1],
+		Bundle:    metaResp.Meta.BundleEntrypoint != "",
+	}, nil
+}
+
 func (s *Service) Spawn(req *struct {
 	OwnerName string `json:"ownerName"`
 	Name      string `json:"name"`
@@ -85,6 +118,23 @@ func (s *Service) Spawn(req *struct {
 		return errors.New("script not found")
 	}
 
+	chroot, ok := s.config.RuntimeChroots[metaResp.Meta.Runtime]
+	if !ok {
+		return errors.New("runtime not available")
+	}
+
+	config := *s.config
+	config.Chroot = chroot
+
+	dependencies := make([]*scriptspb.WorkerExecutionRequest_Dependency, len(metaResp.Meta.Dependency))
+	for i, dependency := range metaResp.Meta.Dependency {
+		dep, err := s.resolveDependency(req.OwnerName, dependency)
+		if err != nil {
+			return err
+		}
+		dependencies[i] = dep
+	}
+
 	glog.Infof("Supervisor is spawning: %s/%s", req.OwnerName, req.Name)
 
 	statusReader, statusWriter, err := os.Pipe()
@@ -111,6 +161,13 @@ func (s *Service) Spawn(req *struct {
 	defer reqWriter.Close()
 	defer reqReader.Close()
 
+	sandboxReqReader, sandboxReqWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer sandboxReqWriter.Close()
+	defer sandboxReqReader.Close()
+
 	child.cmd = exec.Command(os.Args[0], "-logtostderr", "-parent_cgroup", s.currentCgroup)
 	child.cmd.SysProcAttr = &syscall.SysProcAttr{
 		Pdeathsig: syscall.SIGKILL,
@@ -123,6 +180,7 @@ func (s *Service) Spawn(req *struct {
 		req.UnixRights[2],
 		statusWriter,
 		reqReader,
+		sandboxReqReader,
 	}
 	if err := child.cmd.Start(); err != nil {
 		return err
@@ -133,12 +191,25 @@ func (s *Service) Spawn(req *struct {
 	req.UnixRights[2].Close()
 	statusWriter.Close()
 	reqReader.Close()
+	sandboxReqReader.Close()
+
+	rawSandboxReq, err := proto.Marshal(s.sandboxReq)
+	if err != nil {
+		return err
+	}
+
+	if _, err := sandboxReqWriter.Write(rawSandboxReq); err != nil {
+		return err
+	}
+	sandboxReqWriter.Close()
 
 	workerReq := &scriptspb.WorkerExecutionRequest{
-		Config:    s.config,
-		OwnerName: req.OwnerName,
-		Name:      req.Name,
-		Context:   s.context,
+		Config:           &config,
+		OwnerName:        req.OwnerName,
+		Name:             req.Name,
+		Context:          s.context,
+		BundleEntrypoint: metaResp.Meta.BundleEntrypoint,
+		Dependency:       dependencies,
 	}
 
 	rawReq, err := proto.Marshal(workerReq)
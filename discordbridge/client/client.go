@@ -21,7 +21,10 @@ import (
 	"github.com/porpoises/kobun4/discordbridge/statsstore"
 	"github.com/porpoises/kobun4/discordbridge/varstore"
 
+	"github.com/porpoises/kobun4/executor/params"
+
 	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
+	schedulespb "github.com/porpoises/kobun4/executor/schedulesservice/v1pb"
 	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
 )
 
@@ -46,13 +49,14 @@ type Client struct {
 	stats    *statsstore.Store
 	budgeter *budget.Budgeter
 
-	accountsClient accountspb.AccountsClient
-	scriptsClient  scriptspb.ScriptsClient
+	accountsClient  accountspb.AccountsClient
+	scriptsClient   scriptspb.ScriptsClient
+	schedulesClient schedulespb.SchedulesClient
 
 	metaCommandRegexp *regexp.Regexp
 }
 
-func New(token string, opts *Options, knownGuildsOnly bool, rpcTarget net.Addr, vars *varstore.Store, stats *statsstore.Store, budgeter *budget.Budgeter, accountsClient accountspb.AccountsClient, scriptsClient scriptspb.ScriptsClient) (*Client, error) {
+func New(token string, opts *Options, knownGuildsOnly bool, rpcTarget net.Addr, vars *varstore.Store, stats *statsstore.Store, budgeter *budget.Budgeter, accountsClient accountspb.AccountsClient, scriptsClient scriptspb.ScriptsClient, schedulesClient schedulespb.SchedulesClient) (*Client, error) {
 	session, err := discordgo.New(fmt.Sprintf("Bot %s", token))
 	if err != nil {
 		return nil, err
@@ -70,14 +74,20 @@ func New(token string, opts *Options, knownGuildsOnly bool, rpcTarget net.Addr,
 		stats:    stats,
 		budgeter: budgeter,
 
-		accountsClient: accountsClient,
-		scriptsClient:  scriptsClient,
+		accountsClient:  accountsClient,
+		scriptsClient:   scriptsClient,
+		schedulesClient: schedulesClient,
 	}
 
 	session.AddHandler(client.ready)
 	session.AddHandler(client.guildCreate)
 	session.AddHandler(client.guildDelete)
 	session.AddHandler(client.messageCreate)
+	session.AddHandler(client.guildMemberAdd)
+	session.AddHandler(client.guildMemberRemove)
+	session.AddHandler(client.messageReactionAdd)
+	session.AddHandler(client.messageReactionRemove)
+	session.AddHandler(client.messageDelete)
 
 	if err := session.Open(); err != nil {
 		return nil, err
@@ -535,6 +545,21 @@ func (c *Client) runScriptCommand(ctx context.Context, guildVars *varstore.Guild
 		}
 	}
 
+	var args map[string]string
+	if len(metaResp.Meta.Parameter) > 0 {
+		args, err = params.Parse(metaResp.Meta.Parameter, rest)
+		if err != nil {
+			if pErr, ok := err.(*params.ParseError); ok {
+				return &commandError{
+					status:  errorStatusUser,
+					note:    pErr.Error(),
+					details: fmt.Sprintf("Usage: `%s %s`\n%s", commandName, params.Usage(metaResp.Meta.Parameter), params.Help(metaResp.Meta.Parameter)),
+				}
+			}
+			return err
+		}
+	}
+
 	remainingBudget, err := c.budgeter.Remaining(ctx, m.Author.ID)
 	if err != nil {
 		return err
@@ -566,6 +591,9 @@ func (c *Client) runScriptCommand(ctx context.Context, guildVars *varstore.Guild
 			NetworkId: "discord",
 
 			InputMessageId: m.ID,
+
+			Config:    link.Config,
+			Arguments: args,
 		},
 		BridgeTarget: c.rpcTarget.String(),
 	})
@@ -576,6 +604,11 @@ func (c *Client) runScriptCommand(ctx context.Context, guildVars *varstore.Guild
 				status: errorStatusScript,
 				note:   "References non-existent script",
 			}
+		case codes.ResourceExhausted:
+			return &commandError{
+				status: errorStatusRecoverable,
+				note:   "Too busy right now, please try again later",
+			}
 		case codes.Unavailable:
 			return &commandError{
 				status: errorStatusRecoverable,
@@ -652,6 +685,11 @@ func (c *Client) runScriptCommand(ctx context.Context, guildVars *varstore.Guild
 			status: errorStatusScript,
 			note:   "Script took too long!",
 		}
+	} else if resp.Result.Cancelled {
+		return &commandError{
+			status: errorStatusScript,
+			note:   "Script was cancelled.",
+		}
 	} else if waitStatus.Signaled() {
 		return &commandError{
 			status: errorStatusScript,
@@ -0,0 +1,308 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/porpoises/kobun4/discordbridge/varstore"
+
+	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+)
+
+const (
+	eventMemberJoin     = "member_join"
+	eventMemberLeave    = "member_leave"
+	eventReactionAdd    = "reaction_add"
+	eventReactionRemove = "reaction_remove"
+	eventMessageDelete  = "message_delete"
+)
+
+var eventNames = []string{
+	eventMemberJoin,
+	eventMemberLeave,
+	eventReactionAdd,
+	eventReactionRemove,
+	eventMessageDelete,
+}
+
+func isEventName(name string) bool {
+	for _, eventName := range eventNames {
+		if eventName == name {
+			return true
+		}
+	}
+	return false
+}
+
+type guildEvent struct {
+	name      string
+	guildID   string
+	userID    string
+	messageID string
+	extra     map[string]string
+	payload   interface{}
+}
+
+func (c *Client) guildMemberAdd(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	if m.User.ID == s.State.User.ID {
+		return
+	}
+
+	c.handleGuildEvent(&guildEvent{
+		name:    eventMemberJoin,
+		guildID: m.GuildID,
+		userID:  m.User.ID,
+		extra: map[string]string{
+			"username": m.User.Username,
+			"bot":      fmt.Sprintf("%t", m.User.Bot),
+		},
+		payload: m.Member,
+	})
+}
+
+func (c *Client) guildMemberRemove(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+	if m.User.ID == s.State.User.ID {
+		return
+	}
+
+	c.handleGuildEvent(&guildEvent{
+		name:    eventMemberLeave,
+		guildID: m.GuildID,
+		userID:  m.User.ID,
+		extra: map[string]string{
+			"username": m.User.Username,
+			"bot":      fmt.Sprintf("%t", m.User.Bot),
+		},
+		payload: m.Member,
+	})
+}
+
+func (c *Client) reactionEvent(s *discordgo.Session, eventName string, r *discordgo.MessageReaction) {
+	if r.UserID == s.State.User.ID {
+		return
+	}
+
+	channel, err := s.State.Channel(r.ChannelID)
+	if err != nil {
+		glog.Errorf("Failed to get channel: %v", err)
+		return
+	}
+
+	if channel.GuildID == "" {
+		return
+	}
+
+	c.handleGuildEvent(&guildEvent{
+		name:      eventName,
+		guildID:   channel.GuildID,
+		userID:    r.UserID,
+		messageID: r.MessageID,
+		extra: map[string]string{
+			"eventChannelId": r.ChannelID,
+			"emojiId":        r.Emoji.ID,
+			"emojiName":      r.Emoji.Name,
+		},
+		payload: r,
+	})
+}
+
+func (c *Client) messageReactionAdd(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
+	c.reactionEvent(s, eventReactionAdd, m.MessageReaction)
+}
+
+func (c *Client) messageReactionRemove(s *discordgo.Session, m *discordgo.MessageReactionRemove) {
+	c.reactionEvent(s, eventReactionRemove, m.MessageReaction)
+}
+
+func (c *Client) messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	channel, err := s.State.Channel(m.ChannelID)
+	if err != nil {
+		glog.Errorf("Failed to get channel: %v", err)
+		return
+	}
+
+	if channel.GuildID == "" {
+		return
+	}
+
+	var userID string
+	if m.Author != nil {
+		if m.Author.ID == s.State.User.ID {
+			return
+		}
+		userID = m.Author.ID
+	}
+
+	c.handleGuildEvent(&guildEvent{
+		name:      eventMessageDelete,
+		guildID:   channel.GuildID,
+		userID:    userID,
+		messageID: m.ID,
+		extra: map[string]string{
+			"eventChannelId": m.ChannelID,
+		},
+		payload: m.Message,
+	})
+}
+
+func (c *Client) handleGuildEvent(e *guildEvent) {
+	ctx := context.Background()
+
+	var guildVars *varstore.GuildVars
+	var bindings []*varstore.EventBinding
+
+	if err := func() error {
+		tx, err := c.vars.BeginTx(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		guildVars, err = c.vars.GuildVars(ctx, tx, e.guildID)
+		if err != nil {
+			return err
+		}
+
+		bindings, err = c.vars.GuildEventBindings(ctx, tx, e.guildID, e.name)
+		return err
+	}(); err != nil {
+		if err != varstore.ErrNotFound {
+			glog.Errorf("Failed to get event bindings: %v", err)
+		}
+		return
+	}
+
+	if len(bindings) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(e.payload)
+	if err != nil {
+		glog.Errorf("Failed to marshal event payload: %v", err)
+		return
+	}
+
+	for _, binding := range bindings {
+		go func(binding *varstore.EventBinding) {
+			if err := c.runEventScript(ctx, guildVars, e, binding, payload); err != nil {
+				glog.Errorf("Failed to run %s/%s for %s in %s: %v", binding.OwnerName, binding.ScriptName, e.name, e.guildID, err)
+			}
+		}(binding)
+	}
+}
+
+func (c *Client) runEventScript(ctx context.Context, guildVars *varstore.GuildVars, e *guildEvent, binding *varstore.EventBinding, payload []byte) error {
+	metaResp, err := c.scriptsClient.GetMeta(ctx, &scriptspb.GetMetaRequest{
+		OwnerName: binding.OwnerName,
+		Name:      binding.ScriptName,
+	})
+	if err != nil {
+		return err
+	}
+	if metaResp.Meta.Visibility == scriptspb.Visibility_UNPUBLISHED {
+		return fmt.Errorf("script is unpublished")
+	}
+
+	if e.userID != "" {
+		remainingBudget, err := c.budgeter.Remaining(ctx, e.userID)
+		if err != nil {
+			return err
+		}
+
+		if remainingBudget <= 0 {
+			return nil
+		}
+
+		if err := c.budgeter.Charge(ctx, e.userID, c.opts.MinCostPerUser); err != nil {
+			return err
+		}
+	}
+
+	extra := map[string]string{
+		"event": e.name,
+	}
+	for k, v := range e.extra {
+		extra[k] = v
+	}
+
+	resp, err := c.scriptsClient.Execute(ctx, &scriptspb.ExecuteRequest{
+		OwnerName: binding.OwnerName,
+		Name:      binding.ScriptName,
+		Stdin:     payload,
+		Context: &scriptspb.Context{
+			BridgeName:  "discord",
+			CommandName: e.name,
+
+			UserId:    e.userID,
+			ChannelId: binding.ChannelID,
+			GroupId:   e.guildID,
+			NetworkId: "discord",
+
+			InputMessageId: e.messageID,
+
+			Extra: extra,
+		},
+		BridgeTarget: c.rpcTarget.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if e.userID != "" {
+		totalCost := time.Duration(resp.Result.Timings.RealNanos) * time.Nanosecond
+		remainingCost := totalCost - c.opts.MinCostPerUser
+
+		if remainingCost > 0 {
+			if err := c.budgeter.Charge(ctx, e.userID, remainingCost); err != nil {
+				return err
+			}
+		}
+	}
+
+	waitStatus := syscall.WaitStatus(resp.Result.WaitStatus)
+	if waitStatus.ExitStatus() != 0 || len(resp.Stdout) == 0 {
+		return nil
+	}
+
+	outputFormatter, ok := OutputFormatters[resp.Result.OutputParams.Format]
+	if !ok {
+		return fmt.Errorf("output format %s unknown", resp.Result.OutputParams.Format)
+	}
+
+	messageSend, err := outputFormatter("", resp.Stdout, true)
+	if err != nil {
+		return err
+	}
+
+	channelID := binding.ChannelID
+	if resp.Result.OutputParams.Private && e.userID != "" {
+		channel, err := c.session.UserChannelCreate(e.userID)
+		if err != nil {
+			return err
+		}
+		channelID = channel.ID
+	}
+
+	msg, err := c.session.ChannelMessageSendComplex(channelID, messageSend)
+	if err != nil {
+		return err
+	}
+
+	if resp.Result.OutputParams.Expires && guildVars.MessageExpiry > 0 {
+		go func() {
+			<-time.After(guildVars.MessageExpiry)
+			if err := c.session.ChannelMessageDelete(channelID, msg.ID); err != nil {
+				glog.Errorf("Failed to delete message: %v", err)
+			}
+		}()
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,8 +14,12 @@ import (
 
 	"github.com/porpoises/kobun4/discordbridge/varstore"
 
+	"github.com/porpoises/kobun4/executor/params"
+
 	"github.com/bwmarrin/discordgo"
 
+	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
+	schedulespb "github.com/porpoises/kobun4/executor/schedulesservice/v1pb"
 	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
 )
 
@@ -133,6 +138,8 @@ var metaCommands map[string]metaCommand = map[string]metaCommand{
 				formattedNames[j] = linkNames[k]
 			}
 
+			sort.Strings(formattedNames)
+
 			meta := uniqueLinkMetas[i]
 			description := "**Command not found. Contact an administrator.**"
 			if meta != nil {
@@ -140,10 +147,11 @@ var metaCommands map[string]metaCommand = map[string]metaCommand{
 				if description == "" {
 					description = "_No description set._"
 				}
+				if len(meta.Parameter) > 0 {
+					description += fmt.Sprintf("\nUsage: `%s %s`", formattedNames[0], params.Usage(meta.Parameter))
+				}
 			}
 
-			sort.Strings(formattedNames)
-
 			fields[i] = &discordgo.MessageEmbedField{
 				Name:  strings.Join(formattedNames, ", "),
 				Value: fmt.Sprintf("[`[%s]`](%s/scripts/view.html?%s) %s", qualifiedName, c.opts.HomeURL, qualifiedName, description),
@@ -154,6 +162,13 @@ var metaCommands map[string]metaCommand = map[string]metaCommand{
 
 		prefix := fmt.Sprintf("@%s", c.session.State.User.Username)
 
+		fields = append(fields,
+			&discordgo.MessageEmbedField{
+				Name:  fmt.Sprintf("%s linkaccount", prefix),
+				Value: `Get a code, sent to you privately, that links your Discord account to a Kobun account you log into another way.`,
+			},
+		)
+
 		if isAdmin {
 			fields = append(fields,
 				&discordgo.MessageEmbedField{
@@ -209,10 +224,38 @@ var metaCommands map[string]metaCommand = map[string]metaCommand{
 						Name:  fmt.Sprintf("%s unlink <command name>", prefix),
 						Value: `Remove a command name link.`,
 					},
+					&discordgo.MessageEmbedField{
+						Name:  fmt.Sprintf("%s config <command name> [<key> [<value>]]", prefix),
+						Value: `Set a configuration value for a linked command, e.g. its default city. Leave out the value to remove the key, or the key to list the command's configuration. Relinking a command clears its configuration.`,
+					},
 					&discordgo.MessageEmbedField{
 						Name:  fmt.Sprintf("%s run <owner name>/<script name> [<input>]", prefix),
 						Value: `Run a script. If you are the owner of the script, you may run it even if it is unpublished.`,
 					},
+					&discordgo.MessageEmbedField{
+						Name:  fmt.Sprintf("%s schedule add <minute> <hour> <day of month> <month> <day of week> <time zone> <script name> [<input>]", prefix),
+						Value: `Run a script in this channel on a cron schedule, e.g. ` + "`0 9 * * 1-5 Europe/London`" + ` for 9am on weekdays.`,
+					},
+					&discordgo.MessageEmbedField{
+						Name:  fmt.Sprintf("%s schedule list", prefix),
+						Value: `List schedules on this server.`,
+					},
+					&discordgo.MessageEmbedField{
+						Name:  fmt.Sprintf("%s schedule remove <schedule ID>", prefix),
+						Value: `Remove a schedule.`,
+					},
+					&discordgo.MessageEmbedField{
+						Name:  fmt.Sprintf("%s bind <event> <script name>", prefix),
+						Value: fmt.Sprintf(`Run a script whenever an event happens on this server, sending its output to this channel. Events are: %s.`, "`"+strings.Join(eventNames, "`, `")+"`"),
+					},
+					&discordgo.MessageEmbedField{
+						Name:  fmt.Sprintf("%s unbind <event> <script name>", prefix),
+						Value: `Remove an event binding.`,
+					},
+					&discordgo.MessageEmbedField{
+						Name:  fmt.Sprintf("%s bindings", prefix),
+						Value: `List event bindings on this server.`,
+					},
 				},
 			},
 		})
@@ -230,6 +273,48 @@ var metaCommands map[string]metaCommand = map[string]metaCommand{
 
 		return nil
 	},
+	"linkaccount": func(ctx context.Context, c *Client, guildVars *varstore.GuildVars, m *discordgo.Message, guild *discordgo.Guild, channel *discordgo.Channel, member *discordgo.Member, rest string) error {
+		linkResp, err := c.accountsClient.CreateLinkCode(ctx, &accountspb.CreateLinkCodeRequest{
+			Identifier: fmt.Sprintf("discord/%s", m.Author.ID),
+		})
+		if err != nil {
+			if grpc.Code(err) == codes.Unavailable {
+				return &commandError{
+					status: errorStatusRecoverable,
+					note:   "Currently unavailable, please try again later",
+				}
+			}
+			return err
+		}
+
+		// The code proves ownership of the Discord account, so it must only ever be sent privately.
+		dmChannel, err := c.session.UserChannelCreate(m.Author.ID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := c.session.ChannelMessageSendComplex(dmChannel.ID, &discordgo.MessageSend{
+			Embed: &discordgo.MessageEmbed{
+				Title:       "🔗 Link Account",
+				URL:         c.opts.HomeURL,
+				Description: fmt.Sprintf("Your link code is `%s`. Enter it while logged into the [Kobun account](%s) you want to link your Discord account to. It can only be used once.\n\n**Do not share this code with anyone.**", linkResp.Code, c.opts.HomeURL),
+				Color:       0x009100,
+				Footer: &discordgo.MessageEmbedFooter{
+					Text: "Expires",
+				},
+				Timestamp: time.Unix(linkResp.ExpireTime, 0).Format(time.RFC3339),
+			},
+		}); err != nil {
+			return &commandError{
+				status: errorStatusUser,
+				note:   "Couldn't send you a direct message, please check your privacy settings",
+			}
+		}
+
+		c.session.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s>: ✅ Check your direct messages.", m.Author.ID))
+
+		return nil
+	},
 	"link": adminOnly(func(ctx context.Context, c *Client, guildVars *varstore.GuildVars, m *discordgo.Message, guild *discordgo.Guild, channel *discordgo.Channel, member *discordgo.Member, rest string) error {
 		parts := strings.SplitN(rest, " ", 2)
 
@@ -428,4 +513,445 @@ var metaCommands map[string]metaCommand = map[string]metaCommand{
 
 		return nil
 	}),
+	"config": adminOnly(func(ctx context.Context, c *Client, guildVars *varstore.GuildVars, m *discordgo.Message, guild *discordgo.Guild, channel *discordgo.Channel, member *discordgo.Member, rest string) error {
+		parts := strings.SplitN(rest, " ", 3)
+
+		commandName := parts[0]
+		if commandName == "" {
+			return &commandError{
+				status: errorStatusUser,
+				note:   "Expecting `config <command name> [<key> [<value>]]`",
+			}
+		}
+
+		tx, err := c.vars.BeginTx(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if len(parts) > 1 {
+			var value string
+			if len(parts) == 3 {
+				value = strings.TrimSpace(parts[2])
+			}
+
+			if err := c.vars.SetGuildLinkConfig(ctx, tx, channel.GuildID, commandName, parts[1], value); err != nil {
+				switch err {
+				case varstore.ErrNotFound:
+					return &commandError{
+						status: errorStatusUser,
+						note:   "Link not found",
+					}
+				case varstore.ErrInvalid:
+					return &commandError{
+						status: errorStatusUser,
+						note:   "Keys must be at most 20 characters and values at most 200, with up to 20 keys per command",
+					}
+				}
+				return err
+			}
+		}
+
+		link, err := c.vars.GuildLink(ctx, tx, channel.GuildID, commandName)
+		if err != nil {
+			if err == varstore.ErrNotFound {
+				return &commandError{
+					status: errorStatusUser,
+					note:   "Link not found",
+				}
+			}
+			return err
+		}
+
+		tx.Commit()
+
+		description := "This command has no configuration."
+
+		keys := make([]string, 0, len(link.Config))
+		for key := range link.Config {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fields := make([]*discordgo.MessageEmbedField, len(keys))
+		for i, key := range keys {
+			description = "Here's this command's configuration."
+			fields[i] = &discordgo.MessageEmbedField{
+				Name:  fmt.Sprintf("`%s`", key),
+				Value: link.Config[key],
+			}
+		}
+
+		c.session.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+			Content: fmt.Sprintf("<@%s>: ✅", m.Author.ID),
+			Embed: &discordgo.MessageEmbed{
+				Title:       fmt.Sprintf("`%s`", commandName),
+				Description: description,
+				Color:       0x009100,
+				Fields:      fields,
+			},
+		})
+
+		return nil
+	}),
+	"schedule": adminOnly(func(ctx context.Context, c *Client, guildVars *varstore.GuildVars, m *discordgo.Message, guild *discordgo.Guild, channel *discordgo.Channel, member *discordgo.Member, rest string) error {
+		parts := strings.SplitN(rest, " ", 2)
+
+		var args string
+		if len(parts) == 2 {
+			args = parts[1]
+		}
+
+		subcommand, ok := scheduleSubcommands[parts[0]]
+		if !ok {
+			return &commandError{
+				status: errorStatusUser,
+				note:   "Expecting `schedule add`, `schedule list` or `schedule remove`",
+			}
+		}
+
+		return subcommand(ctx, c, guildVars, m, guild, channel, member, args)
+	}),
+	"bind": adminOnly(func(ctx context.Context, c *Client, guildVars *varstore.GuildVars, m *discordgo.Message, guild *discordgo.Guild, channel *discordgo.Channel, member *discordgo.Member, rest string) error {
+		parts := strings.Fields(rest)
+
+		if len(parts) != 2 {
+			return &commandError{
+				status: errorStatusUser,
+				note:   "Expecting `bind <event> <qualified script name>`",
+			}
+		}
+
+		eventName := parts[0]
+		if !isEventName(eventName) {
+			return &commandError{
+				status: errorStatusUser,
+				note:   fmt.Sprintf("Unknown event, expecting one of: %s", "`"+strings.Join(eventNames, "`, `")+"`"),
+			}
+		}
+
+		scriptParts := strings.SplitN(parts[1], "/", 2)
+		if len(scriptParts) != 2 {
+			return &commandError{
+				status: errorStatusUser,
+				note:   "Script name must be of format `<owner name>/<script name>`",
+			}
+		}
+
+		getMeta, err := c.scriptsClient.GetMeta(ctx, &scriptspb.GetMetaRequest{
+			OwnerName: scriptParts[0],
+			Name:      scriptParts[1],
+		})
+		if err != nil {
+			if grpc.Code(err) == codes.NotFound {
+				return &commandError{
+					status: errorStatusUser,
+					note:   "Script not found",
+				}
+			} else if grpc.Code(err) == codes.InvalidArgument {
+				return &commandError{
+					status: errorStatusUser,
+					note:   "Invalid script name",
+				}
+			}
+			return err
+		}
+		if getMeta.Meta.Visibility == scriptspb.Visibility_UNPUBLISHED {
+			return &commandError{
+				status: errorStatusScript,
+				note:   "Script not found",
+			}
+		}
+
+		tx, err := c.vars.BeginTx(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := c.vars.SetGuildEventBinding(ctx, tx, channel.GuildID, &varstore.EventBinding{
+			EventName:  eventName,
+			OwnerName:  scriptParts[0],
+			ScriptName: scriptParts[1],
+			ChannelID:  m.ChannelID,
+		}); err != nil {
+			if err == varstore.ErrInvalid {
+				return &commandError{
+					status: errorStatusUser,
+					note:   "Invalid script name",
+				}
+			}
+			return err
+		}
+
+		tx.Commit()
+
+		c.session.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+			Content: fmt.Sprintf("<@%s>: ✅", m.Author.ID),
+			Embed: &discordgo.MessageEmbed{
+				Title:       fmt.Sprintf("`%s`", eventName),
+				Description: fmt.Sprintf("`%s/%s` will run on `%s` in this channel.", scriptParts[0], scriptParts[1], eventName),
+				Color:       0x009100,
+			},
+		})
+
+		return nil
+	}),
+	"unbind": adminOnly(func(ctx context.Context, c *Client, guildVars *varstore.GuildVars, m *discordgo.Message, guild *discordgo.Guild, channel *discordgo.Channel, member *discordgo.Member, rest string) error {
+		parts := strings.Fields(rest)
+
+		if len(parts) != 2 {
+			return &commandError{
+				status: errorStatusUser,
+				note:   "Expecting `unbind <event> <qualified script name>`",
+			}
+		}
+
+		eventName := parts[0]
+
+		scriptParts := strings.SplitN(parts[1], "/", 2)
+		if len(scriptParts) != 2 {
+			return &commandError{
+				status: errorStatusUser,
+				note:   "Script name must be of format `<owner name>/<script name>`",
+			}
+		}
+
+		tx, err := c.vars.BeginTx(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := c.vars.DeleteGuildEventBinding(ctx, tx, channel.GuildID, eventName, scriptParts[0], scriptParts[1]); err != nil {
+			if err == varstore.ErrNotFound {
+				return &commandError{
+					status: errorStatusUser,
+					note:   "Binding not found",
+				}
+			}
+			return err
+		}
+
+		tx.Commit()
+
+		c.session.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+			Content: fmt.Sprintf("<@%s>: ✅", m.Author.ID),
+			Embed: &discordgo.MessageEmbed{
+				Title:       fmt.Sprintf("`%s`", eventName),
+				Color:       0x009100,
+				Description: "Binding removed.",
+			},
+		})
+
+		return nil
+	}),
+	"bindings": adminOnly(func(ctx context.Context, c *Client, guildVars *varstore.GuildVars, m *discordgo.Message, guild *discordgo.Guild, channel *discordgo.Channel, member *discordgo.Member, rest string) error {
+		tx, err := c.vars.BeginTx(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		bindings, err := c.vars.GuildEventBindings(ctx, tx, channel.GuildID, "")
+		if err != nil {
+			return err
+		}
+
+		description := "There aren't any event bindings on this server yet."
+
+		fields := make([]*discordgo.MessageEmbedField, len(bindings))
+		for i, binding := range bindings {
+			description = "Here's a listing of event bindings on this server."
+			fields[i] = &discordgo.MessageEmbedField{
+				Name:  fmt.Sprintf("`%s`", binding.EventName),
+				Value: fmt.Sprintf("`%s/%s` in <#%s>", binding.OwnerName, binding.ScriptName, binding.ChannelID),
+			}
+		}
+
+		c.session.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+			Content: fmt.Sprintf("<@%s>: ✅", m.Author.ID),
+			Embed: &discordgo.MessageEmbed{
+				Title:       "🔔 Event Bindings",
+				Description: description,
+				Color:       0x009100,
+				Fields:      fields,
+			},
+		})
+
+		return nil
+	}),
+}
+
+var scheduleSubcommands map[string]metaCommand = map[string]metaCommand{
+	"add": func(ctx context.Context, c *Client, guildVars *varstore.GuildVars, m *discordgo.Message, guild *discordgo.Guild, channel *discordgo.Channel, member *discordgo.Member, rest string) error {
+		parts := strings.SplitN(rest, " ", 8)
+
+		if len(parts) < 7 {
+			return &commandError{
+				status: errorStatusUser,
+				note:   "Expecting `schedule add <minute> <hour> <day of month> <month> <day of week> <time zone> <qualified script name> [<input>]`",
+			}
+		}
+
+		spec := strings.Join(parts[:5], " ")
+		timeZone := parts[5]
+
+		scriptParts := strings.SplitN(parts[6], "/", 2)
+		if len(scriptParts) != 2 {
+			return &commandError{
+				status: errorStatusUser,
+				note:   "Script name must be of format `<owner name>/<script name>`",
+			}
+		}
+
+		var input string
+		if len(parts) == 8 {
+			input = parts[7]
+		}
+
+		getMeta, err := c.scriptsClient.GetMeta(ctx, &scriptspb.GetMetaRequest{
+			OwnerName: scriptParts[0],
+			Name:      scriptParts[1],
+		})
+		if err != nil {
+			if grpc.Code(err) == codes.NotFound {
+				return &commandError{
+					status: errorStatusUser,
+					note:   "Script not found",
+				}
+			} else if grpc.Code(err) == codes.InvalidArgument {
+				return &commandError{
+					status: errorStatusUser,
+					note:   "Invalid script name",
+				}
+			}
+			return err
+		}
+		if getMeta.Meta.Visibility == scriptspb.Visibility_UNPUBLISHED {
+			return &commandError{
+				status: errorStatusScript,
+				note:   "Script not found",
+			}
+		}
+
+		createResp, err := c.schedulesClient.CreateSchedule(ctx, &schedulespb.CreateScheduleRequest{
+			Schedule: &schedulespb.Schedule{
+				OwnerName:    scriptParts[0],
+				Name:         scriptParts[1],
+				Spec:         spec,
+				TimeZone:     timeZone,
+				BridgeTarget: c.rpcTarget.String(),
+				Context: &scriptspb.Context{
+					BridgeName:  "discord",
+					CommandName: "schedule",
+
+					UserId:    m.Author.ID,
+					ChannelId: m.ChannelID,
+					GroupId:   channel.GuildID,
+					NetworkId: "discord",
+				},
+				Stdin: []byte(input),
+			},
+		})
+		if err != nil {
+			switch grpc.Code(err) {
+			case codes.InvalidArgument:
+				return &commandError{
+					status: errorStatusUser,
+					note:   "Invalid schedule or time zone",
+				}
+			case codes.NotFound:
+				return &commandError{
+					status: errorStatusUser,
+					note:   "Script not found",
+				}
+			}
+			return err
+		}
+
+		c.session.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+			Content: fmt.Sprintf("<@%s>: ✅", m.Author.ID),
+			Embed: &discordgo.MessageEmbed{
+				Title:       fmt.Sprintf("⏰ Schedule %d", createResp.Schedule.Id),
+				Description: fmt.Sprintf("`%s/%s` will run at `%s` (%s) in this channel.", scriptParts[0], scriptParts[1], spec, timeZone),
+				Color:       0x009100,
+				Fields: []*discordgo.MessageEmbedField{
+					{
+						Name:  "Next Run",
+						Value: time.Unix(createResp.Schedule.NextRunTime, 0).UTC().Format(time.RFC1123),
+					},
+				},
+			},
+		})
+
+		return nil
+	},
+	"list": func(ctx context.Context, c *Client, guildVars *varstore.GuildVars, m *discordgo.Message, guild *discordgo.Guild, channel *discordgo.Channel, member *discordgo.Member, rest string) error {
+		listResp, err := c.schedulesClient.ListSchedules(ctx, &schedulespb.ListSchedulesRequest{
+			BridgeName: "discord",
+			GroupId:    channel.GuildID,
+		})
+		if err != nil {
+			return err
+		}
+
+		description := "There aren't any schedules on this server yet."
+
+		fields := make([]*discordgo.MessageEmbedField, len(listResp.Schedule))
+		for i, schedule := range listResp.Schedule {
+			description = "Here's a listing of schedules on this server."
+			fields[i] = &discordgo.MessageEmbedField{
+				Name:  fmt.Sprintf("%d: `%s` (%s)", schedule.Id, schedule.Spec, schedule.TimeZone),
+				Value: fmt.Sprintf("`%s/%s` in <#%s>, next run %s", schedule.OwnerName, schedule.Name, schedule.Context.ChannelId, time.Unix(schedule.NextRunTime, 0).UTC().Format(time.RFC1123)),
+			}
+		}
+
+		c.session.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+			Content: fmt.Sprintf("<@%s>: ✅", m.Author.ID),
+			Embed: &discordgo.MessageEmbed{
+				Title:       "⏰ Schedules",
+				Description: description,
+				Color:       0x009100,
+				Fields:      fields,
+			},
+		})
+
+		return nil
+	},
+	"remove": func(ctx context.Context, c *Client, guildVars *varstore.GuildVars, m *discordgo.Message, guild *discordgo.Guild, channel *discordgo.Channel, member *discordgo.Member, rest string) error {
+		id, err := strconv.ParseInt(strings.TrimSpace(rest), 10, 64)
+		if err != nil {
+			return &commandError{
+				status: errorStatusUser,
+				note:   "Expecting `schedule remove <schedule ID>`",
+			}
+		}
+
+		if _, err := c.schedulesClient.DeleteSchedule(ctx, &schedulespb.DeleteScheduleRequest{
+			Id:         id,
+			BridgeName: "discord",
+			GroupId:    channel.GuildID,
+		}); err != nil {
+			if grpc.Code(err) == codes.NotFound {
+				return &commandError{
+					status: errorStatusUser,
+					note:   "Schedule not found",
+				}
+			}
+			return err
+		}
+
+		c.session.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+			Content: fmt.Sprintf("<@%s>: ✅", m.Author.ID),
+			Embed: &discordgo.MessageEmbed{
+				Title:       fmt.Sprintf("⏰ Schedule %d", id),
+				Color:       0x009100,
+				Description: "Schedule removed.",
+			},
+		})
+
+		return nil
+	},
 }
@@ -37,6 +37,7 @@ import (
 	statspb "github.com/porpoises/kobun4/executor/statsservice/v1pb"
 
 	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
+	schedulespb "github.com/porpoises/kobun4/executor/schedulesservice/v1pb"
 	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
 )
 
@@ -123,7 +124,7 @@ func main() {
 		glog.Fatalf("failed to unmarshal stats reporter targets: %v", err)
 	}
 
-	client, err := client.New(*botToken, options, *knownGuildsOnly, lis.Addr(), vars, stats, budgeter, accountspb.NewAccountsClient(executorConn), scriptspb.NewScriptsClient(executorConn))
+	client, err := client.New(*botToken, options, *knownGuildsOnly, lis.Addr(), vars, stats, budgeter, accountspb.NewAccountsClient(executorConn), scriptspb.NewScriptsClient(executorConn), schedulespb.NewSchedulesClient(executorConn))
 	if err != nil {
 		glog.Fatalf("failed to connect to discord: %v", err)
 	}
@@ -0,0 +1,20 @@
+-- Upgrades a Discord bridge database created from the previous release's schema.sql to the current one.
+
+begin;
+
+alter table guild_links
+    add column config jsonb not null default '{}';
+
+create table guild_event_bindings (
+    guild_id character varying not null,
+    event_name character varying(20) not null,
+    owner_name character varying(20) not null,
+    script_name character varying(20) not null,
+    channel_id character varying not null,
+
+    primary key (guild_id, event_name, owner_name, script_name)
+);
+
+create index guild_event_bindings_guild_id_event_name_idx on guild_event_bindings (guild_id, event_name);
+
+commit;
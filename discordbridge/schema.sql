@@ -11,6 +11,7 @@ create table guild_links (
     link_name character varying(20) not null,
     owner_name character varying(20) not null,
     script_name character varying(20) not null,
+    config jsonb not null default '{}',
 
     primary key (guild_id, link_name)
 );
@@ -19,6 +20,18 @@ create index guild_links_guild_id_idx on guild_links (guild_id);
 create index guild_links_guild_id_script_idx on guild_links (guild_id, owner_name, script_name);
 create index guild_links_link_name_text_idx on guild_links (guild_id, link_name text_pattern_ops);
 
+create table guild_event_bindings (
+    guild_id character varying not null,
+    event_name character varying(20) not null,
+    owner_name character varying(20) not null,
+    script_name character varying(20) not null,
+    channel_id character varying not null,
+
+    primary key (guild_id, event_name, owner_name, script_name)
+);
+
+create index guild_event_bindings_guild_id_event_name_idx on guild_event_bindings (guild_id, event_name);
+
 create table user_channel_stats (
     user_id character varying not null,
     channel_id character varying not null,
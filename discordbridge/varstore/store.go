@@ -2,6 +2,7 @@ package varstore
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -108,13 +109,20 @@ func (s *Store) SetGuildVars(ctx context.Context, tx *sql.Tx, guildID string, gu
 type Link struct {
 	OwnerName  string
 	ScriptName string
+	Config     map[string]string
 }
 
+const (
+	maxLinkConfigEntries     = 20
+	maxLinkConfigKeyLength   = 20
+	maxLinkConfigValueLength = 200
+)
+
 func (s *Store) GuildLinks(ctx context.Context, tx *sql.Tx, guildID string) (map[string]*Link, error) {
 	links := make(map[string]*Link)
 
 	rows, err := tx.QueryContext(ctx, `
-		select link_name, owner_name, script_name
+		select link_name, owner_name, script_name, config
 		from guild_links
 		where guild_id = $1
 	`, guildID)
@@ -125,9 +133,14 @@ func (s *Store) GuildLinks(ctx context.Context, tx *sql.Tx, guildID string) (map
 
 	for rows.Next() {
 		var linkName string
+		var rawConfig []byte
 		link := &Link{}
 
-		if err := rows.Scan(&linkName, &link.OwnerName, &link.ScriptName); err != nil {
+		if err := rows.Scan(&linkName, &link.OwnerName, &link.ScriptName, &rawConfig); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(rawConfig, &link.Config); err != nil {
 			return nil, err
 		}
 
@@ -142,40 +155,50 @@ func (s *Store) GuildLinks(ctx context.Context, tx *sql.Tx, guildID string) (map
 
 func (s *Store) FindLink(ctx context.Context, tx *sql.Tx, guildID string, content string) (string, *Link, error) {
 	var linkName string
+	var rawConfig []byte
 	link := &Link{}
 
 	if err := tx.QueryRowContext(ctx, `
-		select link_name, owner_name, script_name
+		select link_name, owner_name, script_name, config
 		from guild_links
 		where guild_id = $1 and
 		      $2 ~* ('^' || regexp_replace(link_name, '\W', '\\\&', 'g') || '(?!\w)')
 		order by length(link_name) desc
 		limit 1
-	`, guildID, content).Scan(&linkName, &link.OwnerName, &link.ScriptName); err != nil {
+	`, guildID, content).Scan(&linkName, &link.OwnerName, &link.ScriptName, &rawConfig); err != nil {
 		if err == sql.ErrNoRows {
 			return "", nil, ErrNotFound
 		}
 		return "", nil, err
 	}
 
+	if err := json.Unmarshal(rawConfig, &link.Config); err != nil {
+		return "", nil, err
+	}
+
 	return linkName, link, nil
 }
 
 func (s *Store) GuildLink(ctx context.Context, tx *sql.Tx, guildID string, linkName string) (*Link, error) {
+	var rawConfig []byte
 	link := &Link{}
 
 	if err := tx.QueryRowContext(ctx, `
-		select owner_name, script_name
+		select owner_name, script_name, config
 		from guild_links
 		where guild_id = $1 and
 		      link_name = $2
-	`, guildID, linkName).Scan(&link.OwnerName, &link.ScriptName); err != nil {
+	`, guildID, linkName).Scan(&link.OwnerName, &link.ScriptName, &rawConfig); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
 		return nil, err
 	}
 
+	if err := json.Unmarshal(rawConfig, &link.Config); err != nil {
+		return nil, err
+	}
+
 	return link, nil
 }
 
@@ -190,13 +213,24 @@ func (s *Store) SetGuildLink(ctx context.Context, tx *sql.Tx, guildID string, li
 			      link_name = $2
 		`, guildID, linkName)
 	} else {
+		config := link.Config
+		if config == nil {
+			config = map[string]string{}
+		}
+
+		rawConfig, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+
 		r, err = tx.ExecContext(ctx, `
-			insert into guild_links (guild_id, link_name, owner_name, script_name)
-			values ($1, $2, $3, $4)
+			insert into guild_links (guild_id, link_name, owner_name, script_name, config)
+			values ($1, $2, $3, $4, $5)
 			on conflict (guild_id, link_name) do update
 			set owner_name = excluded.owner_name,
-			    script_name = excluded.script_name
-		`, guildID, linkName, link.OwnerName, link.ScriptName)
+			    script_name = excluded.script_name,
+			    config = excluded.config
+		`, guildID, linkName, link.OwnerName, link.ScriptName, rawConfig)
 	}
 
 	if err != nil {
@@ -218,6 +252,47 @@ func (s *Store) SetGuildLink(ctx context.Context, tx *sql.Tx, guildID string, li
 	return nil
 }
 
+// SetGuildLinkConfig sets a single configuration value on a link. An empty value removes the key.
+func (s *Store) SetGuildLinkConfig(ctx context.Context, tx *sql.Tx, guildID string, linkName string, key string, value string) error {
+	if key == "" || len(key) > maxLinkConfigKeyLength || len(value) > maxLinkConfigValueLength {
+		return ErrInvalid
+	}
+
+	link, err := s.GuildLink(ctx, tx, guildID, linkName)
+	if err != nil {
+		return err
+	}
+
+	if link.Config == nil {
+		link.Config = map[string]string{}
+	}
+
+	if value == "" {
+		delete(link.Config, key)
+	} else {
+		if _, ok := link.Config[key]; !ok && len(link.Config) >= maxLinkConfigEntries {
+			return ErrInvalid
+		}
+		link.Config[key] = value
+	}
+
+	rawConfig, err := json.Marshal(link.Config)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		update guild_links
+		set config = $1
+		where guild_id = $2 and
+		      link_name = $3
+	`, rawConfig, guildID, linkName); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (s *Store) Refcount(ctx context.Context, tx *sql.Tx, guildID string, ownerName string, scriptName string) (int, error) {
 	var count int
 	if err := tx.QueryRowContext(ctx, `
@@ -230,3 +305,83 @@ func (s *Store) Refcount(ctx context.Context, tx *sql.Tx, guildID string, ownerN
 	}
 	return count, nil
 }
+
+type EventBinding struct {
+	EventName  string
+	OwnerName  string
+	ScriptName string
+	ChannelID  string
+}
+
+// GuildEventBindings returns the scripts bound to an event in a guild. If eventName is empty, bindings for all events
+// are returned.
+func (s *Store) GuildEventBindings(ctx context.Context, tx *sql.Tx, guildID string, eventName string) ([]*EventBinding, error) {
+	bindings := make([]*EventBinding, 0)
+
+	rows, err := tx.QueryContext(ctx, `
+		select event_name, owner_name, script_name, channel_id
+		from guild_event_bindings
+		where guild_id = $1 and
+		      ($2 = '' or event_name = $2)
+		order by event_name, owner_name, script_name
+	`, guildID, eventName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		binding := &EventBinding{}
+
+		if err := rows.Scan(&binding.EventName, &binding.OwnerName, &binding.ScriptName, &binding.ChannelID); err != nil {
+			return nil, err
+		}
+
+		bindings = append(bindings, binding)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return bindings, nil
+}
+
+func (s *Store) SetGuildEventBinding(ctx context.Context, tx *sql.Tx, guildID string, binding *EventBinding) error {
+	if _, err := tx.ExecContext(ctx, `
+		insert into guild_event_bindings (guild_id, event_name, owner_name, script_name, channel_id)
+		values ($1, $2, $3, $4, $5)
+		on conflict (guild_id, event_name, owner_name, script_name) do update
+		set channel_id = excluded.channel_id
+	`, guildID, binding.EventName, binding.OwnerName, binding.ScriptName, binding.ChannelID); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "22001" /* string_data_right_truncation */ {
+			return ErrInvalid
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteGuildEventBinding(ctx context.Context, tx *sql.Tx, guildID string, eventName string, ownerName string, scriptName string) error {
+	r, err := tx.ExecContext(ctx, `
+		delete from guild_event_bindings
+		where guild_id = $1 and
+		      event_name = $2 and
+		      owner_name = $3 and
+		      script_name = $4
+	`, guildID, eventName, ownerName, scriptName)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package accounts
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+
+	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
+)
+
+var ErrInvalidScope = errors.New("accounts: invalid scope")
+
+// AccessTokenPrefix starts every access token, so they can be told apart from other bearer tokens.
+const AccessTokenPrefix = "k4pat_"
+
+const maxAccessTokenNameLength = 64
+
+func hashToken(token string) []byte {
+	h := sha256.Sum256([]byte(token))
+	return h[:]
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return encode(raw), nil
+}
+
+func scopeNames(scopes []accountspb.Scope) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		name, ok := accountspb.Scope_name[int32(scope)]
+		if !ok {
+			return nil, ErrInvalidScope
+		}
+		names[i] = name
+	}
+	return names, nil
+}
+
+func scopesFromNames(names []string) []accountspb.Scope {
+	scopes := make([]accountspb.Scope, 0, len(names))
+	for _, name := range names {
+		if scope, ok := accountspb.Scope_value[name]; ok {
+			scopes = append(scopes, accountspb.Scope(scope))
+		}
+	}
+	return scopes
+}
+
+// CreateAccessToken mints a named access token for the account. Only a hash of the token is stored, so the token itself
+// is only ever returned here. A zero expireTime means the token never expires.
+func (a *Account) CreateAccessToken(ctx context.Context, name string, scopes []accountspb.Scope, expireTime time.Time) (string, *accountspb.AccessToken, error) {
+	if name == "" || len(name) > maxAccessTokenNameLength {
+		return "", nil, ErrInvalidName
+	}
+
+	names, err := scopeNames(scopes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	id, err := randomString(8, hex.EncodeToString)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", nil, err
+	}
+	token := AccessTokenPrefix + secret
+
+	accessToken := &accountspb.AccessToken{
+		Id:    id,
+		Name:  name,
+		Scope: scopes,
+	}
+
+	var rawExpireTime pq.NullTime
+	if !expireTime.IsZero() {
+		rawExpireTime = pq.NullTime{Time: expireTime, Valid: true}
+		accessToken.ExpireTime = expireTime.Unix()
+	}
+
+	var createTime time.Time
+	if err := a.db.QueryRowContext(ctx, `
+		insert into access_tokens (token_id, account_name, token_name, token_hash, scopes, expire_time)
+		values ($1, $2, $3, $4, $5, $6)
+		returning create_time
+	`, id, a.Name, name, hashToken(token), pq.Array(names), rawExpireTime).Scan(&createTime); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" /* unique_violation */ {
+			return "", nil, ErrAlreadyExists
+		}
+		return "", nil, err
+	}
+	accessToken.CreateTime = createTime.Unix()
+
+	return token, accessToken, nil
+}
+
+func (a *Account) AccessTokens(ctx context.Context) ([]*accountspb.AccessToken, error) {
+	accessTokens := make([]*accountspb.AccessToken, 0)
+
+	rows, err := a.db.QueryContext(ctx, `
+		select token_id, token_name, scopes, create_time, expire_time, last_use_time
+		from access_tokens
+		where account_name = $1
+		order by create_time
+	`, a.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		accessToken := &accountspb.AccessToken{}
+		var names []string
+		var createTime time.Time
+		var expireTime, lastUseTime pq.NullTime
+		if err := rows.Scan(&accessToken.Id, &accessToken.Name, pq.Array(&names), &createTime, &expireTime, &lastUseTime); err != nil {
+			return nil, err
+		}
+
+		accessToken.Scope = scopesFromNames(names)
+		accessToken.CreateTime = createTime.Unix()
+		if expireTime.Valid {
+			accessToken.ExpireTime = expireTime.Time.Unix()
+		}
+		if lastUseTime.Valid {
+			accessToken.LastUseTime = lastUseTime.Time.Unix()
+		}
+
+		accessTokens = append(accessTokens, accessToken)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return accessTokens, nil
+}
+
+func (a *Account) RevokeAccessToken(ctx context.Context, id string) error {
+	res, err := a.db.ExecContext(ctx, `
+		delete from access_tokens
+		where account_name = $1 and
+		      token_id = $2
+	`, a.Name, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// AuthenticateAccessToken returns the name of the account an access token belongs to, along with the token's scopes.
+func (s *Store) AuthenticateAccessToken(ctx context.Context, token string) (string, []accountspb.Scope, error) {
+	if !strings.HasPrefix(token, AccessTokenPrefix) {
+		return "", nil, ErrUnauthenticated
+	}
+
+	var id string
+	var username string
+	var names []string
+	var expireTime pq.NullTime
+	var suspended bool
+	if err := s.db.QueryRowContext(ctx, `
+		select t.token_id, t.account_name, t.scopes, t.expire_time, a.suspended
+		from access_tokens t
+		join accounts a on a.name = t.account_name
+		where t.token_hash = $1
+	`, hashToken(token)).Scan(&id, &username, pq.Array(&names), &expireTime, &suspended); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil, ErrUnauthenticated
+		}
+		return "", nil, err
+	}
+
+	if expireTime.Valid && time.Now().After(expireTime.Time) {
+		return "", nil, ErrUnauthenticated
+	}
+
+	if suspended {
+		return "", nil, ErrSuspended
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		update access_tokens
+		set last_use_time = now()
+		where token_id = $1
+	`, id); err != nil {
+		return "", nil, err
+	}
+
+	return username, scopesFromNames(names), nil
+}
@@ -0,0 +1,189 @@
+package accounts
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+)
+
+func (a *Account) Identifiers(ctx context.Context) ([]string, error) {
+	identifiers := make([]string, 0)
+
+	rows, err := a.db.QueryContext(ctx, `
+		select identifier
+		from account_identifiers
+		where account_name = $1
+		order by identifier
+	`, a.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var identifier string
+		if err := rows.Scan(&identifier); err != nil {
+			return nil, err
+		}
+		identifiers = append(identifiers, identifier)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identifiers, nil
+}
+
+func addIdentifier(ctx context.Context, tx *sql.Tx, username string, identifier string) error {
+	if _, err := tx.ExecContext(ctx, `
+		insert into account_identifiers (account_name, identifier)
+		values ($1, $2)
+	`, username, identifier); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" /* unique_violation */ {
+			return ErrAlreadyExists
+		}
+		return err
+	}
+
+	return nil
+}
+
+// AddIdentifier links an identifier, e.g. discord/<user ID>, to the account without any verification. Users should
+// link identifiers with link codes instead.
+func (a *Account) AddIdentifier(ctx context.Context, identifier string) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := addIdentifier(ctx, tx, a.Name, identifier); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveIdentifier unlinks an identifier from the account. An account without a password must keep at least one
+// identifier, or it could never be logged into again.
+func (a *Account) RemoveIdentifier(ctx context.Context, identifier string) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		delete from account_identifiers
+		where account_name = $1 and identifier = $2
+	`, a.Name, identifier)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	var hasLogin bool
+	if err := tx.QueryRowContext(ctx, `
+		select password_hash != '' or exists (
+		           select 1
+		           from account_identifiers
+		           where account_name = $1
+		       )
+		from accounts
+		where name = $1
+	`, a.Name).Scan(&hasLogin); err != nil {
+		return err
+	}
+
+	if !hasLogin {
+		return ErrLastIdentifier
+	}
+
+	return tx.Commit()
+}
+
+var linkCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// CreateLinkCode creates a one-time code that links the identifier to whichever account redeems it. The caller is
+// responsible for only handing the code to the owner of the identifier.
+func (s *Store) CreateLinkCode(ctx context.Context, identifier string) (string, time.Time, error) {
+	rawCode := make([]byte, 5)
+	if _, err := rand.Read(rawCode); err != nil {
+		return "", time.Time{}, err
+	}
+	code := linkCodeEncoding.EncodeToString(rawCode)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		delete from account_link_codes
+		where identifier = $1 or
+		      expire_time < now()
+	`, identifier); err != nil {
+		return "", time.Time{}, err
+	}
+
+	expireTime := time.Now().Add(s.linkCodeTTL)
+
+	if _, err := tx.ExecContext(ctx, `
+		insert into account_link_codes (code, identifier, expire_time)
+		values ($1, $2, $3)
+	`, code, identifier, expireTime); err != nil {
+		return "", time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", time.Time{}, err
+	}
+
+	return code, expireTime, nil
+}
+
+// RedeemLinkCode consumes a link code, linking its identifier to the account. If the code does not exist or has
+// expired, ErrNotFound is returned.
+func (a *Account) RedeemLinkCode(ctx context.Context, code string) (string, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var identifier string
+	if err := tx.QueryRowContext(ctx, `
+		delete from account_link_codes
+		where code = $1 and
+		      expire_time >= now()
+		returning identifier
+	`, code).Scan(&identifier); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+
+	if err := addIdentifier(ctx, tx, a.Name, identifier); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return identifier, nil
+}
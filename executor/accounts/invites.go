@@ -0,0 +1,116 @@
+package accounts
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+)
+
+type InviteCode struct {
+	Code       string
+	CreateTime time.Time
+
+	// Zero if the code never expires.
+	ExpireTime time.Time
+}
+
+// CreateInviteCode creates a one-time code that allows an account to be registered. A zero expireTime means the code
+// never expires.
+func (s *Store) CreateInviteCode(ctx context.Context, expireTime time.Time) (string, error) {
+	code, err := randomString(10, linkCodeEncoding.EncodeToString)
+	if err != nil {
+		return "", err
+	}
+
+	var rawExpireTime pq.NullTime
+	if !expireTime.IsZero() {
+		rawExpireTime = pq.NullTime{Time: expireTime, Valid: true}
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		insert into invite_codes (code, expire_time)
+		values ($1, $2)
+	`, code, rawExpireTime); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+func (s *Store) InviteCodes(ctx context.Context) ([]*InviteCode, error) {
+	inviteCodes := make([]*InviteCode, 0)
+
+	rows, err := s.db.QueryContext(ctx, `
+		select code, create_time, expire_time
+		from invite_codes
+		where expire_time is null or
+		      expire_time >= now()
+		order by create_time
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		inviteCode := &InviteCode{}
+		var expireTime pq.NullTime
+		if err := rows.Scan(&inviteCode.Code, &inviteCode.CreateTime, &expireTime); err != nil {
+			return nil, err
+		}
+		inviteCode.ExpireTime = expireTime.Time
+		inviteCodes = append(inviteCodes, inviteCode)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return inviteCodes, nil
+}
+
+func (s *Store) DeleteInviteCode(ctx context.Context, code string) error {
+	res, err := s.db.ExecContext(ctx, `
+		delete from invite_codes
+		where code = $1
+	`, code)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// redeemInviteCode consumes an invite code as part of tx. If the code does not exist or has expired,
+// ErrInvalidInviteCode is returned.
+func redeemInviteCode(ctx context.Context, tx *sql.Tx, code string) error {
+	res, err := tx.ExecContext(ctx, `
+		delete from invite_codes
+		where code = $1 and
+		      (expire_time is null or expire_time >= now())
+	`, code)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrInvalidInviteCode
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package accounts
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+
+	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
+)
+
+// LoginPolicy controls how failed logins are throttled, and how long login history is kept for.
+type LoginPolicy struct {
+	// FreeFailures is how many failed logins are allowed before further logins are locked out.
+	FreeFailures int
+
+	// Backoff is how long the first lockout lasts. Each further failure doubles it, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// FailureWindow is how long after the last failed login failures are forgotten.
+	FailureWindow time.Duration
+
+	HistoryMaxAge time.Duration
+}
+
+func (p LoginPolicy) backoff(failureCount int) time.Duration {
+	backoff := p.Backoff
+	for i := p.FreeFailures + 1; i < failureCount && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+const maxLoginSourceLength = 64
+
+func accountThrottleKey(username string) string {
+	return "account/" + username
+}
+
+func sourceThrottleKey(source string) string {
+	return "source/" + source
+}
+
+func (s *Store) lockedUntil(ctx context.Context, keys []string) (time.Time, error) {
+	var lockedUntil pq.NullTime
+	if err := s.db.QueryRowContext(ctx, `
+		select max(locked_until)
+		from login_failures
+		where throttle_key = any($1)
+	`, pq.Array(keys)).Scan(&lockedUntil); err != nil {
+		return time.Time{}, err
+	}
+
+	return lockedUntil.Time, nil
+}
+
+// recordFailure counts a failed login against each of keys, locking them out once they have failed too often.
+func (s *Store) recordFailure(ctx context.Context, keys []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	windowStart := time.Now().Add(-s.loginPolicy.FailureWindow)
+
+	if _, err := tx.ExecContext(ctx, `
+		delete from login_failures
+		where last_failure_time < $1 and
+		      (locked_until is null or locked_until < now())
+	`, windowStart); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		var failureCount int
+		if err := tx.QueryRowContext(ctx, `
+			insert into login_failures (throttle_key, failure_count, last_failure_time)
+			values ($1, 1, now())
+			on conflict (throttle_key) do update
+			set failure_count = case
+			        when login_failures.last_failure_time < $2 then 1
+			        else login_failures.failure_count + 1
+			    end,
+			    last_failure_time = now()
+			returning failure_count
+		`, key, windowStart).Scan(&failureCount); err != nil {
+			return err
+		}
+
+		if failureCount <= s.loginPolicy.FreeFailures {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			update login_failures
+			set locked_until = $1
+			where throttle_key = $2
+		`, time.Now().Add(s.loginPolicy.backoff(failureCount)), key); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) clearFailures(ctx context.Context, key string) error {
+	if _, err := s.db.ExecContext(ctx, `
+		delete from login_failures
+		where throttle_key = $1
+	`, key); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// recordAttempt adds a login attempt to the account's history. Successful logins from the same source and method are
+// only recorded once an hour, as WebDAV clients log in on every request.
+func (s *Store) recordAttempt(ctx context.Context, username string, source string, method string, success bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		delete from login_attempts
+		where account_name = $1 and
+		      attempt_time < $2
+	`, username, time.Now().Add(-s.loginPolicy.HistoryMaxAge)); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		insert into login_attempts (account_name, source, method, success)
+		select $1, $2, $3, $4
+		where not $4 or not exists (
+		    select 1
+		    from login_attempts
+		    where account_name = $1 and
+		          source = $2 and
+		          method = $3 and
+		          success and
+		          attempt_time > now() - interval '1 hour'
+		)
+	`, username, source, method, success); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Login checks the password of the named account, as attempted from source (e.g. an IP address) by method (e.g.
+// userpass or webdav). Failures are throttled both per account and per source: once either has failed too often, every
+// login for it fails with ErrThrottled until its lockout has passed. Attempts on existing accounts are recorded in their
+// login history.
+func (s *Store) Login(ctx context.Context, username string, password string, source string, method string) (*Account, error) {
+	if !nameRegexp.MatchString(username) {
+		return nil, ErrNotFound
+	}
+
+	if len(source) > maxLoginSourceLength {
+		source = source[:maxLoginSourceLength]
+	}
+
+	keys := []string{accountThrottleKey(username)}
+	if source != "" {
+		keys = append(keys, sourceThrottleKey(source))
+	}
+
+	lockedUntil, err := s.lockedUntil(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().Before(lockedUntil) {
+		return nil, ErrThrottled
+	}
+
+	account, err := s.Account(ctx, username)
+	if err != nil {
+		if err == ErrNotFound {
+			if err := s.recordFailure(ctx, keys); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	authErr := account.Authenticate(ctx, password)
+	switch authErr {
+	case nil:
+		if err := s.clearFailures(ctx, accountThrottleKey(username)); err != nil {
+			return nil, err
+		}
+	case ErrUnauthenticated:
+		if err := s.recordFailure(ctx, keys); err != nil {
+			return nil, err
+		}
+	case ErrSuspended:
+	default:
+		return nil, authErr
+	}
+
+	if err := s.recordAttempt(ctx, username, source, method, authErr == nil); err != nil {
+		return nil, err
+	}
+
+	if authErr != nil {
+		return nil, authErr
+	}
+
+	return account, nil
+}
+
+// LoginAttempts returns the account's login history, most recent first.
+func (a *Account) LoginAttempts(ctx context.Context, offset, limit uint32) ([]*accountspb.LoginAttempt, error) {
+	attempts := make([]*accountspb.LoginAttempt, 0)
+
+	rows, err := a.db.QueryContext(ctx, `
+		select attempt_time, source, method, success
+		from login_attempts
+		where account_name = $1
+		order by attempt_time desc
+		offset $2 limit $3
+	`, a.Name, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		attempt := &accountspb.LoginAttempt{}
+		var attemptTime time.Time
+		if err := rows.Scan(&attemptTime, &attempt.Source, &attempt.Method, &attempt.Success); err != nil {
+			return nil, err
+		}
+		attempt.Time = attemptTime.Unix()
+		attempts = append(attempts, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,176 @@
+package accounts
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+)
+
+// CreateSession starts a login session for the account, returning its ID and a refresh token that can be exchanged
+// for new login tokens until expireTime. Only a hash of the refresh token is stored.
+func (a *Account) CreateSession(ctx context.Context, expireTime time.Time) (string, string, error) {
+	id, err := randomString(8, hex.EncodeToString)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := a.db.ExecContext(ctx, `
+		insert into sessions (session_id, account_name, refresh_token_hash, expire_time)
+		values ($1, $2, $3, $4)
+	`, id, a.Name, hashToken(refreshToken), expireTime); err != nil {
+		return "", "", err
+	}
+
+	return id, refreshToken, nil
+}
+
+// RefreshSession exchanges a refresh token for a new one, extending the session until expireTime. The old refresh
+// token cannot be used again. It returns the name of the session's account, along with the session ID.
+func (s *Store) RefreshSession(ctx context.Context, refreshToken string, expireTime time.Time) (string, string, string, error) {
+	newRefreshToken, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	var id string
+	var username string
+	var suspended bool
+	if err := s.db.QueryRowContext(ctx, `
+		update sessions s
+		set refresh_token_hash = $1,
+		    expire_time = $2
+		from accounts a
+		where a.name = s.account_name and
+		      s.refresh_token_hash = $3 and
+		      s.expire_time >= now()
+		returning s.session_id, s.account_name, a.suspended
+	`, hashToken(newRefreshToken), expireTime, hashToken(refreshToken)).Scan(&id, &username, &suspended); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", "", ErrUnauthenticated
+		}
+		return "", "", "", err
+	}
+
+	if suspended {
+		return "", "", "", ErrSuspended
+	}
+
+	return username, id, newRefreshToken, nil
+}
+
+// DeleteSession ends the session a refresh token belongs to.
+func (s *Store) DeleteSession(ctx context.Context, refreshToken string) error {
+	if _, err := s.db.ExecContext(ctx, `
+		delete from sessions
+		where refresh_token_hash = $1
+	`, hashToken(refreshToken)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeToken marks a login token as revoked until it would have expired anyway.
+func (s *Store) RevokeToken(ctx context.Context, tokenID string, expireTime time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		delete from revoked_tokens
+		where expire_time < now()
+	`); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		insert into revoked_tokens (token_id, expire_time)
+		values ($1, $2)
+		on conflict (token_id) do nothing
+	`, tokenID, expireTime); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CheckToken checks that a login token issued to the account at issueTime has not been revoked, either by itself or
+// by all of the account's tokens being invalidated since, e.g. by a password change.
+func (a *Account) CheckToken(ctx context.Context, tokenID string, issueTime time.Time) error {
+	var tokensValidAfter pq.NullTime
+	var suspended bool
+	var revoked bool
+	if err := a.db.QueryRowContext(ctx, `
+		select tokens_valid_after,
+		       suspended,
+		       exists (
+		           select 1
+		           from revoked_tokens
+		           where token_id = $2
+		       )
+		from accounts
+		where name = $1
+	`, a.Name, tokenID).Scan(&tokensValidAfter, &suspended, &revoked); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if revoked || (tokensValidAfter.Valid && issueTime.Before(tokensValidAfter.Time)) {
+		return ErrUnauthenticated
+	}
+
+	if suspended {
+		return ErrSuspended
+	}
+
+	return nil
+}
+
+// InvalidateTokens revokes every login token issued to the account so far and ends all of its sessions.
+func (a *Account) InvalidateTokens(ctx context.Context) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := invalidateTokens(ctx, tx, a.Name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func invalidateTokens(ctx context.Context, tx *sql.Tx, username string) error {
+	// Token issue times only have whole-second precision, so tokens are only valid from the next second on: a token
+	// issued in the same second as this may have been issued before it.
+	if _, err := tx.ExecContext(ctx, `
+		update accounts
+		set tokens_valid_after = date_trunc('second', now()) + interval '1 second'
+		where name = $1
+	`, username); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		delete from sessions
+		where account_name = $1
+	`, username); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"syscall"
+	"time"
 
 	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
@@ -19,27 +20,37 @@ import (
 var nameRegexp = regexp.MustCompile(`^[a-z0-9_-]{1,20}$`)
 
 var (
-	ErrNotFound        error = errors.New("accounts: not found")
-	ErrInvalidName           = errors.New("accounts: invalid name")
-	ErrAlreadyExists         = errors.New("accounts: already exists")
-	ErrUnauthenticated       = errors.New("accounts: unauthenticated")
+	ErrNotFound          error = errors.New("accounts: not found")
+	ErrInvalidName             = errors.New("accounts: invalid name")
+	ErrAlreadyExists           = errors.New("accounts: already exists")
+	ErrUnauthenticated         = errors.New("accounts: unauthenticated")
+	ErrSuspended               = errors.New("accounts: suspended")
+	ErrInvalidField            = errors.New("accounts: invalid field")
+	ErrProfileInUse            = errors.New("accounts: profile in use")
+	ErrLastIdentifier          = errors.New("accounts: last identifier")
+	ErrThrottled               = errors.New("accounts: throttled")
+	ErrInvalidInviteCode       = errors.New("accounts: invalid invite code")
 )
 
 type Store struct {
 	db              *sql.DB
 	storageRootPath string
 	makestoragePath string
+	linkCodeTTL     time.Duration
+	loginPolicy     LoginPolicy
 }
 
 func (s *Store) StorageRootPath() string {
 	return s.storageRootPath
 }
 
-func NewStore(db *sql.DB, storageRootPath string, makestoragePath string) *Store {
+func NewStore(db *sql.DB, storageRootPath string, makestoragePath string, linkCodeTTL time.Duration, loginPolicy LoginPolicy) *Store {
 	return &Store{
 		db:              db,
 		storageRootPath: storageRootPath,
 		makestoragePath: makestoragePath,
+		linkCodeTTL:     linkCodeTTL,
+		loginPolicy:     loginPolicy,
 	}
 }
 
@@ -60,36 +71,31 @@ func getStorageUsage(path string) (*accountspb.StorageUsage, error) {
 	}, nil
 }
 
-func (a *Account) Traits(ctx context.Context) (*accountspb.Traits, error) {
-	traits := &accountspb.Traits{}
-
+func (a *Account) Suspended(ctx context.Context) (bool, error) {
+	var suspended bool
 	if err := a.db.QueryRowContext(ctx, `
-		select time_limit_seconds,
-		       memory_limit,
-		       tmpfs_size,
-		       allow_network_access,
-		       blkio_weight,
-		       cpu_shares,
-		       allowed_services,
-		       allowed_output_formats,
-		       max_messages_per_invocation
+		select suspended
 		from accounts
 		where name = $1
-	`, a.Name).Scan(
-		&traits.TimeLimitSeconds,
-		&traits.MemoryLimit,
-		&traits.TmpfsSize,
-		&traits.AllowNetworkAccess,
-		&traits.BlkioWeight,
-		&traits.CpuShares,
-		pq.Array(&traits.AllowedService),
-		pq.Array(&traits.AllowedOutputFormat),
-		&traits.MaxMessagesPerInvocation,
-	); err != nil {
-		return nil, err
+	`, a.Name).Scan(&suspended); err != nil {
+		return false, err
 	}
 
-	return traits, nil
+	return suspended, nil
+}
+
+// SetSuspended suspends or unsuspends the account. Scripts owned by a suspended account cannot be run, and the account
+// cannot be logged into.
+func (a *Account) SetSuspended(ctx context.Context, suspended bool) error {
+	if _, err := a.db.ExecContext(ctx, `
+		update accounts
+		set suspended = $1
+		where name = $2
+	`, suspended, a.Name); err != nil {
+		return err
+	}
+
+	return nil
 }
 
 func (a *Account) StoragePath() string {
@@ -114,30 +120,42 @@ func (a *Account) PrivateStorageUsage() (*accountspb.StorageUsage, error) {
 
 func (a *Account) Authenticate(ctx context.Context, password string) error {
 	var pwhash string
+	var suspended bool
 	if err := a.db.QueryRowContext(ctx, `
-		select password_hash
+		select password_hash, suspended
 		from accounts
 		where name = $1
-	`, a.Name).Scan(&pwhash); err != nil {
+	`, a.Name).Scan(&pwhash, &suspended); err != nil {
 		return err
 	}
 
+	// Any error is treated as a mismatch: accounts without a password have an empty hash, which bcrypt rejects as too
+	// short rather than mismatched.
 	if err := bcrypt.CompareHashAndPassword([]byte(pwhash), []byte(password)); err != nil {
-		if err == bcrypt.ErrMismatchedHashAndPassword {
-			return ErrUnauthenticated
-		}
+		return ErrUnauthenticated
+	}
+
+	if suspended {
+		return ErrSuspended
 	}
 
 	return nil
 }
 
+// SetPassword changes the account's password. Every login token issued so far is revoked.
 func (a *Account) SetPassword(ctx context.Context, password string) error {
 	pwhash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
 
-	if _, err := a.db.ExecContext(ctx, `
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
 		update accounts
 		set password_hash = $1
 		where name = $2
@@ -145,7 +163,11 @@ func (a *Account) SetPassword(ctx context.Context, password string) error {
 		return err
 	}
 
-	return nil
+	if err := invalidateTokens(ctx, tx, a.Name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (s *Store) destroyStorage(username string) error {
@@ -162,7 +184,8 @@ func (s *Store) makeStorage(username string) error {
 	return cmd.Run()
 }
 
-func (s *Store) Create(ctx context.Context, username string, password string, identifiers []string) error {
+// Create creates an account. If inviteCode is not empty, it is redeemed along with the account's creation.
+func (s *Store) Create(ctx context.Context, username string, password string, identifiers []string, inviteCode string) error {
 	if !nameRegexp.MatchString(username) {
 		return ErrInvalidName
 	}
@@ -173,6 +196,12 @@ func (s *Store) Create(ctx context.Context, username string, password string, id
 	}
 	defer tx.Rollback()
 
+	if inviteCode != "" {
+		if err := redeemInviteCode(ctx, tx, inviteCode); err != nil {
+			return err
+		}
+	}
+
 	pwhash := []byte{}
 	if password != "" {
 		pwhash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -181,9 +210,11 @@ func (s *Store) Create(ctx context.Context, username string, password string, id
 		}
 	}
 
+	// Login tokens are only valid from the account's creation, so tokens issued to a deleted account of the same name
+	// are not accepted.
 	if _, err := tx.ExecContext(ctx, `
-		insert into accounts (name, password_hash)
-		values ($1, $2)
+		insert into accounts (name, password_hash, tokens_valid_after)
+		values ($1, $2, date_trunc('second', now()))
 	`, username, string(pwhash)); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" /* unique_violation */ {
 			return ErrAlreadyExists
@@ -192,13 +223,7 @@ func (s *Store) Create(ctx context.Context, username string, password string, id
 	}
 
 	for _, identifier := range identifiers {
-		if _, err := tx.ExecContext(ctx, `
-			insert into account_identifiers (account_name, identifier)
-			values ($1, $2)
-		`, username, identifier); err != nil {
-			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" /* unique_violation */ {
-				return ErrAlreadyExists
-			}
+		if err := addIdentifier(ctx, tx, username, identifier); err != nil {
 			return err
 		}
 	}
@@ -214,8 +239,62 @@ func (s *Store) Create(ctx context.Context, username string, password string, id
 		return err
 	}
 
-	tx.Commit()
-	return nil
+	return tx.Commit()
+}
+
+// Delete removes an account along with all of its scripts and storage. Storage is only destroyed once the account's
+// deletion has committed; if destroying it fails, the error is returned, and the storage is cleared if the name is used
+// again.
+func (s *Store) Delete(ctx context.Context, username string) error {
+	if !nameRegexp.MatchString(username) {
+		return ErrInvalidName
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Revisions, executions and schedules are deleted along with the scripts.
+	if _, err := tx.ExecContext(ctx, `
+		delete from scripts
+		where owner_name = $1
+	`, username); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		delete from accounts
+		where name = $1
+	`, username)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	// Login failures are not tied to the account row, so a new account with the same name would otherwise inherit its
+	// lockout.
+	if _, err := tx.ExecContext(ctx, `
+		delete from login_failures
+		where throttle_key = $1
+	`, accountThrottleKey(username)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return s.destroyStorage(username)
 }
 
 func (s *Store) Account(ctx context.Context, name string) (*Account, error) {
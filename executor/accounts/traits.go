@@ -0,0 +1,281 @@
+package accounts
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+
+	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
+)
+
+// DefaultTraitProfile is the profile new accounts are assigned to. It cannot be deleted.
+const DefaultTraitProfile = "default"
+
+// traitColumns maps the names of Traits fields to their columns, along with how to get their values.
+var traitColumns = map[string]struct {
+	column string
+	value  func(traits *accountspb.Traits) interface{}
+}{
+	"time_limit_seconds":          {"time_limit_seconds", func(t *accountspb.Traits) interface{} { return t.TimeLimitSeconds }},
+	"memory_limit":                {"memory_limit", func(t *accountspb.Traits) interface{} { return t.MemoryLimit }},
+	"tmpfs_size":                  {"tmpfs_size", func(t *accountspb.Traits) interface{} { return t.TmpfsSize }},
+	"allow_network_access":        {"allow_network_access", func(t *accountspb.Traits) interface{} { return t.AllowNetworkAccess }},
+	"blkio_weight":                {"blkio_weight", func(t *accountspb.Traits) interface{} { return t.BlkioWeight }},
+	"cpu_shares":                  {"cpu_shares", func(t *accountspb.Traits) interface{} { return t.CpuShares }},
+	"max_messages_per_invocation": {"max_messages_per_invocation", func(t *accountspb.Traits) interface{} { return t.MaxMessagesPerInvocation }},
+	"max_concurrent_executions":   {"max_concurrent_executions", func(t *accountspb.Traits) interface{} { return t.MaxConcurrentExecutions }},
+	"allowed_output_format":       {"allowed_output_formats", func(t *accountspb.Traits) interface{} { return pq.Array(t.AllowedOutputFormat) }},
+	"allowed_service":             {"allowed_services", func(t *accountspb.Traits) interface{} { return pq.Array(t.AllowedService) }},
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func scanTraits(row *sql.Row) (*accountspb.Traits, error) {
+	traits := &accountspb.Traits{}
+
+	if err := row.Scan(
+		&traits.TimeLimitSeconds,
+		&traits.MemoryLimit,
+		&traits.TmpfsSize,
+		&traits.AllowNetworkAccess,
+		&traits.BlkioWeight,
+		&traits.CpuShares,
+		pq.Array(&traits.AllowedService),
+		pq.Array(&traits.AllowedOutputFormat),
+		&traits.MaxMessagesPerInvocation,
+		&traits.MaxConcurrentExecutions,
+	); err != nil {
+		return nil, err
+	}
+
+	return traits, nil
+}
+
+// setTraitColumns sets the named trait fields of the row of table with the given name, returning the number of rows
+// updated. If traits is nil, the fields are set to null instead.
+func setTraitColumns(ctx context.Context, db execer, table string, name string, traits *accountspb.Traits, fields []string) (int64, error) {
+	if len(fields) == 0 {
+		return 0, ErrInvalidField
+	}
+
+	assignments := make([]string, len(fields))
+	args := make([]interface{}, 0, len(fields)+1)
+
+	for i, field := range fields {
+		traitColumn, ok := traitColumns[field]
+		if !ok {
+			return 0, ErrInvalidField
+		}
+
+		if traits == nil {
+			assignments[i] = fmt.Sprintf("%s = null", traitColumn.column)
+			continue
+		}
+
+		args = append(args, traitColumn.value(traits))
+		assignments[i] = fmt.Sprintf("%s = $%d", traitColumn.column, len(args))
+	}
+	args = append(args, name)
+
+	res, err := db.ExecContext(ctx, fmt.Sprintf(`
+		update %s
+		set %s
+		where name = $%d
+	`, table, strings.Join(assignments, ", "), len(args)), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Traits returns the account's traits: those of its profile, with any of the account's own overrides on top.
+func (a *Account) Traits(ctx context.Context) (*accountspb.Traits, error) {
+	return scanTraits(a.db.QueryRowContext(ctx, `
+		select coalesce(a.time_limit_seconds, p.time_limit_seconds),
+		       coalesce(a.memory_limit, p.memory_limit),
+		       coalesce(a.tmpfs_size, p.tmpfs_size),
+		       coalesce(a.allow_network_access, p.allow_network_access),
+		       coalesce(a.blkio_weight, p.blkio_weight),
+		       coalesce(a.cpu_shares, p.cpu_shares),
+		       coalesce(a.allowed_services, p.allowed_services),
+		       coalesce(a.allowed_output_formats, p.allowed_output_formats),
+		       coalesce(a.max_messages_per_invocation, p.max_messages_per_invocation),
+		       coalesce(a.max_concurrent_executions, p.max_concurrent_executions)
+		from accounts a
+		join trait_profiles p on p.name = a.trait_profile
+		where a.name = $1
+	`, a.Name))
+}
+
+// SetTraits overrides the named fields of the account's traits with their values in traits. Fields are named as in the
+// Traits message.
+func (a *Account) SetTraits(ctx context.Context, traits *accountspb.Traits, fields []string) error {
+	_, err := setTraitColumns(ctx, a.db, "accounts", a.Name, traits, fields)
+	return err
+}
+
+// ClearTraits removes the account's overrides of the named fields, so they are taken from its profile again.
+func (a *Account) ClearTraits(ctx context.Context, fields []string) error {
+	_, err := setTraitColumns(ctx, a.db, "accounts", a.Name, nil, fields)
+	return err
+}
+
+func (a *Account) TraitProfile(ctx context.Context) (string, error) {
+	var profileName string
+	if err := a.db.QueryRowContext(ctx, `
+		select trait_profile
+		from accounts
+		where name = $1
+	`, a.Name).Scan(&profileName); err != nil {
+		return "", err
+	}
+
+	return profileName, nil
+}
+
+func (a *Account) SetTraitProfile(ctx context.Context, profileName string) error {
+	if _, err := a.db.ExecContext(ctx, `
+		update accounts
+		set trait_profile = $1
+		where name = $2
+	`, profileName, a.Name); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" /* foreign_key_violation */ {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) TraitProfile(ctx context.Context, name string) (*accountspb.Traits, error) {
+	traits, err := scanTraits(s.db.QueryRowContext(ctx, `
+		select time_limit_seconds,
+		       memory_limit,
+		       tmpfs_size,
+		       allow_network_access,
+		       blkio_weight,
+		       cpu_shares,
+		       allowed_services,
+		       allowed_output_formats,
+		       max_messages_per_invocation,
+		       max_concurrent_executions
+		from trait_profiles
+		where name = $1
+	`, name))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return traits, nil
+}
+
+func (s *Store) TraitProfiles(ctx context.Context) ([]string, error) {
+	names := make([]string, 0)
+
+	rows, err := s.db.QueryContext(ctx, `
+		select name
+		from trait_profiles
+		order by name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// CreateTraitProfile creates a profile. Fields not given are set to the defaults.
+func (s *Store) CreateTraitProfile(ctx context.Context, name string, traits *accountspb.Traits, fields []string) error {
+	if !nameRegexp.MatchString(name) {
+		return ErrInvalidName
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		insert into trait_profiles (name)
+		values ($1)
+	`, name); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" /* unique_violation */ {
+			return ErrAlreadyExists
+		}
+		return err
+	}
+
+	if len(fields) > 0 {
+		if _, err := setTraitColumns(ctx, tx, "trait_profiles", name, traits, fields); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UpdateTraitProfile sets the named fields of a profile, which applies to every account using it that does not
+// override them.
+func (s *Store) UpdateTraitProfile(ctx context.Context, name string, traits *accountspb.Traits, fields []string) error {
+	n, err := setTraitColumns(ctx, s.db, "trait_profiles", name, traits, fields)
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteTraitProfile(ctx context.Context, name string) error {
+	if name == DefaultTraitProfile {
+		return ErrProfileInUse
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		delete from trait_profiles
+		where name = $1
+	`, name)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" /* foreign_key_violation */ {
+			return ErrProfileInUse
+		}
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
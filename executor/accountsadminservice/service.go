@@ -0,0 +1,290 @@
+package accountsadminservice
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/porpoises/kobun4/executor/accounts"
+
+	pb "github.com/porpoises/kobun4/executor/accountsadminservice/v1pb"
+)
+
+type Service struct {
+	accounts *accounts.Store
+}
+
+func New(accounts *accounts.Store) *Service {
+	return &Service{
+		accounts: accounts,
+	}
+}
+
+func (s *Service) account(ctx context.Context, username string) (*accounts.Account, error) {
+	account, err := s.accounts.Account(ctx, username)
+	if err != nil {
+		if err == accounts.ErrNotFound {
+			return nil, grpc.Errorf(codes.NotFound, "account not found")
+		}
+		glog.Errorf("Failed to load account: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load account")
+	}
+	return account, nil
+}
+
+func (s *Service) SetTraits(ctx context.Context, req *pb.SetTraitsRequest) (*pb.SetTraitsResponse, error) {
+	if req.Traits == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "traits must be set")
+	}
+
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := account.SetTraits(ctx, req.Traits, req.Field); err != nil {
+		if err == accounts.ErrInvalidField {
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid trait field")
+		}
+		glog.Errorf("Failed to set traits: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to set traits")
+	}
+
+	glog.Infof("Set traits %v of %s: %s", req.Field, req.Username, req.Traits)
+
+	return &pb.SetTraitsResponse{}, nil
+}
+
+func (s *Service) ClearTraits(ctx context.Context, req *pb.ClearTraitsRequest) (*pb.ClearTraitsResponse, error) {
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := account.ClearTraits(ctx, req.Field); err != nil {
+		if err == accounts.ErrInvalidField {
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid trait field")
+		}
+		glog.Errorf("Failed to clear traits: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to clear traits")
+	}
+
+	glog.Infof("Cleared traits %v of %s", req.Field, req.Username)
+
+	return &pb.ClearTraitsResponse{}, nil
+}
+
+func (s *Service) SetTraitProfile(ctx context.Context, req *pb.SetTraitProfileRequest) (*pb.SetTraitProfileResponse, error) {
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := account.SetTraitProfile(ctx, req.ProfileName); err != nil {
+		if err == accounts.ErrNotFound {
+			return nil, grpc.Errorf(codes.NotFound, "trait profile not found")
+		}
+		glog.Errorf("Failed to set trait profile: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to set trait profile")
+	}
+
+	glog.Infof("Set trait profile of %s: %s", req.Username, req.ProfileName)
+
+	return &pb.SetTraitProfileResponse{}, nil
+}
+
+func (s *Service) CreateTraitProfile(ctx context.Context, req *pb.CreateTraitProfileRequest) (*pb.CreateTraitProfileResponse, error) {
+	if req.Traits == nil && len(req.Field) > 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "traits must be set")
+	}
+
+	if err := s.accounts.CreateTraitProfile(ctx, req.Name, req.Traits, req.Field); err != nil {
+		switch err {
+		case accounts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid trait profile name")
+		case accounts.ErrInvalidField:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid trait field")
+		case accounts.ErrAlreadyExists:
+			return nil, grpc.Errorf(codes.AlreadyExists, "trait profile already exists")
+		}
+		glog.Errorf("Failed to create trait profile: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to create trait profile")
+	}
+
+	glog.Infof("Created trait profile %s with traits %v: %s", req.Name, req.Field, req.Traits)
+
+	return &pb.CreateTraitProfileResponse{}, nil
+}
+
+func (s *Service) UpdateTraitProfile(ctx context.Context, req *pb.UpdateTraitProfileRequest) (*pb.UpdateTraitProfileResponse, error) {
+	if req.Traits == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "traits must be set")
+	}
+
+	if err := s.accounts.UpdateTraitProfile(ctx, req.Name, req.Traits, req.Field); err != nil {
+		switch err {
+		case accounts.ErrInvalidField:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid trait field")
+		case accounts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "trait profile not found")
+		}
+		glog.Errorf("Failed to update trait profile: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to update trait profile")
+	}
+
+	glog.Infof("Updated traits %v of trait profile %s: %s", req.Field, req.Name, req.Traits)
+
+	return &pb.UpdateTraitProfileResponse{}, nil
+}
+
+func (s *Service) GetTraitProfile(ctx context.Context, req *pb.GetTraitProfileRequest) (*pb.GetTraitProfileResponse, error) {
+	traits, err := s.accounts.TraitProfile(ctx, req.Name)
+	if err != nil {
+		if err == accounts.ErrNotFound {
+			return nil, grpc.Errorf(codes.NotFound, "trait profile not found")
+		}
+		glog.Errorf("Failed to get trait profile: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to get trait profile")
+	}
+
+	return &pb.GetTraitProfileResponse{
+		Traits: traits,
+	}, nil
+}
+
+func (s *Service) ListTraitProfiles(ctx context.Context, req *pb.ListTraitProfilesRequest) (*pb.ListTraitProfilesResponse, error) {
+	names, err := s.accounts.TraitProfiles(ctx)
+	if err != nil {
+		glog.Errorf("Failed to list trait profiles: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to list trait profiles")
+	}
+
+	return &pb.ListTraitProfilesResponse{
+		Name: names,
+	}, nil
+}
+
+func (s *Service) DeleteTraitProfile(ctx context.Context, req *pb.DeleteTraitProfileRequest) (*pb.DeleteTraitProfileResponse, error) {
+	if err := s.accounts.DeleteTraitProfile(ctx, req.Name); err != nil {
+		switch err {
+		case accounts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "trait profile not found")
+		case accounts.ErrProfileInUse:
+			return nil, grpc.Errorf(codes.FailedPrecondition, "trait profile in use")
+		}
+		glog.Errorf("Failed to delete trait profile: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to delete trait profile")
+	}
+
+	glog.Infof("Deleted trait profile: %s", req.Name)
+
+	return &pb.DeleteTraitProfileResponse{}, nil
+}
+
+func (s *Service) Suspend(ctx context.Context, req *pb.SuspendRequest) (*pb.SuspendResponse, error) {
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := account.SetSuspended(ctx, true); err != nil {
+		glog.Errorf("Failed to suspend account: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to suspend account")
+	}
+
+	glog.Infof("Suspended account: %s", req.Username)
+
+	return &pb.SuspendResponse{}, nil
+}
+
+func (s *Service) Unsuspend(ctx context.Context, req *pb.UnsuspendRequest) (*pb.UnsuspendResponse, error) {
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := account.SetSuspended(ctx, false); err != nil {
+		glog.Errorf("Failed to unsuspend account: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to unsuspend account")
+	}
+
+	glog.Infof("Unsuspended account: %s", req.Username)
+
+	return &pb.UnsuspendResponse{}, nil
+}
+
+func (s *Service) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	if err := s.accounts.Delete(ctx, req.Username); err != nil {
+		switch err {
+		case accounts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid account name")
+		case accounts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "account not found")
+		}
+		glog.Errorf("Failed to delete account: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to delete account")
+	}
+
+	glog.Infof("Deleted account: %s", req.Username)
+
+	return &pb.DeleteResponse{}, nil
+}
+
+func (s *Service) CreateInviteCode(ctx context.Context, req *pb.CreateInviteCodeRequest) (*pb.CreateInviteCodeResponse, error) {
+	var expireTime time.Time
+	if req.ExpireTime != 0 {
+		expireTime = time.Unix(req.ExpireTime, 0)
+	}
+
+	code, err := s.accounts.CreateInviteCode(ctx, expireTime)
+	if err != nil {
+		glog.Errorf("Failed to create invite code: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to create invite code")
+	}
+
+	glog.Infof("Created invite code: %s", code)
+
+	return &pb.CreateInviteCodeResponse{
+		Code: code,
+	}, nil
+}
+
+func (s *Service) ListInviteCodes(ctx context.Context, req *pb.ListInviteCodesRequest) (*pb.ListInviteCodesResponse, error) {
+	inviteCodes, err := s.accounts.InviteCodes(ctx)
+	if err != nil {
+		glog.Errorf("Failed to list invite codes: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to list invite codes")
+	}
+
+	resp := &pb.ListInviteCodesResponse{
+		InviteCode: make([]*pb.InviteCode, len(inviteCodes)),
+	}
+	for i, inviteCode := range inviteCodes {
+		resp.InviteCode[i] = &pb.InviteCode{
+			Code:       inviteCode.Code,
+			CreateTime: inviteCode.CreateTime.Unix(),
+		}
+		if !inviteCode.ExpireTime.IsZero() {
+			resp.InviteCode[i].ExpireTime = inviteCode.ExpireTime.Unix()
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *Service) DeleteInviteCode(ctx context.Context, req *pb.DeleteInviteCodeRequest) (*pb.DeleteInviteCodeResponse, error) {
+	if err := s.accounts.DeleteInviteCode(ctx, req.Code); err != nil {
+		if err == accounts.ErrNotFound {
+			return nil, grpc.Errorf(codes.NotFound, "invite code not found")
+		}
+		glog.Errorf("Failed to delete invite code: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to delete invite code")
+	}
+
+	glog.Infof("Deleted invite code: %s", req.Code)
+
+	return &pb.DeleteInviteCodeResponse{}, nil
+}
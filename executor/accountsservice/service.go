@@ -1,6 +1,9 @@
 package accountsservice
 
 import (
+	"strings"
+	"time"
+
 	"github.com/golang/glog"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -21,13 +24,27 @@ func New(accounts *accounts.Store) *Service {
 	}
 }
 
+func (s *Service) account(ctx context.Context, username string) (*accounts.Account, error) {
+	account, err := s.accounts.Account(ctx, username)
+	if err != nil {
+		if err == accounts.ErrNotFound {
+			return nil, grpc.Errorf(codes.NotFound, "account not found")
+		}
+		glog.Errorf("Failed to load account: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load account")
+	}
+	return account, nil
+}
+
 func (s *Service) Create(ctx context.Context, req *pb.CreateRequest) (*pb.CreateResponse, error) {
-	if err := s.accounts.Create(ctx, req.Username, req.Password, req.Identifier); err != nil {
+	if err := s.accounts.Create(ctx, req.Username, req.Password, req.Identifier, req.InviteCode); err != nil {
 		switch err {
 		case accounts.ErrInvalidName:
 			return nil, grpc.Errorf(codes.InvalidArgument, "invalid account name")
 		case accounts.ErrAlreadyExists:
 			return nil, grpc.Errorf(codes.AlreadyExists, "already exists")
+		case accounts.ErrInvalidInviteCode:
+			return nil, grpc.Errorf(codes.FailedPrecondition, "invalid invite code")
 		}
 		glog.Errorf("Failed to create account: %v", err)
 		return nil, grpc.Errorf(codes.Internal, "failed to create account")
@@ -37,18 +54,16 @@ func (s *Service) Create(ctx context.Context, req *pb.CreateRequest) (*pb.Create
 }
 
 func (s *Service) Authenticate(ctx context.Context, req *pb.AuthenticateRequest) (*pb.AuthenticateResponse, error) {
-	account, err := s.accounts.Account(ctx, req.Username)
-	if err != nil {
-		if err == accounts.ErrNotFound {
+	if _, err := s.accounts.Login(ctx, req.Username, req.Password, req.Source, req.Method); err != nil {
+		switch err {
+		case accounts.ErrNotFound:
 			return nil, grpc.Errorf(codes.NotFound, "account not found")
-		}
-		glog.Errorf("Failed to load account: %v", err)
-		return nil, grpc.Errorf(codes.Internal, "failed to load account")
-	}
-
-	if err := account.Authenticate(ctx, req.Password); err != nil {
-		if err == accounts.ErrUnauthenticated {
+		case accounts.ErrThrottled:
+			return nil, grpc.Errorf(codes.ResourceExhausted, "too many failed logins")
+		case accounts.ErrUnauthenticated:
 			return nil, grpc.Errorf(codes.PermissionDenied, "invalid credentials")
+		case accounts.ErrSuspended:
+			return nil, grpc.Errorf(codes.PermissionDenied, "account suspended")
 		}
 		glog.Errorf("Failed to authenticate account: %v", err)
 		return nil, grpc.Errorf(codes.Internal, "failed to authenticate account")
@@ -81,9 +96,18 @@ func (s *Service) ListByIdentifier(ctx context.Context, req *pb.ListByIdentifier
 		return nil, grpc.Errorf(codes.Internal, "failed to list accounts")
 	}
 
-	names := make([]string, len(accounts))
-	for i, account := range accounts {
-		names[i] = account.Name
+	// Identifiers are used to log in, which suspended accounts cannot do.
+	names := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		suspended, err := account.Suspended(ctx)
+		if err != nil {
+			glog.Errorf("Failed to get account suspension: %v", err)
+			return nil, grpc.Errorf(codes.Internal, "failed to list accounts")
+		}
+
+		if !suspended {
+			names = append(names, account.Name)
+		}
 	}
 
 	return &pb.ListByIdentifierResponse{
@@ -119,10 +143,24 @@ func (s *Service) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse,
 		return nil, grpc.Errorf(codes.Internal, "failed to load account")
 	}
 
+	suspended, err := account.Suspended(ctx)
+	if err != nil {
+		glog.Errorf("Failed to get account suspension: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load account")
+	}
+
+	traitProfile, err := account.TraitProfile(ctx)
+	if err != nil {
+		glog.Errorf("Failed to get trait profile: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load account")
+	}
+
 	return &pb.GetResponse{
 		ScriptsStorageUsage: scriptsStorageUsage,
 		PrivateStorageUsage: privateStorageUsage,
 		Traits:              traits,
+		Suspended:           suspended,
+		TraitProfile:        traitProfile,
 	}, nil
 }
 
@@ -155,3 +193,289 @@ func (s *Service) CheckAccountIdentifier(ctx context.Context, req *pb.CheckAccou
 
 	return &pb.CheckAccountIdentifierResponse{}, nil
 }
+
+func (s *Service) ListIdentifiers(ctx context.Context, req *pb.ListIdentifiersRequest) (*pb.ListIdentifiersResponse, error) {
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	identifiers, err := account.Identifiers(ctx)
+	if err != nil {
+		glog.Errorf("Failed to list identifiers: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to list identifiers")
+	}
+
+	return &pb.ListIdentifiersResponse{
+		Identifier: identifiers,
+	}, nil
+}
+
+func (s *Service) AddIdentifier(ctx context.Context, req *pb.AddIdentifierRequest) (*pb.AddIdentifierResponse, error) {
+	if req.Identifier == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "identifier must be set")
+	}
+
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := account.AddIdentifier(ctx, req.Identifier); err != nil {
+		if err == accounts.ErrAlreadyExists {
+			return nil, grpc.Errorf(codes.AlreadyExists, "identifier already linked")
+		}
+		glog.Errorf("Failed to add identifier: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to add identifier")
+	}
+
+	return &pb.AddIdentifierResponse{}, nil
+}
+
+func (s *Service) RemoveIdentifier(ctx context.Context, req *pb.RemoveIdentifierRequest) (*pb.RemoveIdentifierResponse, error) {
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := account.RemoveIdentifier(ctx, req.Identifier); err != nil {
+		switch err {
+		case accounts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "identifier not found")
+		case accounts.ErrLastIdentifier:
+			return nil, grpc.Errorf(codes.FailedPrecondition, "account has no password, so its last identifier cannot be removed")
+		}
+		glog.Errorf("Failed to remove identifier: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to remove identifier")
+	}
+
+	return &pb.RemoveIdentifierResponse{}, nil
+}
+
+func (s *Service) CreateLinkCode(ctx context.Context, req *pb.CreateLinkCodeRequest) (*pb.CreateLinkCodeResponse, error) {
+	if req.Identifier == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "identifier must be set")
+	}
+
+	code, expireTime, err := s.accounts.CreateLinkCode(ctx, req.Identifier)
+	if err != nil {
+		glog.Errorf("Failed to create link code: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to create link code")
+	}
+
+	return &pb.CreateLinkCodeResponse{
+		Code:       code,
+		ExpireTime: expireTime.Unix(),
+	}, nil
+}
+
+func (s *Service) RedeemLinkCode(ctx context.Context, req *pb.RedeemLinkCodeRequest) (*pb.RedeemLinkCodeResponse, error) {
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	identifier, err := account.RedeemLinkCode(ctx, strings.ToUpper(strings.TrimSpace(req.Code)))
+	if err != nil {
+		switch err {
+		case accounts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "link code invalid or expired")
+		case accounts.ErrAlreadyExists:
+			return nil, grpc.Errorf(codes.AlreadyExists, "identifier already linked")
+		}
+		glog.Errorf("Failed to redeem link code: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to redeem link code")
+	}
+
+	glog.Infof("Linked %s to account %s", identifier, req.Username)
+
+	return &pb.RedeemLinkCodeResponse{
+		Identifier: identifier,
+	}, nil
+}
+
+func (s *Service) CreateAccessToken(ctx context.Context, req *pb.CreateAccessTokenRequest) (*pb.CreateAccessTokenResponse, error) {
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	var expireTime time.Time
+	if req.ExpireTime != 0 {
+		expireTime = time.Unix(req.ExpireTime, 0)
+		if expireTime.Before(time.Now()) {
+			return nil, grpc.Errorf(codes.InvalidArgument, "expiry time in the past")
+		}
+	}
+
+	token, accessToken, err := account.CreateAccessToken(ctx, req.Name, req.Scope, expireTime)
+	if err != nil {
+		switch err {
+		case accounts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid access token name")
+		case accounts.ErrInvalidScope:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid scopes")
+		case accounts.ErrAlreadyExists:
+			return nil, grpc.Errorf(codes.AlreadyExists, "access token already exists")
+		}
+		glog.Errorf("Failed to create access token: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to create access token")
+	}
+
+	return &pb.CreateAccessTokenResponse{
+		Token:       token,
+		AccessToken: accessToken,
+	}, nil
+}
+
+func (s *Service) ListAccessTokens(ctx context.Context, req *pb.ListAccessTokensRequest) (*pb.ListAccessTokensResponse, error) {
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	accessTokens, err := account.AccessTokens(ctx)
+	if err != nil {
+		glog.Errorf("Failed to list access tokens: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to list access tokens")
+	}
+
+	return &pb.ListAccessTokensResponse{
+		AccessToken: accessTokens,
+	}, nil
+}
+
+func (s *Service) RevokeAccessToken(ctx context.Context, req *pb.RevokeAccessTokenRequest) (*pb.RevokeAccessTokenResponse, error) {
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := account.RevokeAccessToken(ctx, req.Id); err != nil {
+		if err == accounts.ErrNotFound {
+			return nil, grpc.Errorf(codes.NotFound, "access token not found")
+		}
+		glog.Errorf("Failed to revoke access token: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to revoke access token")
+	}
+
+	return &pb.RevokeAccessTokenResponse{}, nil
+}
+
+func (s *Service) AuthenticateAccessToken(ctx context.Context, req *pb.AuthenticateAccessTokenRequest) (*pb.AuthenticateAccessTokenResponse, error) {
+	username, scopes, err := s.accounts.AuthenticateAccessToken(ctx, req.Token)
+	if err != nil {
+		switch err {
+		case accounts.ErrUnauthenticated:
+			return nil, grpc.Errorf(codes.PermissionDenied, "invalid credentials")
+		case accounts.ErrSuspended:
+			return nil, grpc.Errorf(codes.PermissionDenied, "account suspended")
+		}
+		glog.Errorf("Failed to authenticate access token: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to authenticate access token")
+	}
+
+	return &pb.AuthenticateAccessTokenResponse{
+		Username: username,
+		Scope:    scopes,
+	}, nil
+}
+
+func (s *Service) CreateSession(ctx context.Context, req *pb.CreateSessionRequest) (*pb.CreateSessionResponse, error) {
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, refreshToken, err := account.CreateSession(ctx, time.Unix(req.ExpireTime, 0))
+	if err != nil {
+		glog.Errorf("Failed to create session: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to create session")
+	}
+
+	return &pb.CreateSessionResponse{
+		SessionId:    sessionID,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func (s *Service) RefreshSession(ctx context.Context, req *pb.RefreshSessionRequest) (*pb.RefreshSessionResponse, error) {
+	username, sessionID, refreshToken, err := s.accounts.RefreshSession(ctx, req.RefreshToken, time.Unix(req.ExpireTime, 0))
+	if err != nil {
+		switch err {
+		case accounts.ErrUnauthenticated:
+			return nil, grpc.Errorf(codes.PermissionDenied, "invalid credentials")
+		case accounts.ErrSuspended:
+			return nil, grpc.Errorf(codes.PermissionDenied, "account suspended")
+		}
+		glog.Errorf("Failed to refresh session: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to refresh session")
+	}
+
+	return &pb.RefreshSessionResponse{
+		Username:     username,
+		SessionId:    sessionID,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func (s *Service) DeleteSession(ctx context.Context, req *pb.DeleteSessionRequest) (*pb.DeleteSessionResponse, error) {
+	if err := s.accounts.DeleteSession(ctx, req.RefreshToken); err != nil {
+		glog.Errorf("Failed to delete session: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to delete session")
+	}
+
+	return &pb.DeleteSessionResponse{}, nil
+}
+
+func (s *Service) RevokeToken(ctx context.Context, req *pb.RevokeTokenRequest) (*pb.RevokeTokenResponse, error) {
+	if req.TokenId == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "token ID must be set")
+	}
+
+	if err := s.accounts.RevokeToken(ctx, req.TokenId, time.Unix(req.ExpireTime, 0)); err != nil {
+		glog.Errorf("Failed to revoke token: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to revoke token")
+	}
+
+	return &pb.RevokeTokenResponse{}, nil
+}
+
+func (s *Service) CheckToken(ctx context.Context, req *pb.CheckTokenRequest) (*pb.CheckTokenResponse, error) {
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := account.CheckToken(ctx, req.TokenId, time.Unix(req.IssueTime, 0)); err != nil {
+		switch err {
+		case accounts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "account not found")
+		case accounts.ErrUnauthenticated:
+			return nil, grpc.Errorf(codes.PermissionDenied, "token revoked")
+		case accounts.ErrSuspended:
+			return nil, grpc.Errorf(codes.PermissionDenied, "account suspended")
+		}
+		glog.Errorf("Failed to check token: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to check token")
+	}
+
+	return &pb.CheckTokenResponse{}, nil
+}
+
+func (s *Service) ListLoginAttempts(ctx context.Context, req *pb.ListLoginAttemptsRequest) (*pb.ListLoginAttemptsResponse, error) {
+	account, err := s.account(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts, err := account.LoginAttempts(ctx, req.Offset, req.Limit)
+	if err != nil {
+		glog.Errorf("Failed to list login attempts: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to list login attempts")
+	}
+
+	return &pb.ListLoginAttemptsResponse{
+		LoginAttempt: attempts,
+	}, nil
+}
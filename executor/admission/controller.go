@@ -0,0 +1,172 @@
+package admission
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+)
+
+var (
+	ErrQueueFull error = errors.New("admission: queue full")
+)
+
+var (
+	queueWaitDurationsHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "kobun4",
+		Subsystem: "executor",
+		Name:      "admission_queue_wait_durations_histogram_milliseconds",
+		Help:      "Time executions spent queued before being admitted.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	})
+
+	queuedExecutionsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kobun4",
+		Subsystem: "executor",
+		Name:      "admission_queued_executions",
+		Help:      "Number of executions waiting to be admitted.",
+	})
+
+	runningExecutionsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kobun4",
+		Subsystem: "executor",
+		Name:      "admission_running_executions",
+		Help:      "Number of admitted executions.",
+	})
+
+	rejectedExecutionsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kobun4",
+		Subsystem: "executor",
+		Name:      "admission_rejected_executions_total",
+		Help:      "Executions rejected because the queue was full.",
+	})
+)
+
+type waiter struct {
+	accountName string
+	accountMax  int
+	admitted    chan struct{}
+}
+
+// Controller bounds the number of executions running at once, both globally and per account. Executions that cannot
+// run immediately wait in a bounded FIFO queue.
+type Controller struct {
+	mu sync.Mutex
+
+	maxRunning int
+	maxQueued  int
+
+	running          int
+	runningByAccount map[string]int
+	queue            []*waiter
+}
+
+func NewController(maxRunning int, maxQueued int) *Controller {
+	prometheus.MustRegister(queueWaitDurationsHistogram)
+	prometheus.MustRegister(queuedExecutionsGauge)
+	prometheus.MustRegister(runningExecutionsGauge)
+	prometheus.MustRegister(rejectedExecutionsCounter)
+
+	return &Controller{
+		maxRunning: maxRunning,
+		maxQueued:  maxQueued,
+
+		runningByAccount: make(map[string]int),
+		queue:            make([]*waiter, 0),
+	}
+}
+
+func (c *Controller) canRun(accountName string, accountMax int) bool {
+	if c.running >= c.maxRunning {
+		return false
+	}
+	return accountMax <= 0 || c.runningByAccount[accountName] < accountMax
+}
+
+func (c *Controller) admit(accountName string) {
+	c.running++
+	c.runningByAccount[accountName]++
+	runningExecutionsGauge.Set(float64(c.running))
+}
+
+func (c *Controller) release(accountName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.running--
+	c.runningByAccount[accountName]--
+	if c.runningByAccount[accountName] == 0 {
+		delete(c.runningByAccount, accountName)
+	}
+
+	queue := c.queue[:0]
+	for _, w := range c.queue {
+		if c.canRun(w.accountName, w.accountMax) {
+			c.admit(w.accountName)
+			close(w.admitted)
+			continue
+		}
+		queue = append(queue, w)
+	}
+	c.queue = queue
+
+	runningExecutionsGauge.Set(float64(c.running))
+	queuedExecutionsGauge.Set(float64(len(c.queue)))
+}
+
+// Acquire blocks until an execution for the given account may run. accountMax is the account's concurrency limit, or
+// 0 if it has none. The returned function must be called once the execution is done.
+func (c *Controller) Acquire(ctx context.Context, accountName string, accountMax int) (func(), error) {
+	release := func() {
+		c.release(accountName)
+	}
+
+	c.mu.Lock()
+	if c.canRun(accountName, accountMax) {
+		c.admit(accountName)
+		c.mu.Unlock()
+		queueWaitDurationsHistogram.Observe(0)
+		return release, nil
+	}
+
+	if len(c.queue) >= c.maxQueued {
+		c.mu.Unlock()
+		rejectedExecutionsCounter.Inc()
+		return nil, ErrQueueFull
+	}
+
+	w := &waiter{
+		accountName: accountName,
+		accountMax:  accountMax,
+		admitted:    make(chan struct{}),
+	}
+	c.queue = append(c.queue, w)
+	queuedExecutionsGauge.Set(float64(len(c.queue)))
+	c.mu.Unlock()
+
+	startTime := time.Now()
+
+	select {
+	case <-w.admitted:
+		queueWaitDurationsHistogram.Observe(float64(time.Since(startTime)) / float64(time.Millisecond))
+		return release, nil
+	case <-ctx.Done():
+	}
+
+	c.mu.Lock()
+	for i, other := range c.queue {
+		if other == w {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			queuedExecutionsGauge.Set(float64(len(c.queue)))
+			c.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	c.mu.Unlock()
+
+	// We were admitted while giving up, so give the slot back.
+	release()
+	return nil, ctx.Err()
+}
@@ -0,0 +1,239 @@
+package admission
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// newTestController builds a Controller without registering its metrics, which may only be registered once.
+func newTestController(maxRunning int, maxQueued int) *Controller {
+	return &Controller{
+		maxRunning: maxRunning,
+		maxQueued:  maxQueued,
+
+		runningByAccount: make(map[string]int),
+		queue:            make([]*waiter, 0),
+	}
+}
+
+func (c *Controller) queueLen() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.queue)
+}
+
+func waitForQueueLen(t *testing.T, c *Controller, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for c.queueLen() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue length = %d, want %d", c.queueLen(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestControllerAcquire(t *testing.T) {
+	type step struct {
+		accountName string
+		accountMax  int
+		want        error
+	}
+
+	for _, c := range []struct {
+		name       string
+		maxRunning int
+		maxQueued  int
+		steps      []step
+	}{
+		{
+			name:       "global limit",
+			maxRunning: 2,
+			maxQueued:  1,
+			steps: []step{
+				{"a", 0, nil},
+				{"b", 0, nil},
+				{"c", 0, context.DeadlineExceeded},
+			},
+		},
+		{
+			name:       "account limit",
+			maxRunning: 3,
+			maxQueued:  1,
+			steps: []step{
+				{"a", 1, nil},
+				{"a", 1, context.DeadlineExceeded},
+				{"b", 1, nil},
+			},
+		},
+		{
+			name:       "no account limit",
+			maxRunning: 3,
+			maxQueued:  1,
+			steps: []step{
+				{"a", 0, nil},
+				{"a", 0, nil},
+				{"a", 0, nil},
+			},
+		},
+		{
+			name:       "queue full",
+			maxRunning: 1,
+			maxQueued:  0,
+			steps: []step{
+				{"a", 0, nil},
+				{"b", 0, ErrQueueFull},
+			},
+		},
+	} {
+		controller := newTestController(c.maxRunning, c.maxQueued)
+
+		for i, s := range c.steps {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			_, err := controller.Acquire(ctx, s.accountName, s.accountMax)
+			cancel()
+
+			if err != s.want {
+				t.Errorf("%s: step %d: Acquire(%q, %d) = %v, want %v", c.name, i, s.accountName, s.accountMax, err, s.want)
+			}
+		}
+
+		if n := controller.queueLen(); n != 0 {
+			t.Errorf("%s: %d executions left queued", c.name, n)
+		}
+	}
+}
+
+func TestControllerQueue(t *testing.T) {
+	c := newTestController(1, 2)
+
+	releaseA, err := c.Acquire(context.Background(), "a", 0)
+	if err != nil {
+		t.Fatalf("Acquire(a) = %v", err)
+	}
+
+	admitted := make(chan string, 2)
+	for i, accountName := range []string{"b", "c"} {
+		accountName := accountName
+		go func() {
+			release, err := c.Acquire(context.Background(), accountName, 0)
+			if err != nil {
+				t.Errorf("Acquire(%s) = %v", accountName, err)
+				return
+			}
+			admitted <- accountName
+			release()
+		}()
+		waitForQueueLen(t, c, i+1)
+	}
+
+	if _, err := c.Acquire(context.Background(), "d", 0); err != ErrQueueFull {
+		t.Errorf("Acquire(d) = %v, want %v", err, ErrQueueFull)
+	}
+
+	releaseA()
+
+	for _, want := range []string{"b", "c"} {
+		select {
+		case got := <-admitted:
+			if got != want {
+				t.Errorf("admitted %s, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s was never admitted", want)
+		}
+	}
+}
+
+func TestControllerQueueSkipsAccountsAtLimit(t *testing.T) {
+	c := newTestController(2, 2)
+
+	releaseA, err := c.Acquire(context.Background(), "a", 1)
+	if err != nil {
+		t.Fatalf("Acquire(a) = %v", err)
+	}
+	releaseB, err := c.Acquire(context.Background(), "b", 0)
+	if err != nil {
+		t.Fatalf("Acquire(b) = %v", err)
+	}
+
+	admitted := make(chan string, 2)
+	for i, accountName := range []string{"a", "c"} {
+		accountName := accountName
+		go func() {
+			release, err := c.Acquire(context.Background(), accountName, 1)
+			if err != nil {
+				t.Errorf("Acquire(%s) = %v", accountName, err)
+				return
+			}
+			admitted <- accountName
+			release()
+		}()
+		waitForQueueLen(t, c, i+1)
+	}
+
+	// Freeing b's slot must admit c, even though a's waiter is ahead of it, because a is still at its limit.
+	releaseB()
+
+	select {
+	case got := <-admitted:
+		if got != "c" {
+			t.Errorf("admitted %s, want c", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("c was never admitted")
+	}
+
+	releaseA()
+
+	select {
+	case got := <-admitted:
+		if got != "a" {
+			t.Errorf("admitted %s, want a", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("a was never admitted")
+	}
+}
+
+func TestControllerCancel(t *testing.T) {
+	c := newTestController(1, 1)
+
+	release, err := c.Acquire(context.Background(), "a", 0)
+	if err != nil {
+		t.Fatalf("Acquire(a) = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Acquire(ctx, "b", 0)
+		done <- err
+	}()
+	waitForQueueLen(t, c, 1)
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Acquire(b) = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Acquire(b) did not return after cancellation")
+	}
+
+	if n := c.queueLen(); n != 0 {
+		t.Errorf("queue length = %d after cancellation, want 0", n)
+	}
+
+	// The cancelled waiter must not hold a slot once a is done.
+	release()
+
+	release, err = c.Acquire(context.Background(), "c", 0)
+	if err != nil {
+		t.Fatalf("Acquire(c) = %v", err)
+	}
+	release()
+}
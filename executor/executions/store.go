@@ -0,0 +1,213 @@
+package executions
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+)
+
+var (
+	ErrNotFound error = errors.New("executions: not found")
+)
+
+type Store struct {
+	db *sql.DB
+
+	maxOutputSize int
+	maxAge        time.Duration
+	maxPerScript  int
+}
+
+func NewStore(db *sql.DB, maxOutputSize int, maxAge time.Duration, maxPerScript int, cleanupPeriod time.Duration) *Store {
+	s := &Store{
+		db: db,
+
+		maxOutputSize: maxOutputSize,
+		maxAge:        maxAge,
+		maxPerScript:  maxPerScript,
+	}
+
+	go func() {
+		for range time.Tick(cleanupPeriod) {
+			deletedRows, err := s.cleanup(context.Background())
+			if err != nil {
+				glog.Errorf("Failed to clean up execution rows: %v", err)
+			} else {
+				glog.Infof("Cleaned up %d execution rows.", deletedRows)
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *Store) truncate(output []byte) []byte {
+	if len(output) > s.maxOutputSize {
+		return output[:s.maxOutputSize]
+	}
+	return output
+}
+
+func (s *Store) Record(ctx context.Context, execution *scriptspb.Execution) error {
+	rawContext, err := proto.Marshal(execution.Context)
+	if err != nil {
+		return err
+	}
+
+	result := execution.Result
+	if result == nil {
+		result = &scriptspb.WorkerExecutionResult{}
+	}
+
+	timings := result.Timings
+	if timings == nil {
+		timings = &scriptspb.WorkerExecutionResult_Timings{}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		insert into executions (execution_id, owner_name, script_name, context, start_time, wait_status, time_limit_exceeded, cancelled, real_nanos, user_nanos, system_nanos, error_message, stdout, stderr)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	`, execution.Id, execution.OwnerName, execution.Name, rawContext, time.Unix(0, execution.StartTime), int64(result.WaitStatus), result.TimeLimitExceeded, result.Cancelled, int64(timings.RealNanos), int64(timings.UserNanos), int64(timings.SystemNanos), execution.Error, s.truncate(execution.Stdout), s.truncate(execution.Stderr)); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		delete from executions
+		where owner_name = $1 and
+		      script_name = $2 and
+		      execution_id not in (
+		          select execution_id
+		          from executions
+		          where owner_name = $1 and
+		                script_name = $2
+		          order by start_time desc
+		          limit $3
+		      )
+	`, execution.OwnerName, execution.Name, s.maxPerScript); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanExecution(row rowScanner, withOutput bool) (*scriptspb.Execution, error) {
+	execution := &scriptspb.Execution{
+		Context: &scriptspb.Context{},
+		Result: &scriptspb.WorkerExecutionResult{
+			Timings: &scriptspb.WorkerExecutionResult_Timings{},
+		},
+	}
+
+	var rawContext []byte
+	var startTime time.Time
+	var waitStatus, realNanos, userNanos, systemNanos int64
+
+	dest := []interface{}{
+		&execution.Id,
+		&execution.OwnerName,
+		&execution.Name,
+		&rawContext,
+		&startTime,
+		&waitStatus,
+		&execution.Result.TimeLimitExceeded,
+		&execution.Result.Cancelled,
+		&realNanos,
+		&userNanos,
+		&systemNanos,
+		&execution.Error,
+	}
+	if withOutput {
+		dest = append(dest, &execution.Stdout, &execution.Stderr)
+	}
+
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	if err := proto.Unmarshal(rawContext, execution.Context); err != nil {
+		return nil, err
+	}
+
+	execution.StartTime = startTime.UnixNano()
+	execution.Result.WaitStatus = uint32(waitStatus)
+	execution.Result.Timings.RealNanos = uint64(realNanos)
+	execution.Result.Timings.UserNanos = uint64(userNanos)
+	execution.Result.Timings.SystemNanos = uint64(systemNanos)
+
+	return execution, nil
+}
+
+// Executions lists executions of a script, newest first. Output is not included.
+func (s *Store) Executions(ctx context.Context, ownerName string, name string, offset, limit uint32) ([]*scriptspb.Execution, error) {
+	executions := make([]*scriptspb.Execution, 0)
+
+	rows, err := s.db.QueryContext(ctx, `
+		select execution_id, owner_name, script_name, context, start_time, wait_status, time_limit_exceeded, cancelled, real_nanos, user_nanos, system_nanos, error_message
+		from executions
+		where owner_name = $1 and
+		      ($2 = '' or script_name = $2)
+		order by start_time desc
+		offset $3 limit $4
+	`, ownerName, name, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		execution, err := scanExecution(rows, false)
+		if err != nil {
+			return nil, err
+		}
+		executions = append(executions, execution)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return executions, nil
+}
+
+func (s *Store) Execution(ctx context.Context, id string) (*scriptspb.Execution, error) {
+	execution, err := scanExecution(s.db.QueryRowContext(ctx, `
+		select execution_id, owner_name, script_name, context, start_time, wait_status, time_limit_exceeded, cancelled, real_nanos, user_nanos, system_nanos, error_message, stdout, stderr
+		from executions
+		where execution_id = $1
+	`, id), true)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return execution, nil
+}
+
+func (s *Store) cleanup(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		delete from executions
+		where start_time < $1
+	`, time.Now().Add(-s.maxAge))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
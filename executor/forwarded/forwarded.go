@@ -0,0 +1,23 @@
+// Package forwarded works out which address an HTTP request came from, for throttling and rate limiting by source.
+package forwarded
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Source returns the address r came from.
+//
+// If trustForwardedFor is set, the server must only be reachable through a reverse proxy that appends the address it
+// received each request from to X-Forwarded-For, and the last address in the header is used. Anything before it was
+// sent by the client and may be forged. Otherwise, the header is ignored entirely, as any client could set it to evade
+// per-source limits.
+func Source(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+			addrs := strings.Split(forwardedFor, ",")
+			return strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,33 @@
+package forwarded
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	for _, c := range []struct {
+		name              string
+		forwardedFor      string
+		trustForwardedFor bool
+		want              string
+	}{
+		{"no header", "", true, "10.0.0.1:1234"},
+		{"untrusted header", "192.0.2.1", false, "10.0.0.1:1234"},
+		{"trusted header", "192.0.2.1", true, "192.0.2.1"},
+		{"last hop of trusted header", "198.51.100.1, 192.0.2.1", true, "192.0.2.1"},
+		{"spaces", "198.51.100.1 ,  192.0.2.1 ", true, "192.0.2.1"},
+	} {
+		r := &http.Request{
+			Header:     make(http.Header),
+			RemoteAddr: "10.0.0.1:1234",
+		}
+		if c.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", c.forwardedFor)
+		}
+
+		if got := Source(r, c.trustForwardedFor); got != c.want {
+			t.Errorf("%s: Source = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"database/sql"
+	"encoding/hex"
 	"flag"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"golang.org/x/net/trace"
 	"net/http"
@@ -21,19 +23,34 @@ import (
 	"google.golang.org/grpc/reflection"
 
 	"github.com/porpoises/kobun4/executor/accounts"
+	"github.com/porpoises/kobun4/executor/admission"
+	"github.com/porpoises/kobun4/executor/executions"
+	"github.com/porpoises/kobun4/executor/runtimes"
+	"github.com/porpoises/kobun4/executor/scheduler"
 	"github.com/porpoises/kobun4/executor/scripts"
+	"github.com/porpoises/kobun4/executor/secrets"
+	"github.com/porpoises/kobun4/executor/warmpool"
 	"github.com/porpoises/kobun4/executor/webdav"
 
+	"github.com/porpoises/kobun4/executor/accountsadminservice"
+	accountsadminpb "github.com/porpoises/kobun4/executor/accountsadminservice/v1pb"
 	"github.com/porpoises/kobun4/executor/accountsservice"
 	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
+	"github.com/porpoises/kobun4/executor/schedulesservice"
+	schedulespb "github.com/porpoises/kobun4/executor/schedulesservice/v1pb"
 	"github.com/porpoises/kobun4/executor/scriptsservice"
 	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+	"github.com/porpoises/kobun4/executor/secretsservice"
+	secretspb "github.com/porpoises/kobun4/executor/secretsservice/v1pb"
 )
 
 var (
 	bindSocket       = flag.String("bind_socket", "/run/kobun4-executor/main.socket", "Bind for socket")
 	bindDebugSocket  = flag.String("bind_debug_socket", "/run/kobun4-executor/debug.socket", "Bind for socket")
 	bindWebdavSocket = flag.String("bind_webdav_socket", "/run/kobun4-executor/webdav.socket", "Bind for WebDAV socket")
+	bindAdminSocket  = flag.String("bind_admin_socket", "/run/kobun4-executor/admin.socket", "Bind for admin socket")
+
+	trustForwardedFor = flag.Bool("trust_forwarded_for", false, "Take the last address in X-Forwarded-For as the WebDAV client's address. Only set this if the WebDAV socket is only reachable through a reverse proxy that sets it")
 
 	postgresURL = flag.String("postgres_url", "postgres://", "URL to Postgres database")
 
@@ -42,8 +59,35 @@ var (
 
 	k4LibraryPath   = flag.String("k4_library_path", "clients", "Path to library root")
 	chrootPath      = flag.String("chroot_path", "chroot", "Path to chroot")
+	runtimesPath    = flag.String("runtimes_path", "runtimes", "Path to additional runtime images")
 	parentCgroup    = flag.String("parent_cgroup", "kobun4-executor", "Parent cgroup")
 	storageRootPath = flag.String("storage_root_path", "storage", "Path to image root")
+
+	maxBundleSize  = flag.Int64("max_bundle_size", 1024*1024, "Maximum total size of the files in a script bundle")
+	maxBundleFiles = flag.Int("max_bundle_files", 256, "Maximum number of files and directories in a script bundle")
+
+	executionLogMaxOutputSize = flag.Int("execution_log_max_output_size", 16*1024, "Maximum bytes of stdout and stderr to keep per logged execution")
+	executionLogMaxAge        = flag.Duration("execution_log_max_age", 7*24*time.Hour, "How long to keep logged executions")
+	executionLogMaxPerScript  = flag.Int("execution_log_max_per_script", 100, "Maximum number of logged executions to keep per script")
+	executionLogCleanupPeriod = flag.Duration("execution_log_cleanup_period", 10*time.Minute, "How often to clean up logged executions")
+
+	maxConcurrentExecutions = flag.Int("max_concurrent_executions", 32, "Maximum number of executions to run at once")
+	maxQueuedExecutions     = flag.Int("max_queued_executions", 128, "Maximum number of executions waiting to run before new ones are rejected")
+
+	disableWarmPool = flag.Bool("disable_warm_pool", false, "Prepare every sandbox on demand instead of keeping a warm pool")
+	warmPoolSize    = flag.Int("warm_pool_size", 2, "Number of prepared sandboxes to keep per sandbox profile")
+
+	schedulePollPeriod = flag.Duration("schedule_poll_period", 15*time.Second, "How often to check for due schedules")
+
+	linkCodeTTL = flag.Duration("link_code_ttl", 10*time.Minute, "How long account link codes are valid for")
+
+	loginFreeFailures  = flag.Int("login_free_failures", 5, "Number of failed logins per account or source allowed before logins are locked out")
+	loginBackoff       = flag.Duration("login_backoff", 30*time.Second, "How long the first login lockout lasts, doubling with each further failure")
+	loginMaxBackoff    = flag.Duration("login_max_backoff", time.Hour, "Maximum length of a login lockout")
+	loginFailureWindow = flag.Duration("login_failure_window", 24*time.Hour, "How long after the last failed login failures are forgotten")
+	loginHistoryMaxAge = flag.Duration("login_history_max_age", 90*24*time.Hour, "How long to keep accounts' login history")
+
+	secretsMasterKey = flag.String("secrets_master_key", "", "Hex-encoded 32-byte key used to encrypt account secrets. If not set, secrets are disabled")
 )
 
 func main() {
@@ -76,8 +120,48 @@ func main() {
 		glog.Fatalf("failed to get storage root path: %v", err)
 	}
 
-	accountStore := accounts.NewStore(db, storageRootAbsPath, filepath.Join(*toolsPath, "makestorage", "makestorage"))
-	scriptsStore := scripts.NewStore(db, storageRootAbsPath)
+	accountStore := accounts.NewStore(db, storageRootAbsPath, filepath.Join(*toolsPath, "makestorage", "makestorage"), *linkCodeTTL, accounts.LoginPolicy{
+		FreeFailures:  *loginFreeFailures,
+		Backoff:       *loginBackoff,
+		MaxBackoff:    *loginMaxBackoff,
+		FailureWindow: *loginFailureWindow,
+		HistoryMaxAge: *loginHistoryMaxAge,
+	})
+	scriptsStore := scripts.NewStore(db, storageRootAbsPath, *maxBundleSize, *maxBundleFiles)
+	executionsStore := executions.NewStore(db, *executionLogMaxOutputSize, *executionLogMaxAge, *executionLogMaxPerScript, *executionLogCleanupPeriod)
+	schedulesStore := scheduler.NewStore(db)
+
+	var secretsStore *secrets.Store
+	if *secretsMasterKey != "" {
+		masterKey, err := hex.DecodeString(*secretsMasterKey)
+		if err != nil {
+			glog.Fatalf("failed to decode secrets master key: %v", err)
+		}
+
+		secretsStore, err = secrets.NewStore(db, masterKey)
+		if err != nil {
+			glog.Fatalf("failed to create secrets store: %v", err)
+		}
+	} else {
+		glog.Warning("-secrets_master_key not provided, secrets are disabled")
+	}
+
+	poolSize := *warmPoolSize
+	if *disableWarmPool {
+		poolSize = 0
+	}
+	warmPool := warmpool.New(*supervisorPath, filepath.Join(*toolsPath, "nsenternet", "nsenternet"), *parentCgroup, poolSize)
+	defer warmPool.Close()
+
+	// Scripts' dependencies are mounted under deps in the library, which needs to exist to be mounted over.
+	if err := os.MkdirAll(filepath.Join(*k4LibraryPath, "deps"), 0755); err != nil {
+		glog.Fatalf("failed to create dependencies mountpoint: %v", err)
+	}
+
+	runtimeRegistry, err := runtimes.Load(*chrootPath, *runtimesPath)
+	if err != nil {
+		glog.Fatalf("failed to load runtimes: %v", err)
+	}
 
 	os.Remove(*bindSocket)
 	lis, err := net.Listen("unix", *bindSocket)
@@ -88,9 +172,14 @@ func main() {
 	os.Chmod(*bindSocket, 0777)
 	glog.Infof("Listening on: %s", lis.Addr())
 
+	scriptsService := scriptsservice.New(lis, scriptsStore, accountStore, executionsStore, secretsStore, admission.NewController(*maxConcurrentExecutions, *maxQueuedExecutions), warmPool, runtimeRegistry, *k4LibraryPath)
+	scheduler.New(schedulesStore, scriptsService, *schedulePollPeriod)
+
 	s := grpc.NewServer()
-	scriptspb.RegisterScriptsServer(s, scriptsservice.New(lis, scriptsStore, accountStore, filepath.Join(*toolsPath, "nsenternet", "nsenternet"), *supervisorPath, *k4LibraryPath, *chrootPath, *parentCgroup))
+	scriptspb.RegisterScriptsServer(s, scriptsService)
 	accountspb.RegisterAccountsServer(s, accountsservice.New(accountStore))
+	schedulespb.RegisterSchedulesServer(s, schedulesservice.New(schedulesStore))
+	secretspb.RegisterSecretsServer(s, secretsservice.New(secretsStore))
 	reflection.Register(s)
 
 	signalChan := make(chan os.Signal, 1)
@@ -111,12 +200,28 @@ func main() {
 	glog.Infof("WebDAV listening on: %s", webdavLis.Addr())
 
 	httpServer := &http.Server{
-		Handler: webdav.NewHandler(accountStore),
+		Handler: webdav.NewHandler(accountStore, *trustForwardedFor),
 	}
 	go func() {
 		errChan <- httpServer.Serve(webdavLis)
 	}()
 
+	os.Remove(*bindAdminSocket)
+	adminLis, err := net.Listen("unix", *bindAdminSocket)
+	if err != nil {
+		glog.Fatalf("failed to listen: %v", err)
+	}
+	defer adminLis.Close()
+	// Only the executor's own user may administer accounts.
+	os.Chmod(*bindAdminSocket, 0700)
+	glog.Infof("Admin listening on: %s", adminLis.Addr())
+
+	adminServer := grpc.NewServer()
+	accountsadminpb.RegisterAccountsAdminServer(adminServer, accountsadminservice.New(accountStore))
+	go func() {
+		errChan <- adminServer.Serve(adminLis)
+	}()
+
 	select {
 	case err := <-errChan:
 		glog.Fatalf("failed to serve: %v", err)
@@ -0,0 +1,292 @@
+package params
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+)
+
+const maxParameters = 20
+
+var nameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]{0,19}$`)
+
+// ParseError is returned when input does not match a script's parameters. Its message is suitable for showing to the
+// user who gave the input.
+type ParseError struct {
+	msg string
+}
+
+func (e *ParseError) Error() string {
+	return e.msg
+}
+
+// Validate checks that a list of parameter declarations is well-formed.
+func Validate(params []*scriptspb.Parameter) error {
+	if len(params) > maxParameters {
+		return fmt.Errorf("at most %d parameters may be declared", maxParameters)
+	}
+
+	seen := make(map[string]bool)
+	sawOptionalPositional := false
+	sawRest := false
+
+	for _, param := range params {
+		if !nameRegexp.MatchString(param.Name) {
+			return fmt.Errorf("invalid parameter name %q", param.Name)
+		}
+
+		if seen[param.Name] {
+			return fmt.Errorf("duplicate parameter %q", param.Name)
+		}
+		seen[param.Name] = true
+
+		if _, ok := scriptspb.Parameter_Type_name[int32(param.Type)]; !ok {
+			return fmt.Errorf("parameter %q has an unknown type", param.Name)
+		}
+
+		if param.DefaultValue != "" {
+			if param.Required {
+				return fmt.Errorf("required parameter %q cannot have a default", param.Name)
+			}
+			if _, err := normalize(param, param.DefaultValue); err != nil {
+				return fmt.Errorf("parameter %q has an invalid default: %v", param.Name, err)
+			}
+		}
+
+		if param.Rest && (!param.Positional || param.Type != scriptspb.Parameter_STRING) {
+			return fmt.Errorf("only positional string parameters may take the rest of the input, but %q does not", param.Name)
+		}
+
+		if !param.Positional {
+			continue
+		}
+
+		if sawRest {
+			return fmt.Errorf("parameter %q follows a parameter that takes the rest of the input", param.Name)
+		}
+		sawRest = param.Rest
+
+		if param.Required && sawOptionalPositional {
+			return fmt.Errorf("required parameter %q follows an optional positional parameter", param.Name)
+		}
+		sawOptionalPositional = sawOptionalPositional || !param.Required
+	}
+
+	return nil
+}
+
+func normalize(param *scriptspb.Parameter, raw string) (string, error) {
+	switch param.Type {
+	case scriptspb.Parameter_INTEGER:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("`%s` must be an integer", param.Name)
+		}
+		return strconv.FormatInt(v, 10), nil
+	case scriptspb.Parameter_NUMBER:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return "", fmt.Errorf("`%s` must be a number", param.Name)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case scriptspb.Parameter_BOOLEAN:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", fmt.Errorf("`%s` must be true or false", param.Name)
+		}
+		return strconv.FormatBool(v), nil
+	}
+	return raw, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func skipSpace(input string, i int) int {
+	for i < len(input) && isSpace(input[i]) {
+		i++
+	}
+	return i
+}
+
+// nextWord reads the word of input starting at start, shell-style: quotes group and backslashes escape. A word with an
+// unterminated quote is instead taken as is up to the next space, so apostrophes in ordinary text need no escaping. It
+// returns the word and the position just after it.
+func nextWord(input string, start int) (string, int) {
+	word := make([]byte, 0)
+	var quote byte
+
+	i := start
+	for ; i < len(input); i++ {
+		c := input[i]
+
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word = append(word, c)
+			}
+		case quote == '"':
+			switch {
+			case c == '"':
+				quote = 0
+			case c == '\\' && i+1 < len(input) && (input[i+1] == '"' || input[i+1] == '\\'):
+				i++
+				word = append(word, input[i])
+			default:
+				word = append(word, c)
+			}
+		case isSpace(c):
+			return string(word), i
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '\\' && i+1 < len(input):
+			i++
+			word = append(word, input[i])
+		default:
+			word = append(word, c)
+		}
+	}
+
+	if quote != 0 {
+		end := start
+		for end < len(input) && !isSpace(input[end]) {
+			end++
+		}
+		return input[start:end], end
+	}
+
+	return string(word), i
+}
+
+// Parse splits input into words, shell-style, and matches them against params. Words of the form name=value set named
+// parameters; the remaining words fill positional parameters in order. A parameter that takes the rest of the input
+// gets it as given, spacing and quotes included, so named parameters must come before it. Values are returned in a
+// canonical form for their type.
+func Parse(params []*scriptspb.Parameter, input string) (map[string]string, error) {
+	byName := make(map[string]*scriptspb.Parameter)
+	positionals := make([]*scriptspb.Parameter, 0)
+	for _, param := range params {
+		byName[param.Name] = param
+		if param.Positional {
+			positionals = append(positionals, param)
+		}
+	}
+
+	args := make(map[string]string)
+
+	for i := skipSpace(input, 0); i < len(input); i = skipSpace(input, i) {
+		start := i
+
+		var word string
+		word, i = nextWord(input, i)
+
+		if j := strings.Index(word, "="); j != -1 {
+			if param, ok := byName[word[:j]]; ok && !param.Positional {
+				v, err := normalize(param, word[j+1:])
+				if err != nil {
+					return nil, &ParseError{err.Error()}
+				}
+				args[param.Name] = v
+				continue
+			}
+		}
+
+		if len(positionals) == 0 {
+			return nil, &ParseError{fmt.Sprintf("Unexpected input `%s`", word)}
+		}
+
+		param := positionals[0]
+		positionals = positionals[1:]
+
+		raw := word
+		if param.Rest {
+			raw = strings.TrimRight(input[start:], " \t\n\r")
+			i = len(input)
+		}
+
+		v, err := normalize(param, raw)
+		if err != nil {
+			return nil, &ParseError{err.Error()}
+		}
+		args[param.Name] = v
+	}
+
+	for _, param := range params {
+		if _, ok := args[param.Name]; ok {
+			continue
+		}
+
+		if param.Required {
+			return nil, &ParseError{fmt.Sprintf("Missing `%s`", param.Name)}
+		}
+
+		if param.DefaultValue != "" {
+			v, err := normalize(param, param.DefaultValue)
+			if err != nil {
+				return nil, &ParseError{err.Error()}
+			}
+			args[param.Name] = v
+		}
+	}
+
+	return args, nil
+}
+
+// Usage returns a one-line synopsis of params, e.g. `<city> [days=<integer>]`.
+func Usage(params []*scriptspb.Parameter) string {
+	parts := make([]string, 0, len(params))
+
+	for _, param := range params {
+		if param.Positional {
+			continue
+		}
+
+		part := fmt.Sprintf("%s=<%s>", param.Name, strings.ToLower(param.Type.String()))
+		if !param.Required {
+			part = "[" + part + "]"
+		}
+		parts = append(parts, part)
+	}
+
+	for _, param := range params {
+		if !param.Positional {
+			continue
+		}
+
+		part := "<" + param.Name
+		if param.Rest {
+			part += "..."
+		}
+		part += ">"
+		if !param.Required {
+			part = "[" + part + "]"
+		}
+		parts = append(parts, part)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Help returns a line per parameter describing it.
+func Help(params []*scriptspb.Parameter) string {
+	lines := make([]string, len(params))
+
+	for i, param := range params {
+		line := fmt.Sprintf("`%s` (%s)", param.Name, strings.ToLower(param.Type.String()))
+		if param.Help != "" {
+			line += ": " + param.Help
+		}
+		if param.DefaultValue != "" {
+			line += fmt.Sprintf(" Defaults to `%s`.", param.DefaultValue)
+		}
+		lines[i] = line
+	}
+
+	return strings.Join(lines, "\n")
+}
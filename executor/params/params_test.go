@@ -0,0 +1,118 @@
+package params
+
+import (
+	"reflect"
+	"testing"
+
+	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+)
+
+func TestParse(t *testing.T) {
+	params := []*scriptspb.Parameter{
+		{Name: "city", Type: scriptspb.Parameter_STRING, Positional: true, Required: true},
+		{Name: "note", Type: scriptspb.Parameter_STRING, Positional: true, Rest: true},
+		{Name: "days", Type: scriptspb.Parameter_INTEGER, DefaultValue: "3"},
+		{Name: "metric", Type: scriptspb.Parameter_BOOLEAN},
+	}
+
+	for _, c := range []struct {
+		name  string
+		input string
+		want  map[string]string
+		valid bool
+	}{
+		{
+			name:  "positional",
+			input: "tokyo",
+			want:  map[string]string{"city": "tokyo", "days": "3"},
+			valid: true,
+		},
+		{
+			name:  "named",
+			input: "days=05 metric=1 tokyo",
+			want:  map[string]string{"city": "tokyo", "days": "5", "metric": "true"},
+			valid: true,
+		},
+		{
+			name:  "quoted",
+			input: `"new york" days='7'`,
+			want:  map[string]string{"city": "new york", "days": "7"},
+			valid: true,
+		},
+		{
+			name:  "escaped",
+			input: `new\ york "say \"hi\""`,
+			want:  map[string]string{"city": "new york", "note": `"say \"hi\""`, "days": "3"},
+			valid: true,
+		},
+		{
+			name:  "rest keeps spacing and quotes",
+			input: `tokyo  it's  "very"   hot  `,
+			want:  map[string]string{"city": "tokyo", "note": `it's  "very"   hot`, "days": "3"},
+			valid: true,
+		},
+		{
+			name:  "rest takes named parameters literally",
+			input: "tokyo hot days=5",
+			want:  map[string]string{"city": "tokyo", "note": "hot days=5", "days": "3"},
+			valid: true,
+		},
+		{
+			name:  "unbalanced quote",
+			input: "o'brien days=2",
+			want:  map[string]string{"city": "o'brien", "days": "2"},
+			valid: true,
+		},
+		{
+			name:  "unbalanced double quote",
+			input: `"tokyo`,
+			want:  map[string]string{"city": `"tokyo`, "days": "3"},
+			valid: true,
+		},
+		{
+			name:  "missing required",
+			input: "days=5",
+			valid: false,
+		},
+		{
+			name:  "wrong type",
+			input: "tokyo days=many",
+			valid: false,
+		},
+		{
+			name:  "empty",
+			input: "   ",
+			valid: false,
+		},
+	} {
+		got, err := Parse(params, c.input)
+		if valid := err == nil; valid != c.valid {
+			t.Errorf("%s: Parse(%q) = %v, want valid = %v", c.name, c.input, err, c.valid)
+			continue
+		}
+		if err != nil {
+			if _, ok := err.(*ParseError); !ok {
+				t.Errorf("%s: Parse(%q) returned %T, want *ParseError", c.name, c.input, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: Parse(%q) = %v, want %v", c.name, c.input, got, c.want)
+		}
+	}
+}
+
+func TestParseUnexpectedInput(t *testing.T) {
+	params := []*scriptspb.Parameter{
+		{Name: "city", Type: scriptspb.Parameter_STRING, Positional: true},
+	}
+
+	for _, input := range []string{
+		"tokyo osaka",
+		"city=tokyo osaka",
+	} {
+		if _, err := Parse(params, input); err == nil {
+			t.Errorf("Parse(%q) = nil, want error", input)
+		}
+	}
+}
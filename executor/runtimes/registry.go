@@ -0,0 +1,137 @@
+package runtimes
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/golang/glog"
+)
+
+var (
+	ErrNotFound error = errors.New("runtimes: not found")
+)
+
+var nameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{0,31}$`)
+
+type Runtime struct {
+	Name        string
+	Description string
+
+	// RootfsPath is the directory mounted as / for scripts using the runtime.
+	RootfsPath string
+}
+
+type metadata struct {
+	Description string `json:"description"`
+}
+
+// Registry holds the runtime images scripts can choose from. The runtime with the empty name is the default, used by
+// scripts that don't choose one.
+type Registry struct {
+	runtimes map[string]*Runtime
+}
+
+// Load builds a registry with defaultRootfsPath as the default runtime, plus every runtime found in runtimesPath. Each
+// runtime there is a directory named after it, containing its image in rootfs/ and, optionally, a runtime.json with a
+// description.
+func Load(defaultRootfsPath string, runtimesPath string) (*Registry, error) {
+	r := &Registry{
+		runtimes: map[string]*Runtime{
+			"": {
+				Name:        "",
+				Description: "Default runtime.",
+				RootfsPath:  defaultRootfsPath,
+			},
+		},
+	}
+
+	if runtimesPath == "" {
+		return r, nil
+	}
+
+	entries, err := ioutil.ReadDir(runtimesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		if !nameRegexp.MatchString(name) {
+			glog.Warningf("Skipping runtime with invalid name: %s", name)
+			continue
+		}
+
+		runtimePath, err := filepath.Abs(filepath.Join(runtimesPath, name))
+		if err != nil {
+			return nil, err
+		}
+
+		rootfsPath := filepath.Join(runtimePath, "rootfs")
+		if fi, err := os.Stat(rootfsPath); err != nil || !fi.IsDir() {
+			glog.Warningf("Skipping runtime without rootfs: %s", name)
+			continue
+		}
+
+		var md metadata
+		rawMetadata, err := ioutil.ReadFile(filepath.Join(runtimePath, "runtime.json"))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+		} else if err := json.Unmarshal(rawMetadata, &md); err != nil {
+			return nil, err
+		}
+
+		r.runtimes[name] = &Runtime{
+			Name:        name,
+			Description: md.Description,
+			RootfsPath:  rootfsPath,
+		}
+		glog.Infof("Loaded runtime: %s", name)
+	}
+
+	return r, nil
+}
+
+func (r *Registry) Runtime(name string) (*Runtime, error) {
+	runtime, ok := r.runtimes[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return runtime, nil
+}
+
+// Runtimes lists all runtimes, sorted by name.
+func (r *Registry) Runtimes() []*Runtime {
+	runtimes := make([]*Runtime, 0, len(r.runtimes))
+	for _, runtime := range r.runtimes {
+		runtimes = append(runtimes, runtime)
+	}
+
+	sort.Slice(runtimes, func(i, j int) bool {
+		return runtimes[i].Name < runtimes[j].Name
+	})
+
+	return runtimes
+}
+
+// RootfsPaths maps the name of every runtime to its image, for the supervisor to pick from when spawning scripts.
+func (r *Registry) RootfsPaths() map[string]string {
+	paths := make(map[string]string, len(r.runtimes))
+	for name, runtime := range r.runtimes {
+		paths[name] = runtime.RootfsPath
+	}
+	return paths
+}
@@ -0,0 +1,190 @@
+package scheduler
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSpec error = errors.New("scheduler: invalid spec")
+)
+
+// Spec is a parsed five-field cron spec: minute, hour, day of month, month and day of week.
+type Spec struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+
+	// Following cron, if both day fields are restricted a day matches if either of them does.
+	dayOfMonthRestricted bool
+	dayOfWeekRestricted  bool
+}
+
+type fieldBounds struct {
+	min int
+	max int
+}
+
+var (
+	minuteBounds     = fieldBounds{0, 59}
+	hourBounds       = fieldBounds{0, 23}
+	dayOfMonthBounds = fieldBounds{1, 31}
+	monthBounds      = fieldBounds{1, 12}
+	dayOfWeekBounds  = fieldBounds{0, 7}
+)
+
+func parseField(field string, bounds fieldBounds) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			v, err := strconv.Atoi(part[i+1:])
+			if err != nil || v <= 0 {
+				return 0, ErrInvalidSpec
+			}
+			step = v
+			part = part[:i]
+		}
+
+		var lo, hi int
+		switch {
+		case part == "*":
+			lo, hi = bounds.min, bounds.max
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			if lo, err = strconv.Atoi(part[:i]); err != nil {
+				return 0, ErrInvalidSpec
+			}
+			if hi, err = strconv.Atoi(part[i+1:]); err != nil {
+				return 0, ErrInvalidSpec
+			}
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, ErrInvalidSpec
+			}
+			lo, hi = v, v
+			if step != 1 {
+				hi = bounds.max
+			}
+		}
+
+		if lo < bounds.min || hi > bounds.max || lo > hi {
+			return 0, ErrInvalidSpec
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func ParseSpec(raw string) (*Spec, error) {
+	fields := strings.Fields(raw)
+	if len(fields) != 5 {
+		return nil, ErrInvalidSpec
+	}
+
+	spec := &Spec{
+		dayOfMonthRestricted: fields[2] != "*",
+		dayOfWeekRestricted:  fields[4] != "*",
+	}
+
+	var err error
+	if spec.minute, err = parseField(fields[0], minuteBounds); err != nil {
+		return nil, err
+	}
+	if spec.hour, err = parseField(fields[1], hourBounds); err != nil {
+		return nil, err
+	}
+	if spec.dayOfMonth, err = parseField(fields[2], dayOfMonthBounds); err != nil {
+		return nil, err
+	}
+	if spec.month, err = parseField(fields[3], monthBounds); err != nil {
+		return nil, err
+	}
+	if spec.dayOfWeek, err = parseField(fields[4], dayOfWeekBounds); err != nil {
+		return nil, err
+	}
+
+	// 7 is an alias for Sunday.
+	if spec.dayOfWeek&(1<<7) != 0 {
+		spec.dayOfWeek |= 1 << 0
+	}
+
+	return spec, nil
+}
+
+func (s *Spec) dayMatches(t time.Time) bool {
+	domMatches := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dowMatches := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+
+	if s.dayOfMonthRestricted && s.dayOfWeekRestricted {
+		return domMatches || dowMatches
+	}
+	return domMatches && dowMatches
+}
+
+// allHours is the hour field of a spec that runs every hour.
+const allHours = 1<<24 - 1
+
+// repeatsWallClock returns whether t's wall clock time already happened earlier the same day, as it does when clocks go
+// back at the end of daylight saving time.
+func repeatsWallClock(t time.Time) bool {
+	_, offset := t.Zone()
+	_, earlierOffset := t.Add(-3 * time.Hour).Zone()
+	if earlierOffset <= offset {
+		return false
+	}
+
+	earlier := t.Add(-time.Duration(earlierOffset-offset) * time.Second)
+	return earlier.Day() == t.Day() && earlier.Hour() == t.Hour() && earlier.Minute() == t.Minute()
+}
+
+// maxSearchYears bounds how far ahead Next looks, so specs that can never match (e.g. February 30th) terminate.
+const maxSearchYears = 5
+
+// Next returns the first time strictly after t that matches the spec, evaluated in loc. ErrInvalidSpec is returned if
+// the spec does not match any time in the next maxSearchYears years.
+func (s *Spec) Next(t time.Time, loc *time.Location) (time.Time, error) {
+	t = t.In(loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		var next time.Time
+
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			next = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			next = t.Add(time.Minute)
+		case s.hour != allHours && repeatsWallClock(t):
+			// Like cron, specs for particular hours only run once when an hour repeats, while specs for every hour run
+			// in both.
+			next = t.Add(time.Minute)
+		default:
+			return t, nil
+		}
+
+		// Midnight may not exist on days with a daylight saving transition, in which case time.Date may normalize to a
+		// time before t.
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+
+	return time.Time{}, ErrInvalidSpec
+}
@@ -0,0 +1,183 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSpec(t *testing.T) {
+	for _, c := range []struct {
+		spec  string
+		valid bool
+	}{
+		{"* * * * *", true},
+		{"*/15 9-17 * * 1-5", true},
+		{"0 0 1,15 1 *", true},
+		{"5/10 * * * *", true},
+		{"0 0 * * 7", true},
+		{"", false},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"a * * * *", false},
+	} {
+		_, err := ParseSpec(c.spec)
+		if valid := err == nil; valid != c.valid {
+			t.Errorf("ParseSpec(%q) = %v, want valid = %v", c.spec, err, c.valid)
+		}
+	}
+}
+
+func TestSpecNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("failed to load time zone: %v", err)
+	}
+
+	for _, c := range []struct {
+		name string
+		spec string
+		loc  *time.Location
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "step",
+			spec: "*/15 * * * *",
+			loc:  time.UTC,
+			from: time.Date(2017, 1, 1, 0, 7, 0, 0, time.UTC),
+			want: time.Date(2017, 1, 1, 0, 15, 0, 0, time.UTC),
+		},
+		{
+			name: "strictly after",
+			spec: "0 0 * * *",
+			loc:  time.UTC,
+			from: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "seconds truncated",
+			spec: "* * * * *",
+			loc:  time.UTC,
+			from: time.Date(2017, 1, 1, 0, 0, 59, 0, time.UTC),
+			want: time.Date(2017, 1, 1, 0, 1, 0, 0, time.UTC),
+		},
+		{
+			name: "day of week",
+			spec: "0 12 * * 1",
+			loc:  time.UTC,
+			from: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), // Sunday
+			want: time.Date(2017, 1, 2, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday as 7",
+			spec: "0 0 * * 7",
+			loc:  time.UTC,
+			from: time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC), // Monday
+			want: time.Date(2017, 1, 8, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or day of week",
+			spec: "0 0 13 * 5",
+			loc:  time.UTC,
+			from: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2017, 1, 6, 0, 0, 0, 0, time.UTC), // Friday, before the 13th
+		},
+		{
+			name: "day of month and month",
+			spec: "0 0 1 6 *",
+			loc:  time.UTC,
+			from: time.Date(2017, 7, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "leap day",
+			spec: "0 0 29 2 *",
+			loc:  time.UTC,
+			from: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "evaluated in location",
+			spec: "0 9 * * *",
+			loc:  newYork,
+			from: time.Date(2017, 1, 1, 15, 0, 0, 0, time.UTC), // 10:00 EST
+			want: time.Date(2017, 1, 2, 9, 0, 0, 0, newYork),
+		},
+		{
+			name: "midnight after spring forward",
+			spec: "0 0 * * *",
+			loc:  newYork,
+			from: time.Date(2017, 3, 11, 12, 0, 0, 0, newYork),
+			want: time.Date(2017, 3, 12, 0, 0, 0, 0, newYork),
+		},
+		{
+			name: "skipped hour",
+			spec: "30 2 * * *",
+			loc:  newYork,
+			from: time.Date(2017, 3, 12, 0, 0, 0, 0, newYork),
+			want: time.Date(2017, 3, 13, 2, 30, 0, 0, newYork),
+		},
+		{
+			name: "hour after skipped hour",
+			spec: "30 3 * * *",
+			loc:  newYork,
+			from: time.Date(2017, 3, 12, 0, 0, 0, 0, newYork),
+			want: time.Date(2017, 3, 12, 3, 30, 0, 0, newYork),
+		},
+		{
+			name: "first of repeated hour",
+			spec: "30 1 * * *",
+			loc:  newYork,
+			from: time.Date(2017, 11, 5, 0, 0, 0, 0, newYork),
+			want: time.Date(2017, 11, 5, 5, 30, 0, 0, time.UTC), // 01:30 EDT
+		},
+		{
+			name: "second of repeated hour skipped",
+			spec: "30 1 * * *",
+			loc:  newYork,
+			from: time.Date(2017, 11, 5, 5, 30, 0, 0, time.UTC), // 01:30 EDT
+			want: time.Date(2017, 11, 6, 1, 30, 0, 0, newYork),
+		},
+		{
+			name: "every hour runs in repeated hour",
+			spec: "30 * * * *",
+			loc:  newYork,
+			from: time.Date(2017, 11, 5, 5, 30, 0, 0, time.UTC), // 01:30 EDT
+			want: time.Date(2017, 11, 5, 6, 30, 0, 0, time.UTC), // 01:30 EST
+		},
+	} {
+		spec, err := ParseSpec(c.spec)
+		if err != nil {
+			t.Errorf("%s: ParseSpec(%q) = %v", c.name, c.spec, err)
+			continue
+		}
+
+		got, err := spec.Next(c.from, c.loc)
+		if err != nil {
+			t.Errorf("%s: Next(%v) = %v", c.name, c.from, err)
+			continue
+		}
+
+		if !got.Equal(c.want) {
+			t.Errorf("%s: Next(%v) = %v, want %v", c.name, c.from, got, c.want)
+		}
+	}
+}
+
+func TestSpecNextNeverMatches(t *testing.T) {
+	spec, err := ParseSpec("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("ParseSpec = %v", err)
+	}
+
+	if _, err := spec.Next(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), time.UTC); err != ErrInvalidSpec {
+		t.Errorf("Next = %v, want %v", err, ErrInvalidSpec)
+	}
+}
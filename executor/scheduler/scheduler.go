@@ -0,0 +1,111 @@
+package scheduler
+
+import (
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	messagingpb "github.com/porpoises/kobun4/executor/messagingservice/v1pb"
+	schedulespb "github.com/porpoises/kobun4/executor/schedulesservice/v1pb"
+	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+)
+
+type Executor interface {
+	GetMeta(ctx context.Context, req *scriptspb.GetMetaRequest) (*scriptspb.GetMetaResponse, error)
+	Execute(ctx context.Context, req *scriptspb.ExecuteRequest) (*scriptspb.ExecuteResponse, error)
+}
+
+type Scheduler struct {
+	store    *Store
+	executor Executor
+}
+
+func New(store *Store, executor Executor, pollPeriod time.Duration) *Scheduler {
+	s := &Scheduler{
+		store:    store,
+		executor: executor,
+	}
+
+	go func() {
+		for now := range time.Tick(pollPeriod) {
+			schedules, err := s.store.claimDue(context.Background(), now)
+			if err != nil {
+				glog.Errorf("Failed to claim due schedules: %v", err)
+				continue
+			}
+
+			for _, schedule := range schedules {
+				go s.run(context.Background(), schedule)
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *Scheduler) run(ctx context.Context, schedule *schedulespb.Schedule) {
+	glog.Infof("Running schedule %d: %s/%s", schedule.Id, schedule.OwnerName, schedule.Name)
+
+	// Scripts may have been unpublished since they were scheduled, in which case they no longer run, as with event
+	// bindings.
+	metaResp, err := s.executor.GetMeta(ctx, &scriptspb.GetMetaRequest{
+		OwnerName: schedule.OwnerName,
+		Name:      schedule.Name,
+	})
+	if err != nil {
+		glog.Errorf("Failed to get meta for schedule %d: %v", schedule.Id, err)
+		return
+	}
+
+	if metaResp.Meta.Visibility == scriptspb.Visibility_UNPUBLISHED {
+		glog.Infof("Skipping schedule %d: %s/%s is unpublished", schedule.Id, schedule.OwnerName, schedule.Name)
+		return
+	}
+
+	resp, err := s.executor.Execute(ctx, &scriptspb.ExecuteRequest{
+		OwnerName:    schedule.OwnerName,
+		Name:         schedule.Name,
+		Stdin:        schedule.Stdin,
+		Context:      schedule.Context,
+		BridgeTarget: schedule.BridgeTarget,
+	})
+	if err != nil {
+		glog.Errorf("Failed to execute schedule %d: %v", schedule.Id, err)
+		return
+	}
+
+	waitStatus := syscall.WaitStatus(resp.Result.WaitStatus)
+	if waitStatus.ExitStatus() != 0 || len(resp.Stdout) == 0 {
+		return
+	}
+
+	bridgeConn, err := grpc.Dial(schedule.BridgeTarget, grpc.WithInsecure(), grpc.WithDialer(func(address string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout("unix", address, timeout)
+	}))
+	if err != nil {
+		glog.Errorf("Failed to connect to bridge for schedule %d: %v", schedule.Id, err)
+		return
+	}
+	defer bridgeConn.Close()
+
+	req := &messagingpb.MessageRequest{
+		Content: resp.Stdout,
+		Format:  resp.Result.OutputParams.Format,
+		Target: &messagingpb.MessageRequest_ChannelId{
+			ChannelId: schedule.Context.ChannelId,
+		},
+	}
+	if resp.Result.OutputParams.Private {
+		req.Target = &messagingpb.MessageRequest_UserId{
+			UserId: schedule.Context.UserId,
+		}
+	}
+
+	if _, err := messagingpb.NewMessagingClient(bridgeConn).Message(ctx, req); err != nil {
+		glog.Errorf("Failed to send output of schedule %d: %v", schedule.Id, err)
+	}
+}
@@ -0,0 +1,208 @@
+package scheduler
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+
+	schedulespb "github.com/porpoises/kobun4/executor/schedulesservice/v1pb"
+	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+)
+
+var (
+	ErrNotFound        error = errors.New("scheduler: not found")
+	ErrScriptNotFound        = errors.New("scheduler: script not found")
+	ErrInvalidTimeZone       = errors.New("scheduler: invalid time zone")
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+func nextRunTime(schedule *schedulespb.Schedule, after time.Time) (time.Time, error) {
+	spec, err := ParseSpec(schedule.Spec)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	loc, err := time.LoadLocation(schedule.TimeZone)
+	if err != nil {
+		return time.Time{}, ErrInvalidTimeZone
+	}
+
+	return spec.Next(after, loc)
+}
+
+func (s *Store) Create(ctx context.Context, schedule *schedulespb.Schedule) (*schedulespb.Schedule, error) {
+	next, err := nextRunTime(schedule, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	rawContext, err := proto.Marshal(schedule.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	created := proto.Clone(schedule).(*schedulespb.Schedule)
+	created.NextRunTime = next.Unix()
+
+	if err := s.db.QueryRowContext(ctx, `
+		insert into schedules (owner_name, script_name, spec, time_zone, bridge_target, bridge_name, group_id, context, stdin, next_run_time)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		returning schedule_id
+	`, schedule.OwnerName, schedule.Name, schedule.Spec, schedule.TimeZone, schedule.BridgeTarget, schedule.Context.BridgeName, schedule.Context.GroupId, rawContext, schedule.Stdin, next).Scan(&created.Id); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			return nil, ErrScriptNotFound
+		}
+		return nil, err
+	}
+
+	return created, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSchedule(row rowScanner) (*schedulespb.Schedule, error) {
+	schedule := &schedulespb.Schedule{
+		Context: &scriptspb.Context{},
+	}
+
+	var rawContext []byte
+	var next time.Time
+	if err := row.Scan(&schedule.Id, &schedule.OwnerName, &schedule.Name, &schedule.Spec, &schedule.TimeZone, &schedule.BridgeTarget, &rawContext, &schedule.Stdin, &next); err != nil {
+		return nil, err
+	}
+
+	if err := proto.Unmarshal(rawContext, schedule.Context); err != nil {
+		return nil, err
+	}
+	schedule.NextRunTime = next.Unix()
+
+	return schedule, nil
+}
+
+func (s *Store) Schedules(ctx context.Context, bridgeName string, groupID string) ([]*schedulespb.Schedule, error) {
+	schedules := make([]*schedulespb.Schedule, 0)
+
+	rows, err := s.db.QueryContext(ctx, `
+		select schedule_id, owner_name, script_name, spec, time_zone, bridge_target, context, stdin, next_run_time
+		from schedules
+		where bridge_name = $1 and
+		      group_id = $2
+		order by schedule_id
+	`, bridgeName, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+func (s *Store) Delete(ctx context.Context, id int64, bridgeName string, groupID string) error {
+	res, err := s.db.ExecContext(ctx, `
+		delete from schedules
+		where schedule_id = $1 and
+		      bridge_name = $2 and
+		      group_id = $3
+	`, id, bridgeName, groupID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// claimDue returns all schedules due at now and advances their next run time, so each run is only claimed once.
+func (s *Store) claimDue(ctx context.Context, now time.Time) ([]*schedulespb.Schedule, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		select schedule_id, owner_name, script_name, spec, time_zone, bridge_target, context, stdin, next_run_time
+		from schedules
+		where next_run_time <= $1
+		for update
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make([]*schedulespb.Schedule, 0)
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, schedule := range schedules {
+		next, err := nextRunTime(schedule, now)
+		if err != nil {
+			// The schedule will never run again, e.g. because its time zone no longer exists.
+			if _, err := tx.ExecContext(ctx, `
+				delete from schedules
+				where schedule_id = $1
+			`, schedule.Id); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			update schedules
+			set next_run_time = $1
+			where schedule_id = $2
+		`, next, schedule.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
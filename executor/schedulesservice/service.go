@@ -0,0 +1,70 @@
+package schedulesservice
+
+import (
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/porpoises/kobun4/executor/scheduler"
+
+	pb "github.com/porpoises/kobun4/executor/schedulesservice/v1pb"
+)
+
+type Service struct {
+	schedules *scheduler.Store
+}
+
+func New(schedules *scheduler.Store) *Service {
+	return &Service{
+		schedules: schedules,
+	}
+}
+
+func (s *Service) CreateSchedule(ctx context.Context, req *pb.CreateScheduleRequest) (*pb.CreateScheduleResponse, error) {
+	if req.Schedule == nil || req.Schedule.Context == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "schedule and context required")
+	}
+
+	schedule, err := s.schedules.Create(ctx, req.Schedule)
+	if err != nil {
+		switch err {
+		case scheduler.ErrInvalidSpec:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid schedule spec")
+		case scheduler.ErrInvalidTimeZone:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid time zone")
+		case scheduler.ErrScriptNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "script not found")
+		}
+		glog.Errorf("Failed to create schedule: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to create schedule")
+	}
+
+	return &pb.CreateScheduleResponse{
+		Schedule: schedule,
+	}, nil
+}
+
+func (s *Service) ListSchedules(ctx context.Context, req *pb.ListSchedulesRequest) (*pb.ListSchedulesResponse, error) {
+	schedules, err := s.schedules.Schedules(ctx, req.BridgeName, req.GroupId)
+	if err != nil {
+		glog.Errorf("Failed to list schedules: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to list schedules")
+	}
+
+	return &pb.ListSchedulesResponse{
+		Schedule: schedules,
+	}, nil
+}
+
+func (s *Service) DeleteSchedule(ctx context.Context, req *pb.DeleteScheduleRequest) (*pb.DeleteScheduleResponse, error) {
+	if err := s.schedules.Delete(ctx, req.Id, req.BridgeName, req.GroupId); err != nil {
+		if err == scheduler.ErrNotFound {
+			return nil, grpc.Errorf(codes.NotFound, "schedule not found")
+		}
+		glog.Errorf("Failed to delete schedule: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to delete schedule")
+	}
+
+	return &pb.DeleteScheduleResponse{}, nil
+}
@@ -1,6 +1,5 @@
-create table accounts (
+create table trait_profiles (
     name character varying(20) primary key not null,
-    password_hash character varying not null,
     time_limit_seconds integer not null default 5,
     memory_limit integer not null default 20971520,
     tmpfs_size integer not null default 20971520,
@@ -9,7 +8,32 @@ create table accounts (
     allow_network_access boolean not null default false,
     allowed_output_formats character varying[] not null default array['text', 'rich'],
     allowed_services character varying[] not null default array['Deputy', 'NetworkInfo'],
-    max_messages_per_invocation integer not null default 10
+    max_messages_per_invocation integer not null default 10,
+    max_concurrent_executions integer not null default 2
+);
+
+insert into trait_profiles (name) values ('default');
+
+create table accounts (
+    name character varying(20) primary key not null,
+    password_hash character varying not null,
+    trait_profile character varying(20) not null default 'default',
+    time_limit_seconds integer,
+    memory_limit integer,
+    tmpfs_size integer,
+    blkio_weight integer,
+    cpu_shares integer,
+    allow_network_access boolean,
+    allowed_output_formats character varying[],
+    allowed_services character varying[],
+    max_messages_per_invocation integer,
+    max_concurrent_executions integer,
+    suspended boolean not null default false,
+    tokens_valid_after timestamp with time zone,
+
+    foreign key (trait_profile) references trait_profiles (name)
+        on update cascade
+        on delete restrict
 );
 
 create table scripts (
@@ -18,6 +42,15 @@ create table scripts (
     description text(200) not null default '',
     published boolean not null default false,
     votes integer not null default 0,
+    uses bigint not null default 0,
+    create_time timestamp with time zone not null default now(),
+    update_time timestamp with time zone not null default now(),
+    forked_from character varying(41) not null default '',
+    parameters bytea not null default '',
+    runtime character varying(32) not null default '',
+    dependencies character varying(41)[] not null default '{}',
+    secrets character varying(64)[] not null default '{}',
+    bundle_entrypoint character varying(255) not null default '',
 
     primary key (owner_name, script_name),
 
@@ -27,6 +60,8 @@ create table scripts (
 );
 
 create index scripts_owner_name_idx on scripts (owner_name);
+create index scripts_create_time_idx on scripts (create_time);
+create index scripts_update_time_idx on scripts (update_time);
 create index scripts_ft_idx on scripts using gin (to_tsvector('english', script_name || ' ' || description));
 
 create or replace function extract_hashtags(text) returns text[]
@@ -37,9 +72,95 @@ create or replace function extract_hashtags(text) returns text[]
 
 create index scripts_tags_idx on scripts using gin (extract_hashtags(description));
 
+create table script_revisions (
+    owner_name character varying(20) not null,
+    script_name character varying(20) not null,
+    revision_id bigint not null,
+    author_name character varying(20) not null,
+    create_time timestamp with time zone not null default now(),
+    content_hash character varying(64) not null,
+    content bytea not null,
+    description text not null,
+    visibility smallint not null,
+    parameters bytea not null default '',
+    runtime character varying(32) not null default '',
+    dependencies character varying(41)[] not null default '{}',
+    secrets character varying(64)[] not null default '{}',
+
+    primary key (owner_name, script_name, revision_id),
+
+    foreign key (owner_name, script_name) references scripts (owner_name, script_name)
+        on update cascade
+        on delete cascade
+);
+
+create table executions (
+    execution_id character varying(32) primary key not null,
+    owner_name character varying(20) not null,
+    script_name character varying(20) not null,
+    context bytea not null,
+    start_time timestamp with time zone not null,
+    wait_status bigint not null,
+    time_limit_exceeded boolean not null,
+    cancelled boolean not null default false,
+    real_nanos bigint not null,
+    user_nanos bigint not null,
+    system_nanos bigint not null,
+    error_message text not null default '',
+    stdout bytea not null,
+    stderr bytea not null,
+
+    foreign key (owner_name, script_name) references scripts (owner_name, script_name)
+        on update cascade
+        on delete cascade
+);
+
+create index executions_script_start_time_idx on executions (owner_name, script_name, start_time);
+create index executions_start_time_idx on executions (start_time);
+
+create table script_transfers (
+    owner_name character varying(20) not null,
+    script_name character varying(20) not null,
+    new_owner_name character varying(20) not null,
+    revision_id bigint not null,
+    create_time timestamp with time zone not null default now(),
+
+    primary key (owner_name, script_name),
+
+    foreign key (owner_name, script_name) references scripts (owner_name, script_name)
+        on update cascade
+        on delete cascade,
+
+    foreign key (new_owner_name) references accounts (name)
+        on update cascade
+        on delete cascade
+);
+
+create table schedules (
+    schedule_id bigserial primary key not null,
+    owner_name character varying(20) not null,
+    script_name character varying(20) not null,
+    spec character varying not null,
+    time_zone character varying not null,
+    bridge_target character varying not null,
+    bridge_name character varying not null,
+    group_id character varying not null,
+    context bytea not null,
+    stdin bytea not null,
+    next_run_time timestamp with time zone not null,
+
+    foreign key (owner_name, script_name) references scripts (owner_name, script_name)
+        on update cascade
+        on delete cascade
+);
+
+create index schedules_next_run_time_idx on schedules (next_run_time);
+create index schedules_group_idx on schedules (bridge_name, group_id);
+
 create table account_identifiers (
     account_name character varying(20) not null,
-    visibility smallint not null,
+    identifier character varying(64) not null,
+    visibility smallint not null default 0,
 
     primary key (identifier, account_name),
 
@@ -50,3 +171,92 @@ create table account_identifiers (
 
 create index account_identifiers_identifier_idx on account_identifiers (identifier);
 create index account_identifiers_account_name_idx on account_identifiers (account_name);
+
+create table access_tokens (
+    token_id character varying(16) primary key not null,
+    account_name character varying(20) not null,
+    token_name character varying(64) not null,
+    token_hash bytea not null,
+    scopes character varying[] not null,
+    create_time timestamp with time zone not null default now(),
+    expire_time timestamp with time zone,
+    last_use_time timestamp with time zone,
+
+    unique (account_name, token_name),
+
+    foreign key (account_name) references accounts (name)
+        on update cascade
+        on delete cascade
+);
+
+create unique index access_tokens_token_hash_idx on access_tokens (token_hash);
+
+create table sessions (
+    session_id character varying(16) primary key not null,
+    account_name character varying(20) not null,
+    refresh_token_hash bytea not null,
+    create_time timestamp with time zone not null default now(),
+    expire_time timestamp with time zone not null,
+
+    foreign key (account_name) references accounts (name)
+        on update cascade
+        on delete cascade
+);
+
+create unique index sessions_refresh_token_hash_idx on sessions (refresh_token_hash);
+create index sessions_account_name_idx on sessions (account_name);
+
+create table revoked_tokens (
+    token_id character varying(32) primary key not null,
+    expire_time timestamp with time zone not null
+);
+
+create table invite_codes (
+    code character varying(16) primary key not null,
+    create_time timestamp with time zone not null default now(),
+    expire_time timestamp with time zone
+);
+
+create table login_failures (
+    throttle_key character varying(80) primary key not null,
+    failure_count integer not null,
+    last_failure_time timestamp with time zone not null,
+    locked_until timestamp with time zone
+);
+
+create table login_attempts (
+    attempt_id bigserial primary key not null,
+    account_name character varying(20) not null,
+    attempt_time timestamp with time zone not null default now(),
+    source character varying(64) not null,
+    method character varying(16) not null,
+    success boolean not null,
+
+    foreign key (account_name) references accounts (name)
+        on update cascade
+        on delete cascade
+);
+
+create index login_attempts_account_name_attempt_time_idx on login_attempts (account_name, attempt_time);
+
+create table account_link_codes (
+    code character varying(16) primary key not null,
+    identifier character varying(64) not null,
+    expire_time timestamp with time zone not null
+);
+
+create index account_link_codes_identifier_idx on account_link_codes (identifier);
+
+create table secrets (
+    owner_name character varying(20) not null,
+    secret_name character varying(64) not null,
+    nonce bytea not null,
+    ciphertext bytea not null,
+    update_time timestamp with time zone not null default now(),
+
+    primary key (owner_name, secret_name),
+
+    foreign key (owner_name) references accounts (name)
+        on update cascade
+        on delete cascade
+);
@@ -0,0 +1,384 @@
+package scripts
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+)
+
+var (
+	ErrInvalidBundle  error = errors.New("scripts: invalid bundle")
+	ErrBundleTooLarge       = errors.New("scripts: bundle too large")
+)
+
+// bundlesDir is the directory in an account's scripts storage holding extracted bundles. Script names cannot start
+// with a dot, so it never collides with a script.
+const bundlesDir = ".bundles"
+
+func (s *Script) BundlePath() string {
+	return filepath.Join(s.storageRootPath, s.OwnerName, "scripts", bundlesDir, s.Name)
+}
+
+// cleanBundlePath turns a path inside an archive into a relative, slash-separated path, or returns ErrInvalidBundle
+// if it would escape the bundle.
+func cleanBundlePath(name string) (string, error) {
+	name = strings.TrimPrefix(name, "./")
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", ErrInvalidBundle
+		}
+	}
+
+	return strings.TrimPrefix(path.Clean("/"+name), "/"), nil
+}
+
+type bundleExtractor struct {
+	root string
+
+	maxSize  int64
+	maxFiles int
+
+	size  int64
+	files int
+}
+
+func (e *bundleExtractor) addDir(name string) error {
+	name, err := cleanBundlePath(name)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return nil
+	}
+
+	e.files++
+	if e.files > e.maxFiles {
+		return ErrBundleTooLarge
+	}
+
+	return os.MkdirAll(filepath.Join(e.root, filepath.FromSlash(name)), 0755)
+}
+
+func (e *bundleExtractor) addFile(name string, mode os.FileMode, r io.Reader) error {
+	name, err := cleanBundlePath(name)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return ErrInvalidBundle
+	}
+
+	e.files++
+	if e.files > e.maxFiles {
+		return ErrBundleTooLarge
+	}
+
+	p := filepath.Join(e.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	perm := os.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		if os.IsExist(err) {
+			return ErrInvalidBundle
+		}
+		return err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, io.LimitReader(r, e.maxSize-e.size+1))
+	if err != nil {
+		return err
+	}
+
+	e.size += n
+	if e.size > e.maxSize {
+		return ErrBundleTooLarge
+	}
+
+	return f.Close()
+}
+
+func (e *bundleExtractor) extractTar(archive []byte) error {
+	var r io.Reader = bytes.NewReader(archive)
+	if bytes.HasPrefix(archive, []byte{0x1f, 0x8b}) {
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return ErrInvalidBundle
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return ErrInvalidBundle
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = e.addDir(hdr.Name)
+		case tar.TypeReg, tar.TypeRegA:
+			err = e.addFile(hdr.Name, os.FileMode(hdr.Mode), tr)
+		case tar.TypeXGlobalHeader:
+		default:
+			err = ErrInvalidBundle
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (e *bundleExtractor) extractZip(archive []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return ErrInvalidBundle
+	}
+
+	for _, f := range zr.File {
+		mode := f.Mode()
+
+		if mode.IsDir() {
+			if err := e.addDir(f.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !mode.IsRegular() {
+			return ErrInvalidBundle
+		}
+
+		if err := func() error {
+			r, err := f.Open()
+			if err != nil {
+				return ErrInvalidBundle
+			}
+			defer r.Close()
+
+			return e.addFile(f.Name, mode, r)
+		}(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetBundle replaces the script's bundle with the contents of an archive. The entrypoint is the path of the file in the
+// bundle to execute in place of the script's content. Bundles are not part of revisions.
+func (s *Store) SetBundle(ctx context.Context, script *Script, archive []byte, format scriptspb.SetBundleRequest_Format, entrypoint string) error {
+	entrypoint, err := cleanBundlePath(entrypoint)
+	if err != nil || entrypoint == "" {
+		return ErrInvalidBundle
+	}
+
+	bundleRoot := filepath.Dir(script.BundlePath())
+	if err := os.MkdirAll(bundleRoot, 0755); err != nil {
+		return err
+	}
+
+	stagingPath, err := ioutil.TempDir(bundleRoot, "."+script.Name+".")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stagingPath)
+
+	e := &bundleExtractor{
+		root:     filepath.Join(stagingPath, "new"),
+		maxSize:  s.maxBundleSize,
+		maxFiles: s.maxBundleFiles,
+	}
+
+	if err := os.Mkdir(e.root, 0755); err != nil {
+		return err
+	}
+
+	switch format {
+	case scriptspb.SetBundleRequest_TAR:
+		err = e.extractTar(archive)
+	case scriptspb.SetBundleRequest_ZIP:
+		err = e.extractZip(archive)
+	default:
+		err = ErrInvalidBundle
+	}
+	if err != nil {
+		return err
+	}
+
+	entrypointPath := filepath.Join(e.root, filepath.FromSlash(entrypoint))
+	fi, err := os.Lstat(entrypointPath)
+	if err != nil || !fi.Mode().IsRegular() {
+		return ErrInvalidBundle
+	}
+
+	if err := os.Chmod(entrypointPath, 0755); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockScript(ctx, tx, script.OwnerName, script.Name); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		update scripts
+		set bundle_entrypoint = $1,
+		    update_time = now()
+		where owner_name = $2 and
+		      script_name = $3
+	`, entrypoint, script.OwnerName, script.Name); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(script.BundlePath(), filepath.Join(stagingPath, "old")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Rename(e.root, script.BundlePath())
+}
+
+// DeleteBundle removes the script's bundle, so its content is executed again.
+func (s *Script) DeleteBundle(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `
+		update scripts
+		set bundle_entrypoint = '',
+		    update_time = now()
+		where owner_name = $1 and
+		      script_name = $2
+	`, s.OwnerName, s.Name); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(s.BundlePath())
+}
+
+// WriteBundle writes the script's bundle to w as a gzipped tar archive. If the script has no bundle, ErrNotFound is
+// returned.
+func (s *Script) WriteBundle(ctx context.Context, w io.Writer) error {
+	root := s.BundlePath()
+	if _, err := os.Stat(root); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+
+	if err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gzw.Close()
+}
+
+// copyBundle copies a bundle directory, if it exists, from src to dst.
+func copyBundle(src string, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(target, content, fi.Mode().Perm())
+	})
+}
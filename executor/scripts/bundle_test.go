@@ -0,0 +1,246 @@
+package scripts
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanBundlePath(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		want  string
+		valid bool
+	}{
+		{"main.py", "main.py", true},
+		{"./main.py", "main.py", true},
+		{"lib/util.py", "lib/util.py", true},
+		{"lib/", "lib", true},
+		{"lib//./util.py", "lib/util.py", true},
+		{"/etc/passwd", "etc/passwd", true},
+		{".", "", true},
+		{"", "", true},
+		{"..", "", false},
+		{"../main.py", "", false},
+		{"lib/../../main.py", "", false},
+		{"lib/../main.py", "", false},
+	} {
+		got, err := cleanBundlePath(c.name)
+		if valid := err == nil; valid != c.valid {
+			t.Errorf("cleanBundlePath(%q) = %v, want valid = %v", c.name, err, c.valid)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("cleanBundlePath(%q) = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+type bundleEntry struct {
+	name    string
+	dir     bool
+	symlink bool
+	content string
+}
+
+func makeTar(t *testing.T, entries []bundleEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Mode: 0644}
+		switch {
+		case entry.dir:
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+		case entry.symlink:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = entry.content
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(entry.content))
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(entry.content)); err != nil {
+				t.Fatalf("failed to write tar entry: %v", err)
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func makeZip(t *testing.T, entries []bundleEntry) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, entry := range entries {
+		hdr := &zip.FileHeader{Name: entry.name}
+		switch {
+		case entry.dir:
+			hdr.SetMode(os.ModeDir | 0755)
+		case entry.symlink:
+			hdr.SetMode(os.ModeSymlink | 0777)
+		default:
+			hdr.SetMode(0644)
+		}
+
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatalf("failed to write zip header: %v", err)
+		}
+		if !entry.dir {
+			if _, err := w.Write([]byte(entry.content)); err != nil {
+				t.Fatalf("failed to write zip entry: %v", err)
+			}
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBundleExtractor(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		entries  []bundleEntry
+		maxSize  int64
+		maxFiles int
+		want     error
+	}{
+		{
+			name: "files and directories",
+			entries: []bundleEntry{
+				{name: "lib/", dir: true},
+				{name: "lib/util.py", content: "pass"},
+				{name: "main.py", content: "import lib"},
+			},
+			maxSize:  100,
+			maxFiles: 3,
+		},
+		{
+			name: "at size limit",
+			entries: []bundleEntry{
+				{name: "a", content: "12345"},
+				{name: "b", content: "12345"},
+			},
+			maxSize:  10,
+			maxFiles: 10,
+		},
+		{
+			name: "over size limit",
+			entries: []bundleEntry{
+				{name: "a", content: "12345"},
+				{name: "b", content: "123456"},
+			},
+			maxSize:  10,
+			maxFiles: 10,
+			want:     ErrBundleTooLarge,
+		},
+		{
+			name: "over file limit",
+			entries: []bundleEntry{
+				{name: "a"},
+				{name: "b"},
+				{name: "c"},
+			},
+			maxSize:  10,
+			maxFiles: 2,
+			want:     ErrBundleTooLarge,
+		},
+		{
+			name: "directories count as files",
+			entries: []bundleEntry{
+				{name: "a/", dir: true},
+				{name: "b/", dir: true},
+				{name: "c/", dir: true},
+			},
+			maxSize:  10,
+			maxFiles: 2,
+			want:     ErrBundleTooLarge,
+		},
+		{
+			name: "escaping path",
+			entries: []bundleEntry{
+				{name: "../main.py", content: "pass"},
+			},
+			maxSize:  100,
+			maxFiles: 10,
+			want:     ErrInvalidBundle,
+		},
+		{
+			name: "duplicate file",
+			entries: []bundleEntry{
+				{name: "main.py", content: "pass"},
+				{name: "./main.py", content: "pass"},
+			},
+			maxSize:  100,
+			maxFiles: 10,
+			want:     ErrInvalidBundle,
+		},
+		{
+			name: "symlink",
+			entries: []bundleEntry{
+				{name: "main.py", symlink: true, content: "/etc/passwd"},
+			},
+			maxSize:  100,
+			maxFiles: 10,
+			want:     ErrInvalidBundle,
+		},
+	} {
+		for _, format := range []struct {
+			name    string
+			archive []byte
+			extract func(*bundleExtractor, []byte) error
+		}{
+			{"tar", makeTar(t, c.entries), (*bundleExtractor).extractTar},
+			{"zip", makeZip(t, c.entries), (*bundleExtractor).extractZip},
+		} {
+			func() {
+				root, err := ioutil.TempDir("", "bundle")
+				if err != nil {
+					t.Fatalf("failed to create temporary directory: %v", err)
+				}
+				defer os.RemoveAll(root)
+
+				e := &bundleExtractor{
+					root:     root,
+					maxSize:  c.maxSize,
+					maxFiles: c.maxFiles,
+				}
+
+				if err := format.extract(e, format.archive); err != c.want {
+					t.Errorf("%s (%s): extract = %v, want %v", c.name, format.name, err, c.want)
+					return
+				}
+				if c.want != nil {
+					return
+				}
+
+				for _, entry := range c.entries {
+					fi, err := os.Stat(filepath.Join(root, filepath.FromSlash(entry.name)))
+					if err != nil {
+						t.Errorf("%s (%s): %s was not extracted: %v", c.name, format.name, entry.name, err)
+						continue
+					}
+					if fi.IsDir() != entry.dir {
+						t.Errorf("%s (%s): %s is a directory = %v, want %v", c.name, format.name, entry.name, fi.IsDir(), entry.dir)
+					}
+				}
+			}()
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package scripts
+
+import (
+	"bytes"
+	"strings"
+)
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+// Diff returns a line-based diff turning a into b. Each line of the output is prefixed with " " if it is unchanged,
+// "-" if it was removed, or "+" if it was added.
+func Diff(a []byte, b []byte) string {
+	aLines := splitLines(a)
+	bLines := splitLines(b)
+
+	// lcs[i][j] holds the length of the longest common subsequence of aLines[i:] and bLines[j:].
+	lcs := make([][]int, len(aLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bLines)+1)
+	}
+
+	for i := len(aLines) - 1; i >= 0; i-- {
+		for j := len(bLines) - 1; j >= 0; j-- {
+			if aLines[i] == bLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+
+	i, j := 0, 0
+	for i < len(aLines) && j < len(bLines) {
+		switch {
+		case aLines[i] == bLines[j]:
+			buf.WriteString(" " + aLines[i] + "\n")
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			buf.WriteString("-" + aLines[i] + "\n")
+			i++
+		default:
+			buf.WriteString("+" + bLines[j] + "\n")
+			j++
+		}
+	}
+
+	for ; i < len(aLines); i++ {
+		buf.WriteString("-" + aLines[i] + "\n")
+	}
+
+	for ; j < len(bLines); j++ {
+		buf.WriteString("+" + bLines[j] + "\n")
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,257 @@
+package scripts
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+
+	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+)
+
+func hashContent(content []byte) string {
+	h := sha256.Sum256(content)
+	return hex.EncodeToString(h[:])
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// lockScript locks the script row for the rest of tx, so concurrent writers are serialized.
+func lockScript(ctx context.Context, tx *sql.Tx, ownerName string, name string) error {
+	_, err := tx.ExecContext(ctx, `
+		select 1
+		from scripts
+		where owner_name = $1 and
+		      script_name = $2
+		for update
+	`, ownerName, name)
+	return err
+}
+
+func latestRevisionID(ctx context.Context, q queryRower, ownerName string, name string) (uint64, error) {
+	var id uint64
+	if err := q.QueryRowContext(ctx, `
+		select coalesce(max(revision_id), 0)
+		from script_revisions
+		where owner_name = $1 and
+		      script_name = $2
+	`, ownerName, name).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// insertRevision records a new immutable revision of a script as part of tx.
+func insertRevision(ctx context.Context, tx *sql.Tx, ownerName string, name string, authorName string, content []byte, meta *scriptspb.Meta) (*scriptspb.Revision, error) {
+	if err := lockScript(ctx, tx, ownerName, name); err != nil {
+		return nil, err
+	}
+
+	revision := &scriptspb.Revision{
+		AuthorName:  authorName,
+		ContentHash: hashContent(content),
+		Meta: &scriptspb.Meta{
+			Description: meta.Description,
+			Visibility:  meta.Visibility,
+			Parameter:   meta.Parameter,
+			Runtime:     meta.Runtime,
+			Dependency:  meta.Dependency,
+			Secret:      meta.Secret,
+		},
+	}
+
+	rawParameters, err := marshalParameters(meta.Parameter)
+	if err != nil {
+		return nil, err
+	}
+
+	latestID, err := latestRevisionID(ctx, tx, ownerName, name)
+	if err != nil {
+		return nil, err
+	}
+	revision.Id = latestID + 1
+
+	var createTime time.Time
+	if err := tx.QueryRowContext(ctx, `
+		insert into script_revisions (owner_name, script_name, revision_id, author_name, content_hash, content, description, visibility, parameters, runtime, dependencies, secrets)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		returning create_time
+	`, ownerName, name, revision.Id, authorName, revision.ContentHash, content, meta.Description, meta.Visibility, rawParameters, meta.Runtime, pq.Array(meta.Dependency), pq.Array(meta.Secret)).Scan(&createTime); err != nil {
+		return nil, err
+	}
+	revision.CreateTime = createTime.Unix()
+
+	if _, err := tx.ExecContext(ctx, `
+		update scripts
+		set update_time = $1
+		where owner_name = $2 and
+		      script_name = $3
+	`, createTime, ownerName, name); err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
+
+// LatestRevisionID returns the ID of the script's current revision, or 0 if it has none. It doubles as an entity tag
+// for optimistic concurrency control.
+func (s *Script) LatestRevisionID(ctx context.Context) (uint64, error) {
+	return latestRevisionID(ctx, s.db, s.OwnerName, s.Name)
+}
+
+func (s *Script) RecordRevision(ctx context.Context, authorName string) (*scriptspb.Revision, error) {
+	content, err := s.Content(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := s.Meta(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	revision, err := insertRevision(ctx, tx, s.OwnerName, s.Name, authorName, content, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
+
+func (s *Script) Revisions(ctx context.Context, offset, limit uint32) ([]*scriptspb.Revision, error) {
+	revisions := make([]*scriptspb.Revision, 0)
+
+	rows, err := s.db.QueryContext(ctx, `
+		select revision_id, author_name, create_time, content_hash, description, visibility
+		from script_revisions
+		where owner_name = $1 and
+		      script_name = $2
+		order by revision_id desc
+		offset $3 limit $4
+	`, s.OwnerName, s.Name, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		revision := &scriptspb.Revision{
+			Meta: &scriptspb.Meta{},
+		}
+		var createTime time.Time
+		if err := rows.Scan(&revision.Id, &revision.AuthorName, &createTime, &revision.ContentHash, &revision.Meta.Description, &revision.Meta.Visibility); err != nil {
+			return nil, err
+		}
+		revision.CreateTime = createTime.Unix()
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (s *Script) Revision(ctx context.Context, id uint64) (*scriptspb.Revision, []byte, error) {
+	revision := &scriptspb.Revision{
+		Id:   id,
+		Meta: &scriptspb.Meta{},
+	}
+
+	var createTime time.Time
+	var content []byte
+	var rawParameters []byte
+	if err := s.db.QueryRowContext(ctx, `
+		select author_name, create_time, content_hash, content, description, visibility, parameters, runtime, dependencies, secrets
+		from script_revisions
+		where owner_name = $1 and
+		      script_name = $2 and
+		      revision_id = $3
+	`, s.OwnerName, s.Name, id).Scan(&revision.AuthorName, &createTime, &revision.ContentHash, &content, &revision.Meta.Description, &revision.Meta.Visibility, &rawParameters, &revision.Meta.Runtime, pq.Array(&revision.Meta.Dependency), pq.Array(&revision.Meta.Secret)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+	revision.CreateTime = createTime.Unix()
+
+	parameters, err := unmarshalParameters(rawParameters)
+	if err != nil {
+		return nil, nil, err
+	}
+	revision.Meta.Parameter = parameters
+
+	return revision, content, nil
+}
+
+// Rollback restores the content and meta of a previous revision. The rollback itself is recorded as a new revision, so
+// history is never rewritten.
+func (s *Script) Rollback(ctx context.Context, id uint64, authorName string) (*scriptspb.Revision, error) {
+	target, content, err := s.Revision(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	revision, err := insertRevision(ctx, tx, s.OwnerName, s.Name, authorName, content, target.Meta)
+	if err != nil {
+		return nil, err
+	}
+
+	rawParameters, err := marshalParameters(target.Meta.Parameter)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		update scripts
+		set description = $1,
+		    visibility = $2,
+		    parameters = $3,
+		    runtime = $4,
+		    dependencies = $5,
+		    secrets = $6
+		where owner_name = $7 and
+		      script_name = $8
+	`, target.Meta.Description, target.Meta.Visibility, rawParameters, target.Meta.Runtime, pq.Array(target.Meta.Dependency), pq.Array(target.Meta.Secret), s.OwnerName, s.Name); err != nil {
+		return nil, err
+	}
+
+	// As in Update, the content is only moved into place once the revision has committed.
+	tempPath, err := writeTempFile(s.Path(), content, 0755)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tempPath)
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tempPath, s.Path()); err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
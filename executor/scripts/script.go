@@ -6,7 +6,11 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"github.com/lib/pq"
 	"golang.org/x/net/context"
 
 	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
@@ -36,22 +40,88 @@ func (s *Script) Content(ctx context.Context) ([]byte, error) {
 	return ioutil.ReadFile(s.Path())
 }
 
+// writeTempFile writes content to a temporary file next to path, for renaming over it later. The caller is responsible
+// for removing the file if it is never renamed.
+func writeTempFile(path string, content []byte, perm os.FileMode) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	tempPath, err := writeTempFile(path, content, perm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath)
+
+	return os.Rename(tempPath, path)
+}
+
 func (s *Script) SetContent(ctx context.Context, content []byte) error {
-	return ioutil.WriteFile(s.Path(), content, 0755)
+	return writeFileAtomic(s.Path(), content, 0755)
+}
+
+// marshalParameters encodes parameter declarations for storage, as a Meta holding nothing else.
+func marshalParameters(params []*scriptspb.Parameter) ([]byte, error) {
+	return proto.Marshal(&scriptspb.Meta{
+		Parameter: params,
+	})
+}
+
+func unmarshalParameters(raw []byte) ([]*scriptspb.Parameter, error) {
+	meta := &scriptspb.Meta{}
+	if err := proto.Unmarshal(raw, meta); err != nil {
+		return nil, err
+	}
+	return meta.Parameter, nil
 }
 
 func (s *Script) Meta(ctx context.Context) (*scriptspb.Meta, error) {
 	meta := &scriptspb.Meta{}
 
+	var createTime time.Time
+	var updateTime time.Time
+	var rawParameters []byte
+
 	if err := s.db.QueryRowContext(ctx, `
-		select description, visibility
+		select description, visibility, create_time, update_time, forked_from, parameters, runtime, dependencies, secrets, bundle_entrypoint
 		from scripts
 		where owner_name = $1 and
 		      script_name = $2
-	`, s.OwnerName, s.Name).Scan(&meta.Description, &meta.Visibility); err != nil {
+	`, s.OwnerName, s.Name).Scan(&meta.Description, &meta.Visibility, &createTime, &updateTime, &meta.ForkedFrom, &rawParameters, &meta.Runtime, pq.Array(&meta.Dependency), pq.Array(&meta.Secret), &meta.BundleEntrypoint); err != nil {
 		return nil, err
 	}
 
+	meta.CreateTime = createTime.Unix()
+	meta.UpdateTime = updateTime.Unix()
+
+	parameters, err := unmarshalParameters(rawParameters)
+	if err != nil {
+		return nil, err
+	}
+	meta.Parameter = parameters
+
 	return meta, nil
 }
 
@@ -60,18 +130,352 @@ func (s *Script) SetMeta(ctx context.Context, meta *scriptspb.Meta) error {
 		return ErrInvalid
 	}
 
+	rawParameters, err := marshalParameters(meta.Parameter)
+	if err != nil {
+		return err
+	}
+
 	if _, err := s.db.ExecContext(ctx, `
 		update scripts
 		set description = $1,
-		    visibility = $2
-		where owner_name = $3 and
-		      script_name = $4
-	`, meta.Description, meta.Visibility, s.OwnerName, s.Name); err != nil {
+		    visibility = $2,
+		    parameters = $3,
+		    runtime = $4,
+		    dependencies = $5,
+		    secrets = $6,
+		    update_time = now()
+		where owner_name = $7 and
+		      script_name = $8
+	`, meta.Description, meta.Visibility, rawParameters, meta.Runtime, pq.Array(meta.Dependency), pq.Array(meta.Secret), s.OwnerName, s.Name); err != nil {
 		return err
 	}
 	return nil
 }
 
+// Update replaces the content and meta of the script, optionally renaming it, as a single revision. Votes and any other
+// state attached to the script are preserved. If expectedRevisionID is nonzero and the script is no longer at that
+// revision, ErrConflict is returned.
+func (s *Script) Update(ctx context.Context, authorName string, expectedRevisionID uint64, newName string, meta *scriptspb.Meta, content []byte) (*scriptspb.Revision, error) {
+	if meta.Visibility > scriptspb.Visibility_PUBLISHED {
+		return nil, ErrInvalid
+	}
+
+	if newName == "" {
+		newName = s.Name
+	}
+
+	renamed := newName != s.Name
+
+	newScript := &Script{
+		db:              s.db,
+		storageRootPath: s.storageRootPath,
+
+		OwnerName: s.OwnerName,
+		Name:      newName,
+	}
+
+	if !nameRegexp.MatchString(newName) || filepath.Dir(newScript.Path()) != filepath.Dir(s.Path()) {
+		return nil, ErrInvalidName
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockScript(ctx, tx, s.OwnerName, s.Name); err != nil {
+		return nil, err
+	}
+
+	if expectedRevisionID != 0 {
+		currentRevisionID, err := latestRevisionID(ctx, tx, s.OwnerName, s.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if currentRevisionID != expectedRevisionID {
+			return nil, ErrConflict
+		}
+	}
+
+	if renamed {
+		if _, err := os.Stat(newScript.Path()); err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+		} else {
+			return nil, ErrAlreadyExists
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			update scripts
+			set script_name = $1
+			where owner_name = $2 and
+			      script_name = $3
+		`, newName, s.OwnerName, s.Name); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" /* unique_violation */ {
+				return nil, ErrAlreadyExists
+			}
+			return nil, err
+		}
+	}
+
+	rawParameters, err := marshalParameters(meta.Parameter)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		update scripts
+		set description = $1,
+		    visibility = $2,
+		    parameters = $3,
+		    runtime = $4,
+		    dependencies = $5,
+		    secrets = $6
+		where owner_name = $7 and
+		      script_name = $8
+	`, meta.Description, meta.Visibility, rawParameters, meta.Runtime, pq.Array(meta.Dependency), pq.Array(meta.Secret), s.OwnerName, newName); err != nil {
+		return nil, err
+	}
+
+	revision, err := insertRevision(ctx, tx, s.OwnerName, newName, authorName, content, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	// The content is only moved into place once the revision has committed, so the file always matches a revision.
+	tempPath, err := writeTempFile(newScript.Path(), content, 0755)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tempPath)
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tempPath, newScript.Path()); err != nil {
+		return nil, err
+	}
+
+	if renamed {
+		if err := os.Remove(s.Path()); err != nil {
+			glog.Errorf("Failed to remove old script file: %v", err)
+		}
+		if err := os.Rename(s.BundlePath(), newScript.BundlePath()); err != nil && !os.IsNotExist(err) {
+			glog.Errorf("Failed to move script bundle: %v", err)
+		}
+		s.Name = newName
+	}
+
+	return revision, nil
+}
+
+// OfferTransfer offers the script, as it is at its latest revision, to another account, replacing any earlier offer.
+// Nothing moves until that account accepts with TransferOwnership.
+func (s *Script) OfferTransfer(ctx context.Context, newOwnerName string) error {
+	if !nameRegexp.MatchString(newOwnerName) || newOwnerName == s.OwnerName {
+		return ErrInvalidName
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockScript(ctx, tx, s.OwnerName, s.Name); err != nil {
+		return err
+	}
+
+	revisionID, err := latestRevisionID(ctx, tx, s.OwnerName, s.Name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		insert into script_transfers (owner_name, script_name, new_owner_name, revision_id)
+		values ($1, $2, $3, $4)
+		on conflict (owner_name, script_name) do update
+		set new_owner_name = excluded.new_owner_name,
+		    revision_id = excluded.revision_id,
+		    create_time = now()
+	`, s.OwnerName, s.Name, newOwnerName, revisionID); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" /* foreign_key_violation */ {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelTransfer withdraws the script's transfer offer.
+func (s *Script) CancelTransfer(ctx context.Context) error {
+	r, err := s.db.ExecContext(ctx, `
+		delete from script_transfers
+		where owner_name = $1 and
+		      script_name = $2
+	`, s.OwnerName, s.Name)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// TransferOwnership moves the script, along with its revisions and votes, into another account, which accepts the
+// script's transfer offer by doing so. ErrNoTransferOffer is returned if the script has not been offered to the
+// account, and ErrConflict if it has changed since it was offered.
+//
+// Its executions and schedules belong to the previous owner and whoever set them up, so they are deleted rather than
+// handed over. The secrets it was given name the previous owner's secrets, so they are cleared, including in its
+// revisions.
+func (s *Script) TransferOwnership(ctx context.Context, newOwnerName string) error {
+	if !nameRegexp.MatchString(newOwnerName) {
+		return ErrInvalidName
+	}
+
+	newScript := &Script{
+		db:              s.db,
+		storageRootPath: s.storageRootPath,
+
+		OwnerName: newOwnerName,
+		Name:      s.Name,
+	}
+
+	if filepath.Dir(newScript.Path()) != filepath.Join(s.storageRootPath, newOwnerName, "scripts") {
+		return ErrInvalidName
+	}
+
+	if _, err := os.Stat(newScript.Path()); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		return ErrAlreadyExists
+	}
+
+	content, err := s.Content(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockScript(ctx, tx, s.OwnerName, s.Name); err != nil {
+		return err
+	}
+
+	var offeredRevisionID uint64
+	if err := tx.QueryRowContext(ctx, `
+		delete from script_transfers
+		where owner_name = $1 and
+		      script_name = $2 and
+		      new_owner_name = $3
+		returning revision_id
+	`, s.OwnerName, s.Name, newOwnerName).Scan(&offeredRevisionID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNoTransferOffer
+		}
+		return err
+	}
+
+	currentRevisionID, err := latestRevisionID(ctx, tx, s.OwnerName, s.Name)
+	if err != nil {
+		return err
+	}
+
+	if currentRevisionID != offeredRevisionID {
+		return ErrConflict
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		delete from executions
+		where owner_name = $1 and
+		      script_name = $2
+	`, s.OwnerName, s.Name); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		delete from schedules
+		where owner_name = $1 and
+		      script_name = $2
+	`, s.OwnerName, s.Name); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		update scripts
+		set owner_name = $1,
+		    secrets = '{}'
+		where owner_name = $2 and
+		      script_name = $3
+	`, newOwnerName, s.OwnerName, s.Name); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case "23505": // unique_violation
+				return ErrAlreadyExists
+			case "23503": // foreign_key_violation
+				return ErrNotFound
+			}
+		}
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		update script_revisions
+		set secrets = '{}'
+		where owner_name = $1 and
+		      script_name = $2
+	`, newOwnerName, s.Name); err != nil {
+		return err
+	}
+
+	// Account storage roots are separate filesystems, so the file must be copied rather than renamed.
+	if err := newScript.SetContent(ctx, content); err != nil {
+		return err
+	}
+
+	if err := copyBundle(s.BundlePath(), newScript.BundlePath()); err != nil {
+		os.Remove(newScript.Path())
+		os.RemoveAll(newScript.BundlePath())
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		os.Remove(newScript.Path())
+		os.RemoveAll(newScript.BundlePath())
+		return err
+	}
+
+	if err := os.Remove(s.Path()); err != nil {
+		glog.Errorf("Failed to remove old script file: %v", err)
+	}
+	if err := os.RemoveAll(s.BundlePath()); err != nil {
+		glog.Errorf("Failed to remove old script bundle: %v", err)
+	}
+	s.OwnerName = newOwnerName
+
+	return nil
+}
+
 func (s *Script) Vote(ctx context.Context, delta int) error {
 	if _, err := s.db.ExecContext(ctx, `
 		update scripts
@@ -84,25 +488,49 @@ func (s *Script) Vote(ctx context.Context, delta int) error {
 	return nil
 }
 
-func (s *Script) Delete(ctx context.Context) error {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
+func (s *Script) RecordUse(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `
+		update scripts
+		set uses = uses + 1
+		where owner_name = $1 and
+		      script_name = $2
+	`, s.OwnerName, s.Name); err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	return nil
+}
 
-	if _, err := tx.ExecContext(ctx, `
+// Delete removes the script. If expectedRevisionID is nonzero and the script is no longer at that revision, ErrConflict
+// is returned.
+func (s *Script) Delete(ctx context.Context, expectedRevisionID uint64) error {
+	res, err := s.db.ExecContext(ctx, `
 		delete from scripts
 		where owner_name = $1 and
-		      script_name = $2
-	`, s.OwnerName, s.Name); err != nil {
+		      script_name = $2 and
+		      ($3 = 0 or (
+		          select coalesce(max(revision_id), 0)
+		          from script_revisions
+		          where owner_name = $1 and
+		                script_name = $2
+		      ) = $3)
+	`, s.OwnerName, s.Name, expectedRevisionID)
+	if err != nil {
 		return err
 	}
 
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrConflict
+	}
+
 	if err := os.Remove(s.Path()); err != nil {
-		return nil
+		glog.Errorf("Failed to remove script file: %v", err)
 	}
+	os.RemoveAll(s.BundlePath())
 
-	tx.Commit()
 	return nil
 }
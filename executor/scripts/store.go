@@ -15,26 +15,37 @@ import (
 )
 
 var (
-	ErrInvalidName   error = errors.New("scripts: invalid name")
-	ErrAlreadyExists       = errors.New("scripts: already exists")
-	ErrNotFound            = errors.New("scripts: not found")
+	ErrInvalidName     error = errors.New("scripts: invalid name")
+	ErrAlreadyExists         = errors.New("scripts: already exists")
+	ErrNotFound              = errors.New("scripts: not found")
+	ErrConflict              = errors.New("scripts: conflict")
+	ErrNoTransferOffer       = errors.New("scripts: no transfer offer")
 )
 
 type Store struct {
 	db              *sql.DB
 	storageRootPath string
+
+	maxBundleSize  int64
+	maxBundleFiles int
 }
 
-func NewStore(db *sql.DB, storageRootPath string) *Store {
+func NewStore(db *sql.DB, storageRootPath string, maxBundleSize int64, maxBundleFiles int) *Store {
 	return &Store{
 		db:              db,
 		storageRootPath: storageRootPath,
+
+		maxBundleSize:  maxBundleSize,
+		maxBundleFiles: maxBundleFiles,
 	}
 }
 
 var sortOrderClauses map[scriptspb.ListRequest_SortOrder]string = map[scriptspb.ListRequest_SortOrder]string{
-	scriptspb.ListRequest_DEFAULT: "",
-	scriptspb.ListRequest_VOTES:   "votes desc",
+	scriptspb.ListRequest_DEFAULT:          "",
+	scriptspb.ListRequest_VOTES:            "votes desc",
+	scriptspb.ListRequest_RECENTLY_UPDATED: "update_time desc",
+	scriptspb.ListRequest_NEWEST:           "create_time desc",
+	scriptspb.ListRequest_MOST_USED:        "uses desc",
 }
 
 var nameRegexp = regexp.MustCompile(`^[a-z0-9_-]{1,20}$`)
@@ -94,6 +105,104 @@ func (s *Store) Create(ctx context.Context, ownerName string, name string) (*Scr
 	return script, nil
 }
 
+// Fork copies the content and description of source into a new, unpublished script that records where it was forked
+// from.
+func (s *Store) Fork(ctx context.Context, source *Script, ownerName string, name string, authorName string) (*Script, error) {
+	if !nameRegexp.MatchString(ownerName) || !nameRegexp.MatchString(name) {
+		return nil, ErrInvalidName
+	}
+
+	scriptRoot := filepath.Join(s.storageRootPath, ownerName, "scripts")
+	script := &Script{
+		db:              s.db,
+		storageRootPath: s.storageRootPath,
+
+		OwnerName: ownerName,
+		Name:      name,
+	}
+
+	if filepath.Dir(script.Path()) != scriptRoot {
+		return nil, ErrInvalidName
+	}
+
+	content, err := source.Content(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceMeta, err := source.Meta(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Secrets are not carried over: they name the source owner's secrets, which the fork's owner need not want to give
+	// it.
+	meta := &scriptspb.Meta{
+		Description:      sourceMeta.Description,
+		Visibility:       scriptspb.Visibility_UNPUBLISHED,
+		Parameter:        sourceMeta.Parameter,
+		Runtime:          sourceMeta.Runtime,
+		Dependency:       sourceMeta.Dependency,
+		BundleEntrypoint: sourceMeta.BundleEntrypoint,
+	}
+
+	rawParameters, err := marshalParameters(meta.Parameter)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		insert into scripts (owner_name, script_name, description, visibility, forked_from, parameters, runtime, dependencies, bundle_entrypoint)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, ownerName, name, meta.Description, meta.Visibility, source.QualifiedName(), rawParameters, meta.Runtime, pq.Array(meta.Dependency), meta.BundleEntrypoint); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case "23505": // unique_violation
+				return nil, ErrAlreadyExists
+			case "23503": // foreign_key_violation
+				return nil, ErrNotFound
+			}
+		}
+		return nil, err
+	}
+
+	if _, err := os.Stat(script.Path()); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New("script doesn't exist in db but exists on disk")
+	}
+
+	if _, err := insertRevision(ctx, tx, ownerName, name, authorName, content, meta); err != nil {
+		return nil, err
+	}
+
+	if err := script.SetContent(ctx, content); err != nil {
+		return nil, err
+	}
+
+	if err := copyBundle(source.BundlePath(), script.BundlePath()); err != nil {
+		os.Remove(script.Path())
+		os.RemoveAll(script.BundlePath())
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		os.Remove(script.Path())
+		os.RemoveAll(script.BundlePath())
+		return nil, err
+	}
+
+	return script, nil
+}
+
 func (s *Store) Open(ctx context.Context, ownerName string, name string) (*Script, error) {
 	var count int
 	if err := s.db.QueryRowContext(ctx, `
@@ -0,0 +1,92 @@
+package scriptsservice
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/porpoises/kobun4/executor/scripts"
+
+	pb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+)
+
+const maxDependencies = 16
+
+var errUnavailableDependency = errors.New("dependency unavailable")
+
+// openDependency looks up a script that a script owned by ownerName depends on. Scripts may only depend on published or
+// unlisted scripts, or on other scripts of the same owner.
+func (s *Service) openDependency(ctx context.Context, ownerName string, qualifiedName string) (*pb.WorkerExecutionRequest_Dependency, error) {
+	parts := strings.SplitN(qualifiedName, "/", 2)
+	if len(parts) != 2 {
+		return nil, errUnavailableDependency
+	}
+
+	script, err := s.scripts.Open(ctx, parts[0], parts[1])
+	if err != nil {
+		if err == scripts.ErrNotFound {
+			return nil, errUnavailableDependency
+		}
+		return nil, err
+	}
+
+	meta, err := script.Meta(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.Visibility == pb.Visibility_UNPUBLISHED && script.OwnerName != ownerName {
+		return nil, errUnavailableDependency
+	}
+
+	return &pb.WorkerExecutionRequest_Dependency{
+		OwnerName: script.OwnerName,
+		Name:      script.Name,
+		Bundle:    meta.BundleEntrypoint != "",
+	}, nil
+}
+
+func (s *Service) validateDependencies(ctx context.Context, ownerName string, name string, dependencies []string) error {
+	if len(dependencies) > maxDependencies {
+		return grpc.Errorf(codes.InvalidArgument, "too many dependencies, at most %d are allowed", maxDependencies)
+	}
+
+	seen := make(map[string]bool)
+	for _, dependency := range dependencies {
+		if seen[dependency] || dependency == ownerName+"/"+name {
+			return grpc.Errorf(codes.InvalidArgument, "invalid dependency %q", dependency)
+		}
+		seen[dependency] = true
+
+		if _, err := s.openDependency(ctx, ownerName, dependency); err != nil {
+			if err == errUnavailableDependency {
+				return grpc.Errorf(codes.InvalidArgument, "dependency %q is not a published script", dependency)
+			}
+			glog.Errorf("Failed to load dependency: %v", err)
+			return grpc.Errorf(codes.Internal, "failed to load dependency")
+		}
+	}
+
+	return nil
+}
+
+// resolveDependencies looks up the dependencies of a script to mount when it runs.
+func (s *Service) resolveDependencies(ctx context.Context, ownerName string, dependencies []string) ([]*pb.WorkerExecutionRequest_Dependency, error) {
+	resolved := make([]*pb.WorkerExecutionRequest_Dependency, len(dependencies))
+	for i, dependency := range dependencies {
+		dep, err := s.openDependency(ctx, ownerName, dependency)
+		if err != nil {
+			if err == errUnavailableDependency {
+				return nil, grpc.Errorf(codes.FailedPrecondition, "dependency %q is not available", dependency)
+			}
+			glog.Errorf("Failed to load dependency: %v", err)
+			return nil, grpc.Errorf(codes.Internal, "failed to load script")
+		}
+		resolved[i] = dep
+	}
+	return resolved, nil
+}
@@ -2,13 +2,14 @@ package scriptsservice
 
 import (
 	"bytes"
-	"fmt"
+	"crypto/rand"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"sort"
 	"sync"
 	"syscall"
 	"time"
@@ -16,14 +17,19 @@ import (
 	"github.com/djherbis/buffer/limio"
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
-	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 
 	"github.com/porpoises/kobun4/executor/accounts"
+	"github.com/porpoises/kobun4/executor/admission"
+	"github.com/porpoises/kobun4/executor/executions"
+	"github.com/porpoises/kobun4/executor/params"
+	"github.com/porpoises/kobun4/executor/runtimes"
 	"github.com/porpoises/kobun4/executor/scripts"
+	"github.com/porpoises/kobun4/executor/secrets"
+	"github.com/porpoises/kobun4/executor/warmpool"
 
 	pb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
 )
@@ -55,23 +61,62 @@ var (
 
 const maxBufferSize int64 = 5 * 1024 * 1024 // 5MB
 
+const maxSecrets = 16
+
+// validateSecretNames checks the names of the secrets a script is given. The secrets need not exist yet: names without a
+// secret are skipped when the script runs.
+func validateSecretNames(names []string) error {
+	if len(names) > maxSecrets {
+		return grpc.Errorf(codes.InvalidArgument, "too many secrets, at most %d are allowed", maxSecrets)
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] || !secrets.ValidName(name) {
+			return grpc.Errorf(codes.InvalidArgument, "invalid secret name %q", name)
+		}
+		seen[name] = true
+	}
+
+	return nil
+}
+
 type Service struct {
 	lis net.Listener
 
-	scripts  *scripts.Store
-	accounts *accounts.Store
+	scripts    *scripts.Store
+	accounts   *accounts.Store
+	executions *executions.Store
+	secrets    *secrets.Store
+
+	admission *admission.Controller
+	warmPool  *warmpool.Pool
 
-	nsenternetPath string
-	supervisorPath string
-	k4LibraryPath  string
+	runtimes *runtimes.Registry
 
-	chroot       string
-	parentCgroup string
+	k4LibraryPath string
 
-	executionID int64
+	runningMu sync.Mutex
+	running   map[string]*runningExecution
 }
 
-func New(lis net.Listener, scripts *scripts.Store, accounts *accounts.Store, nsenternetPath string, supervisorPath string, k4LibraryPath string, chroot string, parentCgroup string) *Service {
+type runningExecution struct {
+	execution *pb.Execution
+	process   *os.Process
+
+	// cancelled is set once the execution has been cancelled, and is guarded by runningMu.
+	cancelled bool
+}
+
+func (s *Service) cancelRequested(executionID string) bool {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+
+	r, ok := s.running[executionID]
+	return ok && r.cancelled
+}
+
+func New(lis net.Listener, scripts *scripts.Store, accounts *accounts.Store, executions *executions.Store, secrets *secrets.Store, admission *admission.Controller, warmPool *warmpool.Pool, runtimes *runtimes.Registry, k4LibraryPath string) *Service {
 	prometheus.MustRegister(scriptRealExecutionDurationsHistogram)
 	prometheus.MustRegister(scriptCPUExecutionDurationsHistogram)
 	prometheus.MustRegister(scriptUsesByServer)
@@ -79,21 +124,51 @@ func New(lis net.Listener, scripts *scripts.Store, accounts *accounts.Store, nse
 	return &Service{
 		lis: lis,
 
-		scripts:  scripts,
-		accounts: accounts,
+		scripts:    scripts,
+		accounts:   accounts,
+		executions: executions,
+		secrets:    secrets,
+
+		admission: admission,
+		warmPool:  warmPool,
 
-		nsenternetPath: nsenternetPath,
-		supervisorPath: supervisorPath,
-		k4LibraryPath:  k4LibraryPath,
+		runtimes: runtimes,
 
-		chroot:       chroot,
-		parentCgroup: parentCgroup,
+		k4LibraryPath: k4LibraryPath,
 
-		executionID: 0,
+		running: make(map[string]*runningExecution),
 	}
 }
 
+func newExecutionID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
 func (s *Service) Create(ctx context.Context, req *pb.CreateRequest) (*pb.CreateResponse, error) {
+	if req.Meta == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "meta must be set")
+	}
+
+	if err := params.Validate(req.Meta.Parameter); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid parameters: %v", err)
+	}
+
+	if _, err := s.runtimes.Runtime(req.Meta.Runtime); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "unknown runtime")
+	}
+
+	if err := s.validateDependencies(ctx, req.OwnerName, req.Name, req.Meta.Dependency); err != nil {
+		return nil, err
+	}
+
+	if err := validateSecretNames(req.Meta.Secret); err != nil {
+		return nil, err
+	}
+
 	script, err := s.scripts.Create(ctx, req.OwnerName, req.Name)
 	if err != nil {
 		switch err {
@@ -107,20 +182,94 @@ func (s *Service) Create(ctx context.Context, req *pb.CreateRequest) (*pb.Create
 	}
 
 	if err := script.SetMeta(ctx, req.Meta); err != nil {
-		script.Delete(context.Background())
+		script.Delete(context.Background(), 0)
+		if err == scripts.ErrInvalid {
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script meta")
+		}
 		glog.Errorf("Failed to set script meta: %v", err)
 		return nil, grpc.Errorf(codes.Internal, "failed to create script")
 	}
 
 	if err := script.SetContent(ctx, req.Content); err != nil {
-		script.Delete(context.Background())
+		script.Delete(context.Background(), 0)
 		glog.Errorf("Failed to write to file: %v", err)
 		return nil, grpc.Errorf(codes.Internal, "failed to create script")
 	}
 
+	authorName := req.AuthorName
+	if authorName == "" {
+		authorName = req.OwnerName
+	}
+
+	if _, err := script.RecordRevision(ctx, authorName); err != nil {
+		script.Delete(context.Background(), 0)
+		glog.Errorf("Failed to record revision: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to create script")
+	}
+
 	return &pb.CreateResponse{}, nil
 }
 
+func (s *Service) Update(ctx context.Context, req *pb.UpdateRequest) (*pb.UpdateResponse, error) {
+	script, err := s.scripts.Open(ctx, req.OwnerName, req.Name)
+
+	if err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script name, must only contain numbers and lowercase alphabetical characters")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "script not found")
+		}
+		glog.Errorf("Failed to get load script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
+	}
+
+	if req.Meta == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "meta must be set")
+	}
+
+	if err := params.Validate(req.Meta.Parameter); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid parameters: %v", err)
+	}
+
+	if _, err := s.runtimes.Runtime(req.Meta.Runtime); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "unknown runtime")
+	}
+
+	if err := s.validateDependencies(ctx, req.OwnerName, req.Name, req.Meta.Dependency); err != nil {
+		return nil, err
+	}
+
+	if err := validateSecretNames(req.Meta.Secret); err != nil {
+		return nil, err
+	}
+
+	authorName := req.AuthorName
+	if authorName == "" {
+		authorName = req.OwnerName
+	}
+
+	revision, err := script.Update(ctx, authorName, req.ExpectedRevision, req.NewName, req.Meta, req.Content)
+	if err != nil {
+		switch err {
+		case scripts.ErrConflict:
+			return nil, grpc.Errorf(codes.Aborted, "script was modified concurrently")
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script name, must only contain numbers and lowercase alphabetical characters")
+		case scripts.ErrInvalid:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script meta")
+		case scripts.ErrAlreadyExists:
+			return nil, grpc.Errorf(codes.AlreadyExists, "script already exists")
+		}
+		glog.Errorf("Failed to update script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to update script")
+	}
+
+	return &pb.UpdateResponse{
+		Revision: revision,
+	}, nil
+}
+
 func (s *Service) List(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
 	foundScripts, err := s.scripts.Scripts(ctx, req.OwnerName, req.Query, req.ViewerName, req.Offset, req.Limit, req.SortOrder)
 	if err != nil {
@@ -165,7 +314,10 @@ func (s *Service) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.Delete
 		return nil, grpc.Errorf(codes.Internal, "failed to load script")
 	}
 
-	if err := script.Delete(ctx); err != nil {
+	if err := script.Delete(ctx, req.ExpectedRevision); err != nil {
+		if err == scripts.ErrConflict {
+			return nil, grpc.Errorf(codes.Aborted, "script was modified concurrently")
+		}
 		glog.Errorf("Failed to delete script: %v", err)
 		return nil, grpc.Errorf(codes.Internal, "failed to delete script")
 	}
@@ -173,6 +325,138 @@ func (s *Service) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.Delete
 	return &pb.DeleteResponse{}, nil
 }
 
+func (s *Service) Fork(ctx context.Context, req *pb.ForkRequest) (*pb.ForkResponse, error) {
+	source, err := s.scripts.Open(ctx, req.OwnerName, req.Name)
+
+	if err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script name, must only contain numbers and lowercase alphabetical characters")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "script not found")
+		}
+		glog.Errorf("Failed to get load script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
+	}
+
+	meta, err := source.Meta(ctx)
+	if err != nil {
+		glog.Errorf("Failed to get meta: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
+	}
+
+	if meta.Visibility == pb.Visibility_UNPUBLISHED && req.TargetOwnerName != source.OwnerName {
+		return nil, grpc.Errorf(codes.NotFound, "script not found")
+	}
+
+	authorName := req.AuthorName
+	if authorName == "" {
+		authorName = req.TargetOwnerName
+	}
+
+	if _, err := s.scripts.Fork(ctx, source, req.TargetOwnerName, req.TargetName, authorName); err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script name, must only contain numbers and lowercase alphabetical characters")
+		case scripts.ErrAlreadyExists:
+			return nil, grpc.Errorf(codes.AlreadyExists, "script already exists")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "target account not found")
+		}
+		glog.Errorf("Failed to fork script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to fork script")
+	}
+
+	return &pb.ForkResponse{}, nil
+}
+
+func (s *Service) OfferTransfer(ctx context.Context, req *pb.OfferTransferRequest) (*pb.OfferTransferResponse, error) {
+	script, err := s.scripts.Open(ctx, req.OwnerName, req.Name)
+
+	if err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script name, must only contain numbers and lowercase alphabetical characters")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "script not found")
+		}
+		glog.Errorf("Failed to get load script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
+	}
+
+	if err := script.OfferTransfer(ctx, req.NewOwnerName); err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid account name")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "target account not found")
+		}
+		glog.Errorf("Failed to offer script transfer: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to offer script transfer")
+	}
+
+	return &pb.OfferTransferResponse{}, nil
+}
+
+func (s *Service) CancelTransfer(ctx context.Context, req *pb.CancelTransferRequest) (*pb.CancelTransferResponse, error) {
+	script, err := s.scripts.Open(ctx, req.OwnerName, req.Name)
+
+	if err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script name, must only contain numbers and lowercase alphabetical characters")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "script not found")
+		}
+		glog.Errorf("Failed to get load script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
+	}
+
+	if err := script.CancelTransfer(ctx); err != nil {
+		if err == scripts.ErrNotFound {
+			return nil, grpc.Errorf(codes.NotFound, "transfer offer not found")
+		}
+		glog.Errorf("Failed to cancel script transfer: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to cancel script transfer")
+	}
+
+	return &pb.CancelTransferResponse{}, nil
+}
+
+func (s *Service) TransferOwnership(ctx context.Context, req *pb.TransferOwnershipRequest) (*pb.TransferOwnershipResponse, error) {
+	script, err := s.scripts.Open(ctx, req.OwnerName, req.Name)
+
+	if err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script name, must only contain numbers and lowercase alphabetical characters")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "script not found")
+		}
+		glog.Errorf("Failed to get load script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
+	}
+
+	if err := script.TransferOwnership(ctx, req.NewOwnerName); err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid account name")
+		case scripts.ErrAlreadyExists:
+			return nil, grpc.Errorf(codes.AlreadyExists, "script already exists")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "target account not found")
+		case scripts.ErrNoTransferOffer:
+			return nil, grpc.Errorf(codes.FailedPrecondition, "script has not been offered to the account")
+		case scripts.ErrConflict:
+			return nil, grpc.Errorf(codes.Aborted, "script was modified since it was offered")
+		}
+		glog.Errorf("Failed to transfer script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to transfer script")
+	}
+
+	return &pb.TransferOwnershipResponse{}, nil
+}
+
 func (s *Service) Vote(ctx context.Context, req *pb.VoteRequest) (*pb.VoteResponse, error) {
 	script, err := s.scripts.Open(ctx, req.OwnerName, req.Name)
 
@@ -195,46 +479,53 @@ func (s *Service) Vote(ctx context.Context, req *pb.VoteRequest) (*pb.VoteRespon
 	return &pb.VoteResponse{}, nil
 }
 
-var defaultCgroupSubsystems = []string{"memory", "cpu", "blkio"}
+type streamWriter struct {
+	mu     *sync.Mutex
+	stream pb.Scripts_ExecuteStreamServer
+	stderr bool
+}
 
-func makeCgroups(subsystems []string, name string) ([]string, error) {
-	paths := make([]string, len(subsystems))
-	for i, subsystem := range subsystems {
-		mountpoint, err := cgroups.FindCgroupMountpoint(subsystem)
-		if err != nil {
-			return nil, err
-		}
+func (w *streamWriter) Write(p []byte) (int, error) {
+	resp := &pb.ExecuteStreamResponse{}
+	if w.stderr {
+		resp.Stderr = p
+	} else {
+		resp.Stdout = p
+	}
 
-		cgroupPath := filepath.Join(mountpoint, name)
-		if err := os.MkdirAll(cgroupPath, 0755); err != nil {
-			return nil, err
-		}
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-		paths[i] = cgroupPath
+	if err := w.stream.Send(resp); err != nil {
+		return 0, err
 	}
-
-	return paths, nil
+	return len(p), nil
 }
 
-func removeCgroup(path string) error {
-	files, err := ioutil.ReadDir(path)
+func (s *Service) ExecuteStream(req *pb.ExecuteRequest, stream pb.Scripts_ExecuteStreamServer) error {
+	var mu sync.Mutex
+
+	resp, err := s.execute(stream.Context(), req, &streamWriter{mu: &mu, stream: stream}, &streamWriter{mu: &mu, stream: stream, stderr: true})
 	if err != nil {
 		return err
 	}
 
-	for _, f := range files {
-		if f.IsDir() {
-			if err := removeCgroup(filepath.Join(path, f.Name())); err != nil {
-				return err
-			}
-		}
-	}
+	mu.Lock()
+	defer mu.Unlock()
 
-	glog.Infof("Removing cgroup path: %s", path)
-	return os.Remove(path)
+	return stream.Send(&pb.ExecuteStreamResponse{
+		Result:      resp.Result,
+		ExecutionId: resp.ExecutionId,
+	})
 }
 
 func (s *Service) Execute(ctx context.Context, req *pb.ExecuteRequest) (*pb.ExecuteResponse, error) {
+	return s.execute(ctx, req, ioutil.Discard, ioutil.Discard)
+}
+
+// execute runs a script, copying its output to stdoutW and stderrW as it is produced. The returned response also
+// contains the output, up to maxBufferSize.
+func (s *Service) execute(ctx context.Context, req *pb.ExecuteRequest, stdoutW io.Writer, stderrW io.Writer) (resp *pb.ExecuteResponse, err error) {
 	account, err := s.accounts.Account(ctx, req.OwnerName)
 	if err != nil {
 		if err == accounts.ErrNotFound {
@@ -244,6 +535,16 @@ func (s *Service) Execute(ctx context.Context, req *pb.ExecuteRequest) (*pb.Exec
 		return nil, grpc.Errorf(codes.Internal, "failed to load script")
 	}
 
+	suspended, err := account.Suspended(ctx)
+	if err != nil {
+		glog.Errorf("Failed to get account suspension: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
+	}
+
+	if suspended {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "owning account is suspended")
+	}
+
 	traits, err := account.Traits(ctx)
 	if err != nil {
 		glog.Errorf("Failed to get account traits: %v", err)
@@ -263,149 +564,169 @@ func (s *Service) Execute(ctx context.Context, req *pb.ExecuteRequest) (*pb.Exec
 		return nil, grpc.Errorf(codes.Internal, "failed to load script")
 	}
 
-	cgroupName := fmt.Sprintf("%s/execution-%d-%d", s.parentCgroup, time.Now().Unix(), s.executionID)
-	s.executionID++
-	cgroupPaths, err := makeCgroups(defaultCgroupSubsystems, cgroupName)
+	meta, err := script.Meta(ctx)
 	if err != nil {
-		glog.Errorf("Failed to open parent file conn: %v", err)
-		return nil, grpc.Errorf(codes.Internal, "failed to run script")
+		glog.Errorf("Failed to get meta: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
 	}
-	defer func() {
-		for _, path := range cgroupPaths {
-			if err := removeCgroup(path); err != nil {
-				glog.Errorf("Failed to remove all cgroups: %v", err)
-			}
-		}
-	}()
 
-	containersPath, err := ioutil.TempDir("", "kobun4-executor-containers-")
+	runtime, err := s.runtimes.Runtime(meta.Runtime)
 	if err != nil {
-		glog.Errorf("Failed to create containers temp directory: %v", err)
-		return nil, grpc.Errorf(codes.Internal, "failed to run script")
+		return nil, grpc.Errorf(codes.FailedPrecondition, "runtime %q is not available", meta.Runtime)
 	}
-	defer os.RemoveAll(containersPath)
 
-	rootfsesPath, err := ioutil.TempDir("", "kobun4-executor-rootfses-")
+	dependencies, err := s.resolveDependencies(ctx, script.OwnerName, meta.Dependency)
 	if err != nil {
-		glog.Errorf("Failed to create rootfses temp directory: %v", err)
-		return nil, grpc.Errorf(codes.Internal, "failed to run script")
+		return nil, err
 	}
-	defer os.RemoveAll(rootfsesPath)
-
-	var wg sync.WaitGroup
 
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	var status bytes.Buffer
-
-	stdinReader, stdinWriter, err := os.Pipe()
-	if err != nil {
-		glog.Errorf("Failed to create pipe: %v", err)
-		return nil, grpc.Errorf(codes.Internal, "failed to run script")
+	// Scripts get no secrets if secrets are disabled.
+	var ownerSecrets map[string]string
+	if s.secrets != nil {
+		ownerSecrets, err = s.secrets.Secrets(ctx, script.OwnerName, meta.Secret)
+		if err != nil {
+			glog.Errorf("Failed to get account secrets: %v", err)
+			return nil, grpc.Errorf(codes.Internal, "failed to load script")
+		}
 	}
-	defer stdinWriter.Close()
-	defer stdinReader.Close()
-	wg.Add(1)
-	go func() {
-		stdinWriter.Write([]byte(req.Stdin))
-		stdinWriter.Close()
-		wg.Done()
-	}()
 
-	stdoutReader, stdoutWriter, err := os.Pipe()
+	executionID, err := newExecutionID()
 	if err != nil {
-		glog.Errorf("Failed to create pipe: %v", err)
+		glog.Errorf("Failed to generate execution ID: %v", err)
 		return nil, grpc.Errorf(codes.Internal, "failed to run script")
 	}
-	defer stdoutReader.Close()
-	defer stdoutWriter.Close()
 
-	stderrReader, stderrWriter, err := os.Pipe()
-	if err != nil {
-		glog.Errorf("Failed to create pipe: %v", err)
-		return nil, grpc.Errorf(codes.Internal, "failed to run script")
-	}
-	defer stderrReader.Close()
-	defer stderrWriter.Close()
+	// From here on, the execution is recorded however it ends. Output is only recorded once it has been read in full.
+	startTime := time.Now()
+	var result *pb.WorkerExecutionResult
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	outputRead := false
+
+	defer func() {
+		execution := &pb.Execution{
+			Id:        executionID,
+			OwnerName: script.OwnerName,
+			Name:      script.Name,
+			Context:   req.Context,
+			Result:    result,
+			StartTime: startTime.UnixNano(),
+		}
+
+		if outputRead {
+			execution.Stdout = stdout.Bytes()
+			execution.Stderr = stderr.Bytes()
+		}
+
+		if err != nil {
+			execution.Result = nil
+			execution.Error = grpc.ErrorDesc(err)
+		}
+
+		// The request's context may be done already, e.g. if its deadline was exceeded.
+		if err := s.executions.Record(context.Background(), execution); err != nil {
+			glog.Errorf("Failed to record execution: %v", err)
+		}
+	}()
 
-	statusReader, statusWriter, err := os.Pipe()
+	release, err := s.admission.Acquire(ctx, script.OwnerName, int(traits.MaxConcurrentExecutions))
 	if err != nil {
-		glog.Errorf("Failed to create pipe: %v", err)
+		switch err {
+		case admission.ErrQueueFull:
+			return nil, grpc.Errorf(codes.ResourceExhausted, "too many executions queued")
+		case context.Canceled:
+			return nil, grpc.Errorf(codes.Canceled, "execution cancelled while queued")
+		case context.DeadlineExceeded:
+			return nil, grpc.Errorf(codes.DeadlineExceeded, "deadline exceeded while queued")
+		}
+		glog.Errorf("Failed to admit execution: %v", err)
 		return nil, grpc.Errorf(codes.Internal, "failed to run script")
 	}
-	defer statusReader.Close()
-	defer statusWriter.Close()
+	defer release()
 
-	reqReader, reqWriter, err := os.Pipe()
+	sb, err := s.warmPool.Get(&pb.SandboxProfile{
+		MemoryLimit: traits.MemoryLimit,
+		CpuShares:   traits.CpuShares,
+		BlkioWeight: traits.BlkioWeight,
+	})
 	if err != nil {
-		glog.Errorf("Failed to create pipe: %v", err)
+		glog.Errorf("Failed to get sandbox: %v", err)
 		return nil, grpc.Errorf(codes.Internal, "failed to run script")
 	}
-	defer reqWriter.Close()
-	defer reqReader.Close()
+	defer sb.Close()
 
 	timeout := time.Duration(traits.TimeLimitSeconds) * 2 * time.Second
-	glog.Infof("Starting supervisor with timeout: %s", timeout)
+	glog.Infof("Running supervisor with timeout: %s", timeout)
 
 	commandCtx, commandCancel := context.WithTimeout(ctx, timeout)
 	defer commandCancel()
 
-	cmd := exec.CommandContext(commandCtx, s.supervisorPath, "-logtostderr", "-parent_cgroup", cgroupName)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Pdeathsig: syscall.SIGKILL,
-	}
-	cmd.ExtraFiles = []*os.File{
-		stdinReader,
-		stdoutWriter,
-		stderrWriter,
-		statusWriter,
-		reqReader,
-	}
-	if err := cmd.Start(); err != nil {
-		glog.Errorf("Failed to start supervisor: %v", err)
-		return nil, grpc.Errorf(codes.Internal, "failed to run script")
+	go func() {
+		<-commandCtx.Done()
+		sb.Cmd.Process.Kill()
+	}()
+
+	startTime = time.Now()
+
+	s.runningMu.Lock()
+	s.running[executionID] = &runningExecution{
+		execution: &pb.Execution{
+			Id:        executionID,
+			OwnerName: script.OwnerName,
+			Name:      script.Name,
+			Context:   req.Context,
+			StartTime: startTime.UnixNano(),
+		},
+		process: sb.Cmd.Process,
 	}
+	s.runningMu.Unlock()
+	defer func() {
+		s.runningMu.Lock()
+		delete(s.running, executionID)
+		s.runningMu.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+
+	var status bytes.Buffer
 
 	wg.Add(1)
 	go func() {
-		io.Copy(limio.LimitWriter(&stdout, maxBufferSize), stdoutReader)
-		stdoutReader.Close()
+		sb.StdinWriter.Write([]byte(req.Stdin))
+		sb.StdinWriter.Close()
 		wg.Done()
 	}()
 
 	wg.Add(1)
 	go func() {
-		io.Copy(limio.LimitWriter(&stderr, maxBufferSize), stderrReader)
-		stderrReader.Close()
+		io.Copy(limio.LimitWriter(io.MultiWriter(&stdout, stdoutW), maxBufferSize), sb.StdoutReader)
+		sb.StdoutReader.Close()
 		wg.Done()
 	}()
 
 	wg.Add(1)
 	go func() {
-		io.Copy(&status, statusReader)
-		statusReader.Close()
+		io.Copy(limio.LimitWriter(io.MultiWriter(&stderr, stderrW), maxBufferSize), sb.StderrReader)
+		sb.StderrReader.Close()
 		wg.Done()
 	}()
 
-	stdinReader.Close()
-	stdoutWriter.Close()
-	stderrWriter.Close()
-	statusWriter.Close()
-	reqReader.Close()
+	wg.Add(1)
+	go func() {
+		io.Copy(&status, sb.StatusReader)
+		sb.StatusReader.Close()
+		wg.Done()
+	}()
 
 	workerReq := &pb.WorkerExecutionRequest{
 		Config: &pb.WorkerExecutionRequest_Configuration{
-			ContainersPath: containersPath,
-			Chroot:         s.chroot,
-			RootfsesPath:   rootfsesPath,
+			Chroot:         runtime.RootfsPath,
+			RuntimeChroots: s.runtimes.RootfsPaths(),
 
 			Hostname: "kobun4",
 
 			StorageRootPath: s.accounts.StorageRootPath(),
 			K4LibraryPath:   s.k4LibraryPath,
-			NsenternetPath:  s.nsenternetPath,
 
 			BridgeTarget:   req.BridgeTarget,
 			ExecutorTarget: s.lis.Addr().String(),
@@ -414,54 +735,77 @@ func (s *Service) Execute(ctx context.Context, req *pb.ExecuteRequest) (*pb.Exec
 		Name:      script.Name,
 
 		Context: req.Context,
+
+		BundleEntrypoint: meta.BundleEntrypoint,
+		Dependency:       dependencies,
 	}
 	glog.Infof("Execution request: %s", workerReq)
 
+	// Secrets are only added after logging, so they never end up in the logs.
+	workerReq.Secrets = ownerSecrets
+
 	rawReq, err := proto.Marshal(workerReq)
 	if err != nil {
 		glog.Errorf("Failed to marshal request: %v", err)
 		return nil, grpc.Errorf(codes.Internal, "failed to run script")
 	}
 
-	if _, err := reqWriter.Write(rawReq); err != nil {
+	if _, err := sb.RequestWriter.Write(rawReq); err != nil {
 		glog.Errorf("Failed to write request to supervisor: %v", err)
 		return nil, grpc.Errorf(codes.Internal, "failed to run script")
 	}
-	reqWriter.Close()
+	sb.RequestWriter.Close()
 
-	if err := cmd.Wait(); err != nil {
+	if err := sb.Wait(); err != nil {
 		if _, ok := err.(*exec.ExitError); !ok {
 			glog.Errorf("Failed to get ExitError: %v", err)
 			return nil, grpc.Errorf(codes.Internal, "failed to run script")
 		}
 	}
 
-	stdinWriter.Close()
-	stdoutReader.Close()
-	stderrReader.Close()
-	statusReader.Close()
+	sb.StdinWriter.Close()
+	sb.StdoutReader.Close()
+	sb.StderrReader.Close()
+	sb.StatusReader.Close()
 
 	wg.Wait()
-	rawStatus := status.Bytes()
-	if len(rawStatus) == 0 {
+	outputRead = true
+
+	result = &pb.WorkerExecutionResult{}
+	if rawStatus := status.Bytes(); len(rawStatus) > 0 {
+		if err := proto.Unmarshal(rawStatus, result); err != nil {
+			glog.Errorf("Failed to unmarshal status: %v", err)
+			return nil, grpc.Errorf(codes.Internal, "failed to run script")
+		}
+	} else if s.cancelRequested(executionID) {
+		// A freshly started supervisor that is cancelled before it handles SIGTERM exits without a status.
+		result.Cancelled = true
+	} else {
 		glog.Errorf("No status received?")
 		return nil, grpc.Errorf(codes.Internal, "failed to run script")
 	}
 
-	result := &pb.WorkerExecutionResult{}
-	if err := proto.Unmarshal(rawStatus, result); err != nil {
-		glog.Errorf("Failed to unmarshal status: %v", err)
-		return nil, grpc.Errorf(codes.Internal, "failed to run script")
+	// Supervisors that never ran the script, e.g. because it was cancelled first, may leave these unset.
+	if result.Timings == nil {
+		result.Timings = &pb.WorkerExecutionResult_Timings{}
+	}
+	if result.OutputParams == nil {
+		result.OutputParams = &pb.OutputParams{}
 	}
 
 	scriptCPUExecutionDurationsHistogram.WithLabelValues(script.OwnerName, script.Name).Observe(float64(time.Duration(result.Timings.UserNanos+result.Timings.SystemNanos)*time.Nanosecond) / float64(time.Millisecond))
 	scriptRealExecutionDurationsHistogram.WithLabelValues(script.OwnerName, script.Name).Observe(float64(time.Duration(result.Timings.RealNanos)*time.Nanosecond) / float64(time.Millisecond))
 	scriptUsesByServer.WithLabelValues(req.Context.BridgeName, req.Context.NetworkId, req.Context.GroupId, script.OwnerName, script.Name).Inc()
 
+	if err := script.RecordUse(ctx); err != nil {
+		glog.Errorf("Failed to record script use: %v", err)
+	}
+
 	return &pb.ExecuteResponse{
-		Result: result,
-		Stdout: stdout.Bytes(),
-		Stderr: stderr.Bytes(),
+		Result:      result,
+		Stdout:      stdout.Bytes(),
+		Stderr:      stderr.Bytes(),
+		ExecutionId: executionID,
 	}, nil
 }
 
@@ -479,6 +823,12 @@ func (s *Service) GetContent(ctx context.Context, req *pb.GetContentRequest) (*p
 		return nil, grpc.Errorf(codes.Internal, "failed to load script")
 	}
 
+	revisionID, err := script.LatestRevisionID(ctx)
+	if err != nil {
+		glog.Errorf("Failed to get script revision: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to get script content")
+	}
+
 	content, err := script.Content(ctx)
 	if err != nil {
 		glog.Errorf("Failed to get script content: %v", err)
@@ -486,7 +836,8 @@ func (s *Service) GetContent(ctx context.Context, req *pb.GetContentRequest) (*p
 	}
 
 	return &pb.GetContentResponse{
-		Content: content,
+		Content:  content,
+		Revision: revisionID,
 	}, nil
 }
 
@@ -504,6 +855,12 @@ func (s *Service) GetMeta(ctx context.Context, req *pb.GetMetaRequest) (*pb.GetM
 		return nil, grpc.Errorf(codes.Internal, "failed to load script")
 	}
 
+	revisionID, err := script.LatestRevisionID(ctx)
+	if err != nil {
+		glog.Errorf("Failed to get script revision: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to get meta")
+	}
+
 	reqs, err := script.Meta(ctx)
 	if err != nil {
 		glog.Errorf("Failed to get meta: %v", err)
@@ -511,6 +868,286 @@ func (s *Service) GetMeta(ctx context.Context, req *pb.GetMetaRequest) (*pb.GetM
 	}
 
 	return &pb.GetMetaResponse{
-		Meta: reqs,
+		Meta:     reqs,
+		Revision: revisionID,
+	}, nil
+}
+
+func (s *Service) SetBundle(ctx context.Context, req *pb.SetBundleRequest) (*pb.SetBundleResponse, error) {
+	script, err := s.scripts.Open(ctx, req.OwnerName, req.Name)
+
+	if err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script name, must only contain numbers and lowercase alphabetical characters")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "script not found")
+		}
+		glog.Errorf("Failed to get load script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
+	}
+
+	if err := s.scripts.SetBundle(ctx, script, req.Archive, req.Format, req.Entrypoint); err != nil {
+		switch err {
+		case scripts.ErrInvalidBundle:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid bundle, must be a tar or zip archive of regular files containing the entrypoint")
+		case scripts.ErrBundleTooLarge:
+			return nil, grpc.Errorf(codes.ResourceExhausted, "bundle too large")
+		}
+		glog.Errorf("Failed to set bundle: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to set bundle")
+	}
+
+	return &pb.SetBundleResponse{}, nil
+}
+
+func (s *Service) GetBundle(ctx context.Context, req *pb.GetBundleRequest) (*pb.GetBundleResponse, error) {
+	script, err := s.scripts.Open(ctx, req.OwnerName, req.Name)
+
+	if err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script name, must only contain numbers and lowercase alphabetical characters")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "script not found")
+		}
+		glog.Errorf("Failed to get load script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
+	}
+
+	meta, err := script.Meta(ctx)
+	if err != nil {
+		glog.Errorf("Failed to get meta: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to get bundle")
+	}
+
+	if meta.BundleEntrypoint == "" {
+		return nil, grpc.Errorf(codes.NotFound, "script has no bundle")
+	}
+
+	var buf bytes.Buffer
+	if err := script.WriteBundle(ctx, &buf); err != nil {
+		if err == scripts.ErrNotFound {
+			return nil, grpc.Errorf(codes.NotFound, "script has no bundle")
+		}
+		glog.Errorf("Failed to get bundle: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to get bundle")
+	}
+
+	return &pb.GetBundleResponse{
+		Archive:    buf.Bytes(),
+		Entrypoint: meta.BundleEntrypoint,
+	}, nil
+}
+
+func (s *Service) DeleteBundle(ctx context.Context, req *pb.DeleteBundleRequest) (*pb.DeleteBundleResponse, error) {
+	script, err := s.scripts.Open(ctx, req.OwnerName, req.Name)
+
+	if err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script name, must only contain numbers and lowercase alphabetical characters")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "script not found")
+		}
+		glog.Errorf("Failed to get load script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
+	}
+
+	if err := script.DeleteBundle(ctx); err != nil {
+		glog.Errorf("Failed to delete bundle: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to delete bundle")
+	}
+
+	return &pb.DeleteBundleResponse{}, nil
+}
+
+func (s *Service) ListRuntimes(ctx context.Context, req *pb.ListRuntimesRequest) (*pb.ListRuntimesResponse, error) {
+	runtimes := s.runtimes.Runtimes()
+
+	resp := &pb.ListRuntimesResponse{
+		Runtime: make([]*pb.Runtime, len(runtimes)),
+	}
+	for i, runtime := range runtimes {
+		resp.Runtime[i] = &pb.Runtime{
+			Name:        runtime.Name,
+			Description: runtime.Description,
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *Service) ListRevisions(ctx context.Context, req *pb.ListRevisionsRequest) (*pb.ListRevisionsResponse, error) {
+	script, err := s.scripts.Open(ctx, req.OwnerName, req.Name)
+
+	if err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script name, must only contain numbers and lowercase alphabetical characters")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "script not found")
+		}
+		glog.Errorf("Failed to get load script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
+	}
+
+	revisions, err := script.Revisions(ctx, req.Offset, req.Limit)
+	if err != nil {
+		glog.Errorf("Failed to list revisions: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to list revisions")
+	}
+
+	return &pb.ListRevisionsResponse{
+		Revision: revisions,
+	}, nil
+}
+
+func (s *Service) GetRevision(ctx context.Context, req *pb.GetRevisionRequest) (*pb.GetRevisionResponse, error) {
+	script, err := s.scripts.Open(ctx, req.OwnerName, req.Name)
+
+	if err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script name, must only contain numbers and lowercase alphabetical characters")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "script not found")
+		}
+		glog.Errorf("Failed to get load script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
+	}
+
+	revision, content, err := script.Revision(ctx, req.RevisionId)
+	if err != nil {
+		if err == scripts.ErrNotFound {
+			return nil, grpc.Errorf(codes.NotFound, "revision not found")
+		}
+		glog.Errorf("Failed to get revision: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to get revision")
+	}
+
+	var diff string
+	if req.DiffBaseRevisionId != 0 {
+		_, baseContent, err := script.Revision(ctx, req.DiffBaseRevisionId)
+		if err != nil {
+			if err == scripts.ErrNotFound {
+				return nil, grpc.Errorf(codes.NotFound, "base revision not found")
+			}
+			glog.Errorf("Failed to get base revision: %v", err)
+			return nil, grpc.Errorf(codes.Internal, "failed to get revision")
+		}
+
+		diff = scripts.Diff(baseContent, content)
+	}
+
+	return &pb.GetRevisionResponse{
+		Revision: revision,
+		Content:  content,
+		Diff:     diff,
+	}, nil
+}
+
+func (s *Service) Rollback(ctx context.Context, req *pb.RollbackRequest) (*pb.RollbackResponse, error) {
+	script, err := s.scripts.Open(ctx, req.OwnerName, req.Name)
+
+	if err != nil {
+		switch err {
+		case scripts.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid script name, must only contain numbers and lowercase alphabetical characters")
+		case scripts.ErrNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "script not found")
+		}
+		glog.Errorf("Failed to get load script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to load script")
+	}
+
+	authorName := req.AuthorName
+	if authorName == "" {
+		authorName = req.OwnerName
+	}
+
+	revision, err := script.Rollback(ctx, req.RevisionId, authorName)
+	if err != nil {
+		if err == scripts.ErrNotFound {
+			return nil, grpc.Errorf(codes.NotFound, "revision not found")
+		}
+		glog.Errorf("Failed to roll back script: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to roll back script")
+	}
+
+	return &pb.RollbackResponse{
+		Revision: revision,
+	}, nil
+}
+
+func (s *Service) ListExecutions(ctx context.Context, req *pb.ListExecutionsRequest) (*pb.ListExecutionsResponse, error) {
+	foundExecutions, err := s.executions.Executions(ctx, req.OwnerName, req.Name, req.Offset, req.Limit)
+	if err != nil {
+		glog.Errorf("Failed to list executions: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to list executions")
+	}
+
+	return &pb.ListExecutionsResponse{
+		Execution: foundExecutions,
+	}, nil
+}
+
+func (s *Service) GetExecution(ctx context.Context, req *pb.GetExecutionRequest) (*pb.GetExecutionResponse, error) {
+	execution, err := s.executions.Execution(ctx, req.Id)
+	if err != nil {
+		if err == executions.ErrNotFound {
+			return nil, grpc.Errorf(codes.NotFound, "execution not found")
+		}
+		glog.Errorf("Failed to get execution: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to get execution")
+	}
+
+	return &pb.GetExecutionResponse{
+		Execution: execution,
 	}, nil
 }
+
+func (s *Service) ListRunning(ctx context.Context, req *pb.ListRunningRequest) (*pb.ListRunningResponse, error) {
+	running := make([]*pb.Execution, 0)
+
+	s.runningMu.Lock()
+	for _, r := range s.running {
+		if req.OwnerName != "" && r.execution.OwnerName != req.OwnerName {
+			continue
+		}
+		if req.Name != "" && r.execution.Name != req.Name {
+			continue
+		}
+		running = append(running, r.execution)
+	}
+	s.runningMu.Unlock()
+
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].StartTime < running[j].StartTime
+	})
+
+	return &pb.ListRunningResponse{
+		Execution: running,
+	}, nil
+}
+
+func (s *Service) Cancel(ctx context.Context, req *pb.CancelRequest) (*pb.CancelResponse, error) {
+	s.runningMu.Lock()
+	r, ok := s.running[req.ExecutionId]
+	if ok && (req.OwnerName == "" || r.execution.OwnerName == req.OwnerName) {
+		r.cancelled = true
+	}
+	s.runningMu.Unlock()
+
+	if !ok || (req.OwnerName != "" && r.execution.OwnerName != req.OwnerName) {
+		return nil, grpc.Errorf(codes.NotFound, "execution not running")
+	}
+
+	// The supervisor kills the script on SIGTERM and reports the result as cancelled.
+	if err := r.process.Signal(syscall.SIGTERM); err != nil {
+		glog.Errorf("Failed to signal supervisor: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to cancel execution")
+	}
+
+	return &pb.CancelResponse{}, nil
+}
@@ -0,0 +1,187 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+)
+
+var (
+	ErrNotFound        error = errors.New("secrets: not found")
+	ErrAccountNotFound       = errors.New("secrets: account not found")
+	ErrInvalidName           = errors.New("secrets: invalid name")
+	ErrTooLarge              = errors.New("secrets: value too large")
+)
+
+const maxValueSize = 4 * 1024
+
+var nameRegexp = regexp.MustCompile(`^[A-Z][A-Z0-9_]{0,63}$`)
+
+// ValidName returns whether name is a valid secret name.
+func ValidName(name string) bool {
+	return nameRegexp.MatchString(name)
+}
+
+type Store struct {
+	db   *sql.DB
+	aead cipher.AEAD
+}
+
+// NewStore creates a secrets store. Secret values are sealed with AES-GCM under masterKey, which must be 32 bytes long.
+func NewStore(db *sql.DB, masterKey []byte) (*Store, error) {
+	if len(masterKey) != 32 {
+		return nil, fmt.Errorf("secrets: master key must be 32 bytes, got %d", len(masterKey))
+	}
+
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		db:   db,
+		aead: aead,
+	}, nil
+}
+
+// additionalData binds a sealed value to its row, so ciphertexts cannot be swapped between accounts or names.
+func additionalData(ownerName string, name string) []byte {
+	return []byte(ownerName + "/" + name)
+}
+
+func (s *Store) Set(ctx context.Context, ownerName string, name string, value []byte) error {
+	if !ValidName(name) {
+		return ErrInvalidName
+	}
+
+	if len(value) > maxValueSize {
+		return ErrTooLarge
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	ciphertext := s.aead.Seal(nil, nonce, value, additionalData(ownerName, name))
+
+	if _, err := s.db.ExecContext(ctx, `
+		insert into secrets (owner_name, secret_name, nonce, ciphertext)
+		values ($1, $2, $3, $4)
+		on conflict (owner_name, secret_name) do update
+		set nonce = excluded.nonce,
+		    ciphertext = excluded.ciphertext,
+		    update_time = now()
+	`, ownerName, name, nonce, ciphertext); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" /* foreign_key_violation */ {
+			return ErrAccountNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) Names(ctx context.Context, ownerName string) ([]string, error) {
+	names := make([]string, 0)
+
+	rows, err := s.db.QueryContext(ctx, `
+		select secret_name
+		from secrets
+		where owner_name = $1
+		order by secret_name
+	`, ownerName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+func (s *Store) Delete(ctx context.Context, ownerName string, name string) error {
+	r, err := s.db.ExecContext(ctx, `
+		delete from secrets
+		where owner_name = $1 and
+		      secret_name = $2
+	`, ownerName, name)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Secrets returns the named secrets of an account, decrypted. Names the account has no secret for are skipped.
+func (s *Store) Secrets(ctx context.Context, ownerName string, names []string) (map[string]string, error) {
+	secrets := make(map[string]string)
+
+	if len(names) == 0 {
+		return secrets, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		select secret_name, nonce, ciphertext
+		from secrets
+		where owner_name = $1 and
+		      secret_name = any($2)
+	`, ownerName, pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var nonce []byte
+		var ciphertext []byte
+
+		if err := rows.Scan(&name, &nonce, &ciphertext); err != nil {
+			return nil, err
+		}
+
+		value, err := s.aead.Open(nil, nonce, ciphertext, additionalData(ownerName, name))
+		if err != nil {
+			return nil, fmt.Errorf("secrets: failed to open %s/%s: %v", ownerName, name, err)
+		}
+
+		secrets[name] = string(value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
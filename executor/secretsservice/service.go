@@ -0,0 +1,78 @@
+package secretsservice
+
+import (
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/porpoises/kobun4/executor/secrets"
+
+	pb "github.com/porpoises/kobun4/executor/secretsservice/v1pb"
+)
+
+type Service struct {
+	secrets *secrets.Store
+}
+
+// New creates the secrets service. If secrets is nil, secrets are disabled and every call fails with Unimplemented.
+func New(secrets *secrets.Store) *Service {
+	return &Service{
+		secrets: secrets,
+	}
+}
+
+var errSecretsDisabled = grpc.Errorf(codes.Unimplemented, "secrets are not enabled")
+
+func (s *Service) SetSecret(ctx context.Context, req *pb.SetSecretRequest) (*pb.SetSecretResponse, error) {
+	if s.secrets == nil {
+		return nil, errSecretsDisabled
+	}
+
+	if err := s.secrets.Set(ctx, req.OwnerName, req.Name, req.Value); err != nil {
+		switch err {
+		case secrets.ErrInvalidName:
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid secret name")
+		case secrets.ErrTooLarge:
+			return nil, grpc.Errorf(codes.InvalidArgument, "secret too large")
+		case secrets.ErrAccountNotFound:
+			return nil, grpc.Errorf(codes.NotFound, "account not found")
+		}
+		glog.Errorf("Failed to set secret: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to set secret")
+	}
+
+	return &pb.SetSecretResponse{}, nil
+}
+
+func (s *Service) ListSecretNames(ctx context.Context, req *pb.ListSecretNamesRequest) (*pb.ListSecretNamesResponse, error) {
+	if s.secrets == nil {
+		return nil, errSecretsDisabled
+	}
+
+	names, err := s.secrets.Names(ctx, req.OwnerName)
+	if err != nil {
+		glog.Errorf("Failed to list secrets: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to list secrets")
+	}
+
+	return &pb.ListSecretNamesResponse{
+		Name: names,
+	}, nil
+}
+
+func (s *Service) DeleteSecret(ctx context.Context, req *pb.DeleteSecretRequest) (*pb.DeleteSecretResponse, error) {
+	if s.secrets == nil {
+		return nil, errSecretsDisabled
+	}
+
+	if err := s.secrets.Delete(ctx, req.OwnerName, req.Name); err != nil {
+		if err == secrets.ErrNotFound {
+			return nil, grpc.Errorf(codes.NotFound, "secret not found")
+		}
+		glog.Errorf("Failed to delete secret: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to delete secret")
+	}
+
+	return &pb.DeleteSecretResponse{}, nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	accountsadminpb "github.com/porpoises/kobun4/executor/accountsadminservice/v1pb"
+	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
+)
+
+var (
+	executorAdminTarget = flag.String("executor_admin_target", "/run/kobun4-executor/admin.socket", "Executor admin target")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: %s [flags] <command> [args]
+
+commands:
+  set-traits <username> <field>=<value>...
+      Overrides traits of an account. List fields (allowed_output_format, allowed_service) take comma-separated values.
+  clear-traits <username> <field>...
+      Removes overrides of traits of an account, so they are taken from its profile.
+  set-profile <username> <profile>
+  suspend <username>
+  unsuspend <username>
+  delete <username>
+  profile-create <profile> [<field>=<value>...]
+  profile-update <profile> <field>=<value>...
+  profile-get <profile>
+  profile-list
+  profile-delete <profile>
+  invite-create [<duration>]
+      Creates a one-time invite code for registering an account, which expires after duration (e.g. 72h) if given.
+  invite-list
+  invite-delete <code>
+
+flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func parseTraits(args []string) (*accountspb.Traits, []string, error) {
+	traits := &accountspb.Traits{}
+
+	int64Fields := map[string]*int64{
+		"time_limit_seconds":          &traits.TimeLimitSeconds,
+		"memory_limit":                &traits.MemoryLimit,
+		"tmpfs_size":                  &traits.TmpfsSize,
+		"blkio_weight":                &traits.BlkioWeight,
+		"cpu_shares":                  &traits.CpuShares,
+		"max_messages_per_invocation": &traits.MaxMessagesPerInvocation,
+		"max_concurrent_executions":   &traits.MaxConcurrentExecutions,
+	}
+
+	listFields := map[string]*[]string{
+		"allowed_output_format": &traits.AllowedOutputFormat,
+		"allowed_service":       &traits.AllowedService,
+	}
+
+	fields := make([]string, 0, len(args))
+
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("expected <field>=<value>, got %q", arg)
+		}
+		field, value := parts[0], parts[1]
+
+		if p, ok := int64Fields[field]; ok {
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("bad value for %s: %v", field, err)
+			}
+			*p = v
+		} else if p, ok := listFields[field]; ok {
+			*p = []string{}
+			if value != "" {
+				*p = strings.Split(value, ",")
+			}
+		} else if field == "allow_network_access" {
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("bad value for %s: %v", field, err)
+			}
+			traits.AllowNetworkAccess = v
+		} else {
+			return nil, nil, fmt.Errorf("unknown field %s", field)
+		}
+
+		fields = append(fields, field)
+	}
+
+	return traits, fields, nil
+}
+
+func run(ctx context.Context, client accountsadminpb.AccountsAdminClient, command string, args []string) error {
+	switch command {
+	case "profile-list":
+		resp, err := client.ListTraitProfiles(ctx, &accountsadminpb.ListTraitProfilesRequest{})
+		if err != nil {
+			return err
+		}
+		for _, name := range resp.Name {
+			fmt.Println(name)
+		}
+		return nil
+	case "invite-create":
+		var expireTime int64
+		if len(args) > 0 {
+			duration, err := time.ParseDuration(args[0])
+			if err != nil {
+				return fmt.Errorf("bad duration: %v", err)
+			}
+			expireTime = time.Now().Add(duration).Unix()
+		}
+
+		resp, err := client.CreateInviteCode(ctx, &accountsadminpb.CreateInviteCodeRequest{
+			ExpireTime: expireTime,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(resp.Code)
+		return nil
+	case "invite-list":
+		resp, err := client.ListInviteCodes(ctx, &accountsadminpb.ListInviteCodesRequest{})
+		if err != nil {
+			return err
+		}
+		for _, inviteCode := range resp.InviteCode {
+			expires := "never"
+			if inviteCode.ExpireTime != 0 {
+				expires = time.Unix(inviteCode.ExpireTime, 0).Format(time.RFC3339)
+			}
+			fmt.Printf("%s\tcreated %s\texpires %s\n", inviteCode.Code, time.Unix(inviteCode.CreateTime, 0).Format(time.RFC3339), expires)
+		}
+		return nil
+	}
+
+	if len(args) < 1 {
+		return fmt.Errorf("missing argument")
+	}
+	name, args := args[0], args[1:]
+
+	switch command {
+	case "set-traits":
+		traits, fields, err := parseTraits(args)
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 {
+			return fmt.Errorf("no traits given")
+		}
+
+		_, err = client.SetTraits(ctx, &accountsadminpb.SetTraitsRequest{
+			Username: name,
+			Traits:   traits,
+			Field:    fields,
+		})
+		return err
+	case "clear-traits":
+		if len(args) == 0 {
+			return fmt.Errorf("no traits given")
+		}
+
+		_, err := client.ClearTraits(ctx, &accountsadminpb.ClearTraitsRequest{
+			Username: name,
+			Field:    args,
+		})
+		return err
+	case "set-profile":
+		if len(args) != 1 {
+			return fmt.Errorf("expected a profile name")
+		}
+
+		_, err := client.SetTraitProfile(ctx, &accountsadminpb.SetTraitProfileRequest{
+			Username:    name,
+			ProfileName: args[0],
+		})
+		return err
+	case "suspend":
+		_, err := client.Suspend(ctx, &accountsadminpb.SuspendRequest{
+			Username: name,
+		})
+		return err
+	case "unsuspend":
+		_, err := client.Unsuspend(ctx, &accountsadminpb.UnsuspendRequest{
+			Username: name,
+		})
+		return err
+	case "delete":
+		_, err := client.Delete(ctx, &accountsadminpb.DeleteRequest{
+			Username: name,
+		})
+		return err
+	case "profile-create":
+		traits, fields, err := parseTraits(args)
+		if err != nil {
+			return err
+		}
+
+		_, err = client.CreateTraitProfile(ctx, &accountsadminpb.CreateTraitProfileRequest{
+			Name:   name,
+			Traits: traits,
+			Field:  fields,
+		})
+		return err
+	case "profile-update":
+		traits, fields, err := parseTraits(args)
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 {
+			return fmt.Errorf("no traits given")
+		}
+
+		_, err = client.UpdateTraitProfile(ctx, &accountsadminpb.UpdateTraitProfileRequest{
+			Name:   name,
+			Traits: traits,
+			Field:  fields,
+		})
+		return err
+	case "profile-get":
+		resp, err := client.GetTraitProfile(ctx, &accountsadminpb.GetTraitProfileRequest{
+			Name: name,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(proto.MarshalTextString(resp.Traits))
+		return nil
+	case "profile-delete":
+		_, err := client.DeleteTraitProfile(ctx, &accountsadminpb.DeleteTraitProfileRequest{
+			Name: name,
+		})
+		return err
+	case "invite-delete":
+		_, err := client.DeleteInviteCode(ctx, &accountsadminpb.DeleteInviteCodeRequest{
+			Code: name,
+		})
+		return err
+	}
+
+	return fmt.Errorf("unknown command %s", command)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	conn, err := grpc.Dial(*executorAdminTarget, grpc.WithInsecure(), grpc.WithDialer(func(address string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout("unix", address, timeout)
+	}))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to connect to executor: %v\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	if err := run(context.Background(), accountsadminpb.NewAccountsAdminClient(conn), flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,147 @@
+package warmpool
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+
+	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+)
+
+var (
+	idleSandboxesGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kobun4",
+		Subsystem: "executor",
+		Name:      "warm_pool_idle_sandboxes",
+		Help:      "Number of sandboxes waiting in the warm pool.",
+	})
+
+	sandboxesTakenCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kobun4",
+		Subsystem: "executor",
+		Name:      "warm_pool_sandboxes_taken_total",
+		Help:      "Sandboxes handed out, by whether they came from the warm pool.",
+	}, []string{"warm"})
+)
+
+// Pool keeps up to size prepared sandboxes for each sandbox profile it has been asked for. A pool of size 0 prepares
+// every sandbox on demand.
+type Pool struct {
+	supervisorPath string
+	nsenternetPath string
+	parentCgroup   string
+
+	size int
+
+	mu      sync.Mutex
+	idle    map[string][]*Sandbox
+	filling map[string]bool
+}
+
+func New(supervisorPath string, nsenternetPath string, parentCgroup string, size int) *Pool {
+	prometheus.MustRegister(idleSandboxesGauge)
+	prometheus.MustRegister(sandboxesTakenCounter)
+
+	return &Pool{
+		supervisorPath: supervisorPath,
+		nsenternetPath: nsenternetPath,
+		parentCgroup:   parentCgroup,
+
+		size: size,
+
+		idle:    make(map[string][]*Sandbox),
+		filling: make(map[string]bool),
+	}
+}
+
+func (p *Pool) newSandbox(profile *scriptspb.SandboxProfile) (*Sandbox, error) {
+	return newSandbox(p.supervisorPath, p.nsenternetPath, p.parentCgroup, profile)
+}
+
+func (p *Pool) fill(key string, profile *scriptspb.SandboxProfile) {
+	for {
+		p.mu.Lock()
+		if len(p.idle[key]) >= p.size {
+			p.filling[key] = false
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+
+		sb, err := p.newSandbox(profile)
+		if err != nil {
+			glog.Errorf("Failed to prepare sandbox: %v", err)
+			p.mu.Lock()
+			p.filling[key] = false
+			p.mu.Unlock()
+			return
+		}
+
+		p.mu.Lock()
+		p.idle[key] = append(p.idle[key], sb)
+		idleSandboxesGauge.Inc()
+		p.mu.Unlock()
+	}
+}
+
+// Get returns a sandbox prepared for the given profile, taking one from the pool if possible. The caller owns the
+// sandbox and must close it.
+func (p *Pool) Get(profile *scriptspb.SandboxProfile) (*Sandbox, error) {
+	if p.size <= 0 {
+		sandboxesTakenCounter.WithLabelValues("false").Inc()
+		return p.newSandbox(profile)
+	}
+
+	key := proto.CompactTextString(profile)
+
+	var sb *Sandbox
+	dead := make([]*Sandbox, 0)
+
+	p.mu.Lock()
+	// Supervisors may have died while idle, in which case they are discarded and refilled like any other taken sandbox.
+	for idle := p.idle[key]; len(idle) > 0 && sb == nil; idle = p.idle[key] {
+		candidate := idle[len(idle)-1]
+		p.idle[key] = idle[:len(idle)-1]
+		idleSandboxesGauge.Dec()
+
+		if candidate.Alive() {
+			sb = candidate
+		} else {
+			dead = append(dead, candidate)
+		}
+	}
+	if !p.filling[key] {
+		p.filling[key] = true
+		go p.fill(key, profile)
+	}
+	p.mu.Unlock()
+
+	for _, deadSb := range dead {
+		glog.Errorf("Discarding sandbox whose supervisor exited while idle: %v", deadSb.Wait())
+		deadSb.Close()
+	}
+
+	if sb != nil {
+		sandboxesTakenCounter.WithLabelValues("true").Inc()
+		return sb, nil
+	}
+
+	sandboxesTakenCounter.WithLabelValues("false").Inc()
+	return p.newSandbox(profile)
+}
+
+// Close releases all idle sandboxes.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, idle := range p.idle {
+		for _, sb := range idle {
+			sb.Close()
+			idleSandboxesGauge.Dec()
+		}
+		delete(p.idle, key)
+	}
+}
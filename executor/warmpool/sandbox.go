@@ -0,0 +1,237 @@
+package warmpool
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+
+	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+)
+
+var defaultCgroupSubsystems = []string{"memory", "cpu", "blkio"}
+
+func makeCgroups(subsystems []string, name string) ([]string, error) {
+	paths := make([]string, len(subsystems))
+	for i, subsystem := range subsystems {
+		mountpoint, err := cgroups.FindCgroupMountpoint(subsystem)
+		if err != nil {
+			return nil, err
+		}
+
+		cgroupPath := filepath.Join(mountpoint, name)
+		if err := os.MkdirAll(cgroupPath, 0755); err != nil {
+			return nil, err
+		}
+
+		paths[i] = cgroupPath
+	}
+
+	return paths, nil
+}
+
+func removeCgroup(path string) error {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() {
+			if err := removeCgroup(filepath.Join(path, f.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	glog.Infof("Removing cgroup path: %s", path)
+	return os.Remove(path)
+}
+
+// Sandbox is a started supervisor that has prepared its cgroups, container factory and rootfs, and is waiting for an
+// execution request.
+type Sandbox struct {
+	Cmd *exec.Cmd
+
+	StdinWriter   *os.File
+	StdoutReader  *os.File
+	StderrReader  *os.File
+	StatusReader  *os.File
+	RequestWriter *os.File
+
+	cgroupPaths    []string
+	containersPath string
+	rootfsesPath   string
+
+	exited  chan struct{}
+	waitErr error
+}
+
+func newSandbox(supervisorPath string, nsenternetPath string, parentCgroup string, profile *scriptspb.SandboxProfile) (sb *Sandbox, err error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	sb = &Sandbox{}
+	defer func() {
+		if err != nil {
+			sb.Close()
+		}
+	}()
+
+	cgroupName := fmt.Sprintf("%s/sandbox-%s", parentCgroup, hex.EncodeToString(id[:]))
+	sb.cgroupPaths, err = makeCgroups(defaultCgroupSubsystems, cgroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	sb.containersPath, err = ioutil.TempDir("", "kobun4-executor-containers-")
+	if err != nil {
+		return nil, err
+	}
+
+	sb.rootfsesPath, err = ioutil.TempDir("", "kobun4-executor-rootfses-")
+	if err != nil {
+		return nil, err
+	}
+
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer stdinReader.Close()
+	sb.StdinWriter = stdinWriter
+
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer stdoutWriter.Close()
+	sb.StdoutReader = stdoutReader
+
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer stderrWriter.Close()
+	sb.StderrReader = stderrReader
+
+	statusReader, statusWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer statusWriter.Close()
+	sb.StatusReader = statusReader
+
+	reqReader, reqWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer reqReader.Close()
+	sb.RequestWriter = reqWriter
+
+	sandboxReqReader, sandboxReqWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer sandboxReqReader.Close()
+	defer sandboxReqWriter.Close()
+
+	rawSandboxReq, err := proto.Marshal(&scriptspb.WorkerSandboxRequest{
+		ContainersPath: sb.containersPath,
+		RootfsesPath:   sb.rootfsesPath,
+		NsenternetPath: nsenternetPath,
+		Profile:        profile,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(supervisorPath, "-logtostderr", "-parent_cgroup", cgroupName)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Pdeathsig: syscall.SIGKILL,
+	}
+	cmd.ExtraFiles = []*os.File{
+		stdinReader,
+		stdoutWriter,
+		stderrWriter,
+		statusWriter,
+		reqReader,
+		sandboxReqReader,
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	sb.Cmd = cmd
+
+	// The supervisor is waited on for as long as it lives, so a sandbox that dies while idle can be told apart.
+	sb.exited = make(chan struct{})
+	go func() {
+		sb.waitErr = cmd.Wait()
+		close(sb.exited)
+	}()
+
+	if _, err := sandboxReqWriter.Write(rawSandboxReq); err != nil {
+		return nil, err
+	}
+
+	return sb, nil
+}
+
+// Alive returns whether the supervisor is still running.
+func (sb *Sandbox) Alive() bool {
+	select {
+	case <-sb.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// Wait waits for the supervisor to exit. It must be used instead of Cmd.Wait.
+func (sb *Sandbox) Wait() error {
+	<-sb.exited
+	return sb.waitErr
+}
+
+// Close kills the supervisor if it is still running and releases everything the sandbox holds.
+func (sb *Sandbox) Close() error {
+	if sb.Cmd != nil {
+		sb.Cmd.Process.Kill()
+		<-sb.exited
+	}
+
+	for _, f := range []*os.File{sb.StdinWriter, sb.StdoutReader, sb.StderrReader, sb.StatusReader, sb.RequestWriter} {
+		if f != nil {
+			f.Close()
+		}
+	}
+
+	if sb.containersPath != "" {
+		os.RemoveAll(sb.containersPath)
+	}
+
+	if sb.rootfsesPath != "" {
+		os.RemoveAll(sb.rootfsesPath)
+	}
+
+	var err error
+	for _, path := range sb.cgroupPaths {
+		if removeErr := removeCgroup(path); removeErr != nil {
+			glog.Errorf("Failed to remove all cgroups: %v", removeErr)
+			err = removeErr
+		}
+	}
+	return err
+}
@@ -7,39 +7,36 @@ import (
 	"golang.org/x/net/webdav"
 
 	"github.com/porpoises/kobun4/executor/accounts"
+	"github.com/porpoises/kobun4/executor/forwarded"
 )
 
 type Handler struct {
 	accounts *accounts.Store
+
+	trustForwardedFor bool
 }
 
-func NewHandler(accounts *accounts.Store) *Handler {
+func NewHandler(accounts *accounts.Store, trustForwardedFor bool) *Handler {
 	return &Handler{
 		accounts: accounts,
+
+		trustForwardedFor: trustForwardedFor,
 	}
 }
 
 func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) (*accounts.Account, error) {
 	username, password, _ := r.BasicAuth()
 
-	account, err := h.accounts.Account(r.Context(), username)
+	account, err := h.accounts.Login(r.Context(), username, password, forwarded.Source(r, h.trustForwardedFor), "webdav")
 	if err != nil {
-		if err == accounts.ErrNotFound {
-			w.Header().Set("WWW-Authenticate", "Basic realm=\"Kobun\"")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return nil, err
-		}
-		glog.Errorf("Failed to load account: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return nil, err
-	}
-
-	if err := account.Authenticate(r.Context(), password); err != nil {
 		switch err {
-		case accounts.ErrUnauthenticated:
+		case accounts.ErrNotFound, accounts.ErrUnauthenticated, accounts.ErrSuspended:
 			w.Header().Set("WWW-Authenticate", "Basic realm=\"Kobun\"")
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return nil, err
+		case accounts.ErrThrottled:
+			http.Error(w, "Too many failed logins", http.StatusTooManyRequests)
+			return nil, err
 		}
 		glog.Errorf("Failed to authenticate account: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
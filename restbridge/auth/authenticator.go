@@ -1,41 +1,108 @@
 package auth
 
 import (
-	"fmt"
 	"strings"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/emicklei/go-restful"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
 )
 
+// accessTokenPrefix starts every personal access token, as opposed to login tokens, which are JWTs.
+const accessTokenPrefix = "k4pat_"
+
 type Authenticator struct {
-	tokenSecret []byte
+	keyset         *Keyset
+	accountsClient accountspb.AccountsClient
 }
 
-func NewAuthenticator(tokenSecret []byte) *Authenticator {
+func NewAuthenticator(keyset *Keyset, accountsClient accountspb.AccountsClient) *Authenticator {
 	return &Authenticator{
-		tokenSecret: tokenSecret,
+		keyset:         keyset,
+		accountsClient: accountsClient,
 	}
 }
 
-func (a *Authenticator) Authenticate(req *restful.Request, resp *restful.Response) (string, error) {
+func bearerToken(req *restful.Request) string {
 	authorization := strings.SplitN(req.Request.Header.Get("Authorization"), " ", 2)
 	if len(authorization) != 2 || authorization[0] != "Bearer" {
+		return ""
+	}
+	return authorization[1]
+}
+
+// Authenticate returns the name of the account the request's bearer token belongs to, or an empty string if there is no
+// valid token or it does not carry scope. Login tokens carry every scope.
+func (a *Authenticator) Authenticate(req *restful.Request, resp *restful.Response, scope accountspb.Scope) (string, error) {
+	token := bearerToken(req)
+	if token == "" {
 		return "", nil
 	}
 
-	token, _ := jwt.ParseWithClaims(authorization[1], &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	if strings.HasPrefix(token, accessTokenPrefix) {
+		return a.authenticateAccessToken(req.Request.Context(), token, scope)
+	}
+
+	claims, err := a.loginClaims(req.Request.Context(), token)
+	if err != nil || claims == nil {
+		return "", err
+	}
+
+	return claims.Subject, nil
+}
+
+// LoginClaims returns the claims of the request's login token, or nil if it has no valid login token.
+func (a *Authenticator) LoginClaims(req *restful.Request) (*jwt.StandardClaims, error) {
+	token := bearerToken(req)
+	if token == "" || strings.HasPrefix(token, accessTokenPrefix) {
+		return nil, nil
+	}
+
+	return a.loginClaims(req.Request.Context(), token)
+}
+
+func (a *Authenticator) loginClaims(ctx context.Context, token string) (*jwt.StandardClaims, error) {
+	claims, err := a.keyset.Verify(token)
+	if err != nil {
+		return nil, nil
+	}
+
+	// Login tokens are checked against the revocation list on every request, so they can be revoked before they expire.
+	if _, err := a.accountsClient.CheckToken(ctx, &accountspb.CheckTokenRequest{
+		Username:  claims.Subject,
+		TokenId:   claims.Id,
+		IssueTime: claims.IssuedAt,
+	}); err != nil {
+		switch grpc.Code(err) {
+		case codes.NotFound, codes.PermissionDenied:
+			return nil, nil
 		}
+		return nil, err
+	}
 
-		return a.tokenSecret, nil
+	return claims, nil
+}
+
+func (a *Authenticator) authenticateAccessToken(ctx context.Context, token string, scope accountspb.Scope) (string, error) {
+	authResp, err := a.accountsClient.AuthenticateAccessToken(ctx, &accountspb.AuthenticateAccessTokenRequest{
+		Token: token,
 	})
+	if err != nil {
+		if grpc.Code(err) == codes.PermissionDenied {
+			return "", nil
+		}
+		return "", err
+	}
 
-	if token == nil || !token.Valid {
-		return "", nil
+	for _, tokenScope := range authResp.Scope {
+		if tokenScope == scope {
+			return authResp.Username, nil
+		}
 	}
 
-	claims := token.Claims.(*jwt.StandardClaims)
-	return claims.Subject, nil
+	return "", nil
 }
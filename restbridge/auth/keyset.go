@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ErrUnknownKey   = errors.New("auth: unknown key")
+	errInvalidToken = errors.New("auth: invalid token")
+)
+
+// Keyset holds the keys login tokens may be signed with, identified by the kid header of each token. Keeping the old
+// key in the set while signing with a new one lets keys be rotated without logging everyone out.
+type Keyset struct {
+	keys         map[string][]byte
+	signingKeyID string
+}
+
+// NewKeyset creates a keyset that signs new tokens with the key named by signingKeyID. The key with an empty ID, if
+// any, verifies tokens without a kid header.
+func NewKeyset(keys map[string][]byte, signingKeyID string) (*Keyset, error) {
+	if _, ok := keys[signingKeyID]; !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return &Keyset{
+		keys:         keys,
+		signingKeyID: signingKeyID,
+	}, nil
+}
+
+// ParseKeys parses keys of the form <key ID>=<secret>, separated by commas.
+func ParseKeys(spec string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+
+	if spec == "" {
+		return keys, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("expected <key ID>=<secret>, got %q", pair)
+		}
+		keys[parts[0]] = []byte(parts[1])
+	}
+
+	return keys, nil
+}
+
+func (k *Keyset) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if k.signingKeyID != "" {
+		token.Header["kid"] = k.signingKeyID
+	}
+
+	return token.SignedString(k.keys[k.signingKeyID])
+}
+
+func (k *Keyset) key(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+// Verify checks a token's signature and expiry, returning its claims.
+func (k *Keyset) Verify(tokenString string) (*jwt.StandardClaims, error) {
+	claims := &jwt.StandardClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, k.key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errInvalidToken
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestParseKeys(t *testing.T) {
+	for _, c := range []struct {
+		spec  string
+		want  map[string][]byte
+		valid bool
+	}{
+		{"", map[string][]byte{}, true},
+		{"1=secret", map[string][]byte{"1": []byte("secret")}, true},
+		{"1=old,2=new", map[string][]byte{"1": []byte("old"), "2": []byte("new")}, true},
+		{"1=a=b", map[string][]byte{"1": []byte("a=b")}, true},
+		{"secret", nil, false},
+		{"=secret", nil, false},
+		{"1=", nil, false},
+		{"1=old,", nil, false},
+	} {
+		got, err := ParseKeys(c.spec)
+		if valid := err == nil; valid != c.valid {
+			t.Errorf("ParseKeys(%q) = %v, want valid = %v", c.spec, err, c.valid)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseKeys(%q) = %v, want %v", c.spec, got, c.want)
+		}
+	}
+}
+
+func TestNewKeysetUnknownSigningKey(t *testing.T) {
+	if _, err := NewKeyset(map[string][]byte{"1": []byte("old")}, "2"); err != ErrUnknownKey {
+		t.Errorf("NewKeyset = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func tokenKeyID(t *testing.T, tokenString string) (string, bool) {
+	segment, err := jwt.DecodeSegment(strings.Split(tokenString, ".")[0])
+	if err != nil {
+		t.Fatalf("failed to decode token header: %v", err)
+	}
+
+	var header map[string]interface{}
+	if err := json.Unmarshal(segment, &header); err != nil {
+		t.Fatalf("failed to unmarshal token header: %v", err)
+	}
+
+	kid, ok := header["kid"].(string)
+	return kid, ok
+}
+
+func TestKeysetRotation(t *testing.T) {
+	for _, c := range []struct {
+		name         string
+		signingKeys  map[string][]byte
+		signingKeyID string
+		verifyKeys   map[string][]byte
+		valid        bool
+	}{
+		{
+			name:         "same key",
+			signingKeys:  map[string][]byte{"1": []byte("old")},
+			signingKeyID: "1",
+			verifyKeys:   map[string][]byte{"1": []byte("old")},
+			valid:        true,
+		},
+		{
+			name:         "old key kept after rotation",
+			signingKeys:  map[string][]byte{"1": []byte("old")},
+			signingKeyID: "1",
+			verifyKeys:   map[string][]byte{"1": []byte("old"), "2": []byte("new")},
+			valid:        true,
+		},
+		{
+			name:         "old key dropped after rotation",
+			signingKeys:  map[string][]byte{"1": []byte("old")},
+			signingKeyID: "1",
+			verifyKeys:   map[string][]byte{"2": []byte("new")},
+			valid:        false,
+		},
+		{
+			name:         "kid selects key",
+			signingKeys:  map[string][]byte{"1": []byte("old"), "2": []byte("new")},
+			signingKeyID: "2",
+			verifyKeys:   map[string][]byte{"1": []byte("old"), "2": []byte("new")},
+			valid:        true,
+		},
+		{
+			name:         "kid with different secret",
+			signingKeys:  map[string][]byte{"1": []byte("old")},
+			signingKeyID: "1",
+			verifyKeys:   map[string][]byte{"1": []byte("new")},
+			valid:        false,
+		},
+		{
+			name:         "no kid",
+			signingKeys:  map[string][]byte{"": []byte("legacy")},
+			signingKeyID: "",
+			verifyKeys:   map[string][]byte{"": []byte("legacy"), "1": []byte("new")},
+			valid:        true,
+		},
+		{
+			name:         "no kid without legacy key",
+			signingKeys:  map[string][]byte{"": []byte("legacy")},
+			signingKeyID: "",
+			verifyKeys:   map[string][]byte{"1": []byte("legacy")},
+			valid:        false,
+		},
+	} {
+		signer, err := NewKeyset(c.signingKeys, c.signingKeyID)
+		if err != nil {
+			t.Fatalf("%s: NewKeyset = %v", c.name, err)
+		}
+
+		// Verification does not depend on which key the verifier would sign with.
+		verifier := &Keyset{keys: c.verifyKeys}
+
+		token, err := signer.Sign(&jwt.StandardClaims{
+			Subject:   "someone",
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		})
+		if err != nil {
+			t.Fatalf("%s: Sign = %v", c.name, err)
+		}
+
+		kid, ok := tokenKeyID(t, token)
+		if wantOK := c.signingKeyID != ""; ok != wantOK || kid != c.signingKeyID {
+			t.Errorf("%s: token kid = %q (present = %v), want %q (present = %v)", c.name, kid, ok, c.signingKeyID, wantOK)
+		}
+
+		claims, err := verifier.Verify(token)
+		if valid := err == nil; valid != c.valid {
+			t.Errorf("%s: Verify = %v, want valid = %v", c.name, err, c.valid)
+			continue
+		}
+		if err == nil && claims.Subject != "someone" {
+			t.Errorf("%s: Verify subject = %q, want %q", c.name, claims.Subject, "someone")
+		}
+	}
+}
+
+func TestKeysetVerifyExpired(t *testing.T) {
+	keyset, err := NewKeyset(map[string][]byte{"1": []byte("secret")}, "1")
+	if err != nil {
+		t.Fatalf("NewKeyset = %v", err)
+	}
+
+	token, err := keyset.Sign(&jwt.StandardClaims{
+		Subject:   "someone",
+		ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("Sign = %v", err)
+	}
+
+	if _, err := keyset.Verify(token); err == nil {
+		t.Errorf("Verify = nil, want error for expired token")
+	}
+}
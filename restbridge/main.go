@@ -22,14 +22,24 @@ import (
 
 	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
 	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+	secretspb "github.com/porpoises/kobun4/executor/secretsservice/v1pb"
 )
 
 var (
 	bindSocket      = flag.String("bind_socket", "/run/kobun4-restbridge/main.socket", "Bind for socket")
 	bindDebugSocket = flag.String("bind_debug_socket", "/run/kobun4-restbridge/debug.socket", "Bind for socket")
 
-	tokenSecret   = flag.String("token_secret", "", "Token secret")
-	tokenDuration = flag.Duration("token_duration", 24*time.Hour, "Token duration")
+	tokenSecret          = flag.String("token_secret", "", "Token secret, used for tokens without a key ID")
+	tokenKeys            = flag.String("token_keys", "", "Comma-separated <key ID>=<secret> keys that tokens may be signed with")
+	tokenSigningKeyID    = flag.String("token_signing_key_id", "", "ID of the key in -token_keys to sign new tokens with, or empty to sign them with -token_secret")
+	tokenDuration        = flag.Duration("token_duration", 24*time.Hour, "Token duration")
+	refreshTokenDuration = flag.Duration("refresh_token_duration", 30*24*time.Hour, "How long a refresh token may go unused before it expires")
+
+	requireInviteCode      = flag.Bool("require_invite_code", false, "Require an invite code to register an account")
+	registrationsPerSource = flag.Int("registrations_per_source", 3, "Maximum number of registration attempts per source address per -registration_window")
+	registrationWindow     = flag.Duration("registration_window", time.Hour, "Window over which registration attempts are limited")
+
+	trustForwardedFor = flag.Bool("trust_forwarded_for", false, "Take the last address in X-Forwarded-For as the client's address. Only set this if the socket is only reachable through a reverse proxy that sets it")
 
 	executorTarget = flag.String("executor_target", "/run/kobun4-executor/main.socket", "Executor target")
 )
@@ -52,8 +62,18 @@ func main() {
 
 	go http.Serve(debugLis, nil)
 
-	if *tokenSecret == "" {
-		glog.Fatal("-token_secret not provided")
+	keys, err := auth.ParseKeys(*tokenKeys)
+	if err != nil {
+		glog.Fatalf("failed to parse -token_keys: %v", err)
+	}
+
+	if *tokenSecret != "" {
+		keys[""] = []byte(*tokenSecret)
+	}
+
+	keyset, err := auth.NewKeyset(keys, *tokenSigningKeyID)
+	if err != nil {
+		glog.Fatal("-token_secret not provided, or -token_signing_key_id not in -token_keys")
 	}
 
 	executorConn, err := grpc.Dial(*executorTarget, grpc.WithInsecure(), grpc.WithDialer(func(address string, timeout time.Duration) (net.Conn, error) {
@@ -68,18 +88,22 @@ func main() {
 
 	accountsClient := accountspb.NewAccountsClient(executorConn)
 	scriptsClient := scriptspb.NewScriptsClient(executorConn)
+	secretsClient := secretspb.NewSecretsClient(executorConn)
 
-	secret := []byte(*tokenSecret)
+	authenticator := auth.NewAuthenticator(keyset, accountsClient)
 
-	authenticator := auth.NewAuthenticator(secret)
+	// Accounts created by logging in via Discord count towards the same limit as registrations.
+	registrationLimiter := rest.NewRateLimiter(*registrationsPerSource, *registrationWindow)
 
-	accountsResource := rest.NewAccountsResource(authenticator, accountsClient)
+	accountsResource := rest.NewAccountsResource(authenticator, accountsClient, secretsClient, *requireInviteCode, registrationLimiter, *trustForwardedFor)
 	scriptsResource := rest.NewScriptsResource(authenticator, scriptsClient)
-	loginResource := rest.NewLoginResource(secret, *tokenDuration, accountsClient)
+	loginResource := rest.NewLoginResource(keyset, *tokenDuration, *refreshTokenDuration, authenticator, accountsClient, *requireInviteCode, registrationLimiter, *trustForwardedFor)
+	runtimesResource := rest.NewRuntimesResource(scriptsClient)
 
 	wsContainer.Add(accountsResource.WebService())
 	wsContainer.Add(scriptsResource.WebService())
 	wsContainer.Add(loginResource.WebService())
+	wsContainer.Add(runtimesResource.WebService())
 
 	httpServer := &http.Server{
 		Handler: wsContainer,
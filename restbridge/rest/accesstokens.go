@@ -0,0 +1,184 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
+)
+
+type AccessToken struct {
+	ID          string   `json:"id,omitempty"`
+	Name        string   `json:"name"`
+	Scopes      []string `json:"scopes"`
+	CreateTime  int64    `json:"createTime,omitempty"`
+	ExpireTime  int64    `json:"expireTime,omitempty"`
+	LastUseTime int64    `json:"lastUseTime,omitempty"`
+	Token       string   `json:"token,omitempty"`
+}
+
+type AccessTokens struct {
+	AccessTokens []*AccessToken `json:"accessTokens"`
+}
+
+func accessTokenFromPb(accessToken *accountspb.AccessToken) *AccessToken {
+	scopes := make([]string, len(accessToken.Scope))
+	for i, scope := range accessToken.Scope {
+		scopes[i] = strings.ToLower(scope.String())
+	}
+
+	return &AccessToken{
+		ID:          accessToken.Id,
+		Name:        accessToken.Name,
+		Scopes:      scopes,
+		CreateTime:  accessToken.CreateTime,
+		ExpireTime:  accessToken.ExpireTime,
+		LastUseTime: accessToken.LastUseTime,
+	}
+}
+
+var errBadScope = errors.New("bad scope")
+
+func scopesToPb(names []string) ([]accountspb.Scope, error) {
+	scopes := make([]accountspb.Scope, len(names))
+	for i, name := range names {
+		scope, ok := accountspb.Scope_value[strings.ToUpper(name)]
+		if !ok {
+			return nil, errBadScope
+		}
+		scopes[i] = accountspb.Scope(scope)
+	}
+	return scopes, nil
+}
+
+func (r AccountsResource) listAccessTokens(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_ACCOUNT_ADMIN)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+	if accountName != username {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	listResp, err := r.accountsClient.ListAccessTokens(req.Request.Context(), &accountspb.ListAccessTokensRequest{
+		Username: accountName,
+	})
+	if err != nil {
+		glog.Errorf("Failed to list access tokens: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accessTokens := make([]*AccessToken, len(listResp.AccessToken))
+	for i, accessToken := range listResp.AccessToken {
+		accessTokens[i] = accessTokenFromPb(accessToken)
+	}
+
+	resp.WriteEntity(AccessTokens{
+		AccessTokens: accessTokens,
+	})
+}
+
+func (r AccountsResource) createAccessToken(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_ACCOUNT_ADMIN)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+	if accountName != username {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	accessToken := new(AccessToken)
+	if err := req.ReadEntity(&accessToken); err != nil {
+		glog.Errorf("Failed to read entity: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	scopes, err := scopesToPb(accessToken.Scopes)
+	if err != nil {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusBadRequest, "bad request: scopes must be read_scripts, write_scripts, execute or account_admin")
+		return
+	}
+
+	createResp, err := r.accountsClient.CreateAccessToken(req.Request.Context(), &accountspb.CreateAccessTokenRequest{
+		Username:   accountName,
+		Name:       accessToken.Name,
+		Scope:      scopes,
+		ExpireTime: accessToken.ExpireTime,
+	})
+	if err != nil {
+		switch grpc.Code(err) {
+		case codes.InvalidArgument:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: tokens need a name of at most 64 characters, at least one scope and an expiry time in the future, if any")
+		case codes.AlreadyExists:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusConflict, "access token already exists")
+		default:
+			glog.Errorf("Failed to create access token: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	created := accessTokenFromPb(createResp.AccessToken)
+	created.Token = createResp.Token
+	resp.WriteEntity(created)
+}
+
+func (r AccountsResource) revokeAccessToken(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_ACCOUNT_ADMIN)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+	if accountName != username {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if _, err := r.accountsClient.RevokeAccessToken(req.Request.Context(), &accountspb.RevokeAccessTokenRequest{
+		Username: accountName,
+		Id:       req.PathParameter("tokenId"),
+	}); err != nil {
+		if grpc.Code(err) == codes.NotFound {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "access token not found")
+		} else {
+			glog.Errorf("Failed to revoke access token: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+}
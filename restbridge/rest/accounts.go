@@ -12,6 +12,7 @@ import (
 	"github.com/porpoises/kobun4/restbridge/auth"
 
 	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
+	secretspb "github.com/porpoises/kobun4/executor/secretsservice/v1pb"
 )
 
 type Index struct {
@@ -27,15 +28,58 @@ type Password struct {
 	Password string `json:"password"`
 }
 
+type SecretNames struct {
+	Names []string `json:"names"`
+}
+
+type Secret struct {
+	Value string `json:"value"`
+}
+
+type Identifiers struct {
+	Identifiers []string `json:"identifiers"`
+}
+
+type LinkCode struct {
+	Code string `json:"code"`
+}
+
+type Identifier struct {
+	Identifier string `json:"identifier"`
+}
+
+type LoginAttempt struct {
+	Time    int64  `json:"time"`
+	Source  string `json:"source"`
+	Method  string `json:"method"`
+	Success bool   `json:"success"`
+}
+
+type LoginAttempts struct {
+	LoginAttempts []*LoginAttempt `json:"loginAttempts"`
+}
+
 type AccountsResource struct {
 	authenticator  *auth.Authenticator
 	accountsClient accountspb.AccountsClient
+	secretsClient  secretspb.SecretsClient
+
+	requireInviteCode   bool
+	registrationLimiter *RateLimiter
+
+	trustForwardedFor bool
 }
 
-func NewAccountsResource(authenticator *auth.Authenticator, accountsClient accountspb.AccountsClient) *AccountsResource {
+func NewAccountsResource(authenticator *auth.Authenticator, accountsClient accountspb.AccountsClient, secretsClient secretspb.SecretsClient, requireInviteCode bool, registrationLimiter *RateLimiter, trustForwardedFor bool) *AccountsResource {
 	return &AccountsResource{
 		authenticator:  authenticator,
 		accountsClient: accountsClient,
+		secretsClient:  secretsClient,
+
+		requireInviteCode:   requireInviteCode,
+		registrationLimiter: registrationLimiter,
+
+		trustForwardedFor: trustForwardedFor,
 	}
 }
 
@@ -53,6 +97,11 @@ func (r AccountsResource) WebService() *restful.WebService {
 		Doc("Lists accounts.").
 		Writes(Index{}))
 
+	ws.Route(ws.POST("").To(r.register).
+		Doc("Registers an account with a password.").
+		Reads(Registration{}).
+		Writes(Account{}))
+
 	ws.Route(ws.GET("/{accountName}").To(r.read).
 		Doc("Reads an account.").
 		Param(ws.PathParameter("accountName", "account name")).
@@ -63,6 +112,61 @@ func (r AccountsResource) WebService() *restful.WebService {
 		Param(ws.PathParameter("accountName", "account name")).
 		Reads(Password{}))
 
+	ws.Route(ws.GET("/{accountName}/secrets").To(r.listSecretNames).
+		Doc("Lists the names of an account's secrets.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Writes(SecretNames{}))
+
+	ws.Route(ws.PUT("/{accountName}/secrets/{secretName}").To(r.setSecret).
+		Doc("Sets a secret.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("secretName", "secret name")).
+		Reads(Secret{}))
+
+	ws.Route(ws.DELETE("/{accountName}/secrets/{secretName}").To(r.deleteSecret).
+		Doc("Deletes a secret.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("secretName", "secret name")))
+
+	ws.Route(ws.GET("/{accountName}/identifiers").To(r.listIdentifiers).
+		Doc("Lists the identifiers, e.g. Discord users, linked to an account.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Writes(Identifiers{}))
+
+	ws.Route(ws.POST("/{accountName}/identifiers").To(r.linkIdentifier).
+		Doc("Links an identifier to an account by redeeming a link code.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Reads(LinkCode{}).
+		Writes(Identifier{}))
+
+	ws.Route(ws.DELETE("/{accountName}/identifiers").To(r.unlinkIdentifier).
+		Doc("Unlinks an identifier from an account.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.QueryParameter("identifier", "identifier to unlink")))
+
+	ws.Route(ws.GET("/{accountName}/tokens").To(r.listAccessTokens).
+		Doc("Lists an account's personal access tokens.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Writes(AccessTokens{}))
+
+	ws.Route(ws.POST("/{accountName}/tokens").To(r.createAccessToken).
+		Doc("Creates a personal access token. The token is only returned here.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Reads(AccessToken{}).
+		Writes(AccessToken{}))
+
+	ws.Route(ws.DELETE("/{accountName}/tokens/{tokenId}").To(r.revokeAccessToken).
+		Doc("Revokes a personal access token.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("tokenId", "token ID")))
+
+	ws.Route(ws.GET("/{accountName}/logins").To(r.listLoginAttempts).
+		Doc("Lists an account's login history, most recent first.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.QueryParameter("offset", "offset")).
+		Param(ws.QueryParameter("limit", "limit")).
+		Writes(LoginAttempts{}))
+
 	return ws
 }
 
@@ -113,7 +217,7 @@ func (r AccountsResource) list(req *restful.Request, resp *restful.Response) {
 }
 
 func (r AccountsResource) read(req *restful.Request, resp *restful.Response) {
-	username, err := r.authenticator.Authenticate(req, resp)
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_ACCOUNT_ADMIN)
 	if err != nil {
 		glog.Errorf("Failed to authenticate: %v", err)
 		resp.AddHeader("Content-Type", "text/plain")
@@ -150,7 +254,7 @@ func (r AccountsResource) read(req *restful.Request, resp *restful.Response) {
 }
 
 func (r AccountsResource) setPassword(req *restful.Request, resp *restful.Response) {
-	username, err := r.authenticator.Authenticate(req, resp)
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_ACCOUNT_ADMIN)
 	if err != nil {
 		glog.Errorf("Failed to authenticate: %v", err)
 		resp.AddHeader("Content-Type", "text/plain")
@@ -183,3 +287,313 @@ func (r AccountsResource) setPassword(req *restful.Request, resp *restful.Respon
 		return
 	}
 }
+
+func (r AccountsResource) listSecretNames(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_ACCOUNT_ADMIN)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+	if accountName != username {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	listResp, err := r.secretsClient.ListSecretNames(req.Request.Context(), &secretspb.ListSecretNamesRequest{
+		OwnerName: accountName,
+	})
+	if err != nil {
+		if grpc.Code(err) == codes.Unimplemented {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotImplemented, "secrets are not enabled")
+		} else {
+			glog.Errorf("Failed to list secrets: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	resp.WriteEntity(SecretNames{
+		Names: listResp.Name,
+	})
+}
+
+func (r AccountsResource) setSecret(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_ACCOUNT_ADMIN)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+	if accountName != username {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	secret := new(Secret)
+	if err := req.ReadEntity(&secret); err != nil {
+		glog.Errorf("Failed to read entity: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if _, err := r.secretsClient.SetSecret(req.Request.Context(), &secretspb.SetSecretRequest{
+		OwnerName: accountName,
+		Name:      req.PathParameter("secretName"),
+		Value:     []byte(secret.Value),
+	}); err != nil {
+		switch grpc.Code(err) {
+		case codes.InvalidArgument:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: secret names must be upper case letters, digits and underscores, and values at most 4 KiB")
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "account not found")
+		case codes.Unimplemented:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotImplemented, "secrets are not enabled")
+		default:
+			glog.Errorf("Failed to set secret: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+}
+
+func (r AccountsResource) deleteSecret(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_ACCOUNT_ADMIN)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+	if accountName != username {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if _, err := r.secretsClient.DeleteSecret(req.Request.Context(), &secretspb.DeleteSecretRequest{
+		OwnerName: accountName,
+		Name:      req.PathParameter("secretName"),
+	}); err != nil {
+		switch grpc.Code(err) {
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "secret not found")
+		case codes.Unimplemented:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotImplemented, "secrets are not enabled")
+		default:
+			glog.Errorf("Failed to delete secret: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+}
+
+func (r AccountsResource) listIdentifiers(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_ACCOUNT_ADMIN)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+	if accountName != username {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	listResp, err := r.accountsClient.ListIdentifiers(req.Request.Context(), &accountspb.ListIdentifiersRequest{
+		Username: accountName,
+	})
+	if err != nil {
+		glog.Errorf("Failed to list identifiers: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp.WriteEntity(Identifiers{
+		Identifiers: listResp.Identifier,
+	})
+}
+
+func (r AccountsResource) linkIdentifier(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_ACCOUNT_ADMIN)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+	if accountName != username {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	linkCode := new(LinkCode)
+	if err := req.ReadEntity(&linkCode); err != nil {
+		glog.Errorf("Failed to read entity: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	redeemResp, err := r.accountsClient.RedeemLinkCode(req.Request.Context(), &accountspb.RedeemLinkCodeRequest{
+		Username: accountName,
+		Code:     linkCode.Code,
+	})
+	if err != nil {
+		switch grpc.Code(err) {
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: link code invalid or expired")
+		case codes.AlreadyExists:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusConflict, "identifier already linked")
+		default:
+			glog.Errorf("Failed to redeem link code: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	resp.WriteEntity(Identifier{
+		Identifier: redeemResp.Identifier,
+	})
+}
+
+func (r AccountsResource) unlinkIdentifier(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_ACCOUNT_ADMIN)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+	if accountName != username {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if _, err := r.accountsClient.RemoveIdentifier(req.Request.Context(), &accountspb.RemoveIdentifierRequest{
+		Username:   accountName,
+		Identifier: req.QueryParameter("identifier"),
+	}); err != nil {
+		switch grpc.Code(err) {
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "identifier not found")
+		case codes.FailedPrecondition:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusConflict, "cannot unlink the last identifier of an account without a password")
+		default:
+			glog.Errorf("Failed to remove identifier: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+}
+
+func (r AccountsResource) listLoginAttempts(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_ACCOUNT_ADMIN)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+	if accountName != username {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var offset uint32
+	limit := maxLimit
+
+	if rawOffset := req.QueryParameter("offset"); rawOffset != "" {
+		v, err := strconv.ParseUint(rawOffset, 10, 32)
+		if err != nil {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: bad offset")
+			return
+		}
+
+		offset = uint32(v)
+	}
+
+	if rawLimit := req.QueryParameter("limit"); rawLimit != "" {
+		v, err := strconv.ParseUint(rawLimit, 10, 32)
+		if err != nil {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: bad limit")
+			return
+		}
+
+		limit = uint32(v)
+
+		if limit > maxLimit {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: limit too high")
+			return
+		}
+	}
+
+	listResp, err := r.accountsClient.ListLoginAttempts(req.Request.Context(), &accountspb.ListLoginAttemptsRequest{
+		Username: accountName,
+		Offset:   offset,
+		Limit:    limit,
+	})
+	if err != nil {
+		glog.Errorf("Failed to list login attempts: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	attempts := make([]*LoginAttempt, len(listResp.LoginAttempt))
+	for i, attempt := range listResp.LoginAttempt {
+		attempts[i] = &LoginAttempt{
+			Time:    attempt.Time,
+			Source:  attempt.Source,
+			Method:  attempt.Method,
+			Success: attempt.Success,
+		}
+	}
+
+	resp.WriteEntity(LoginAttempts{
+		LoginAttempts: attempts,
+	})
+}
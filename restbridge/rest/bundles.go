@@ -0,0 +1,194 @@
+package rest
+
+import (
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
+	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+)
+
+// maxBundleUploadSize bounds the size of an uploaded archive. The executor separately limits the size of its contents.
+const maxBundleUploadSize = 2 * 1024 * 1024
+
+var bundleFormats = map[string]scriptspb.SetBundleRequest_Format{
+	"application/x-tar": scriptspb.SetBundleRequest_TAR,
+	"application/gzip":  scriptspb.SetBundleRequest_TAR,
+	"application/zip":   scriptspb.SetBundleRequest_ZIP,
+}
+
+func (r ScriptsResource) readBundle(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_READ_SCRIPTS)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+	scriptName := req.PathParameter("scriptName")
+
+	bundleResp, err := func() (*scriptspb.GetBundleResponse, error) {
+		metaResp, err := r.scriptsClient.GetMeta(req.Request.Context(), &scriptspb.GetMetaRequest{
+			OwnerName: accountName,
+			Name:      scriptName,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if metaResp.Meta.Visibility == scriptspb.Visibility_UNPUBLISHED && username != accountName {
+			return nil, errNotPublished
+		}
+
+		return r.scriptsClient.GetBundle(req.Request.Context(), &scriptspb.GetBundleRequest{
+			OwnerName: accountName,
+			Name:      scriptName,
+		})
+	}()
+	if err != nil {
+		if err == errNotPublished {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "script not found")
+			return
+		}
+
+		switch grpc.Code(err) {
+		case codes.InvalidArgument:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "script name invalid")
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, grpc.ErrorDesc(err))
+		default:
+			glog.Errorf("Failed to get bundle: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	resp.AddHeader("Content-Type", "application/gzip")
+	resp.AddHeader("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": scriptName + ".tar.gz",
+	}))
+	resp.Write(bundleResp.Archive)
+}
+
+func (r ScriptsResource) updateBundle(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_WRITE_SCRIPTS)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+
+	if username != accountName {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	scriptName := req.PathParameter("scriptName")
+
+	mediaType, _, err := mime.ParseMediaType(req.HeaderParameter("Content-Type"))
+	if err != nil {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusBadRequest, "bad request: bad Content-Type header")
+		return
+	}
+
+	format, ok := bundleFormats[mediaType]
+	if !ok {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnsupportedMediaType, "bundle must be a tar or zip archive")
+		return
+	}
+
+	archive, err := ioutil.ReadAll(io.LimitReader(req.Request.Body, maxBundleUploadSize+1))
+	if err != nil {
+		glog.Errorf("Failed to read bundle: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if len(archive) > maxBundleUploadSize {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusRequestEntityTooLarge, "bundle too large")
+		return
+	}
+
+	if _, err := r.scriptsClient.SetBundle(req.Request.Context(), &scriptspb.SetBundleRequest{
+		OwnerName:  accountName,
+		Name:       scriptName,
+		Archive:    archive,
+		Format:     format,
+		Entrypoint: req.QueryParameter("entrypoint"),
+	}); err != nil {
+		switch grpc.Code(err) {
+		case codes.InvalidArgument:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, grpc.ErrorDesc(err))
+		case codes.ResourceExhausted:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusRequestEntityTooLarge, "bundle too large")
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "script not found")
+		default:
+			glog.Errorf("Failed to set bundle: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+}
+
+func (r ScriptsResource) deleteBundle(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_WRITE_SCRIPTS)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+
+	if username != accountName {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if _, err := r.scriptsClient.DeleteBundle(req.Request.Context(), &scriptspb.DeleteBundleRequest{
+		OwnerName: accountName,
+		Name:      req.PathParameter("scriptName"),
+	}); err != nil {
+		switch grpc.Code(err) {
+		case codes.InvalidArgument:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "script name invalid")
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "script not found")
+		default:
+			glog.Errorf("Failed to delete bundle: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+}
@@ -1,6 +1,8 @@
 package rest
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"time"
@@ -9,9 +11,13 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/emicklei/go-restful"
 	"github.com/golang/glog"
+	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 
+	"github.com/porpoises/kobun4/executor/forwarded"
+	"github.com/porpoises/kobun4/restbridge/auth"
+
 	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
 )
 
@@ -23,26 +29,46 @@ type UserpassCredentials struct {
 type DiscordCredentials struct {
 	Token             string `json:"token"`
 	PreferredUsername string `json:"preferredUsername,omitempty"`
+	InviteCode        string `json:"inviteCode,omitempty"`
 }
 
 type Token struct {
-	Username string `json:"username"`
-	Token    string `json:"token"`
+	Username     string `json:"username"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+type RefreshCredentials struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
 type LoginResource struct {
-	tokenSecret   []byte
-	tokenDuration time.Duration
+	keyset               *auth.Keyset
+	tokenDuration        time.Duration
+	refreshTokenDuration time.Duration
 
+	authenticator  *auth.Authenticator
 	accountsClient accountspb.AccountsClient
+
+	requireInviteCode   bool
+	registrationLimiter *RateLimiter
+
+	trustForwardedFor bool
 }
 
-func NewLoginResource(tokenSecret []byte, tokenDuration time.Duration, accountsClient accountspb.AccountsClient) *LoginResource {
+func NewLoginResource(keyset *auth.Keyset, tokenDuration time.Duration, refreshTokenDuration time.Duration, authenticator *auth.Authenticator, accountsClient accountspb.AccountsClient, requireInviteCode bool, registrationLimiter *RateLimiter, trustForwardedFor bool) *LoginResource {
 	return &LoginResource{
-		tokenSecret:   tokenSecret,
-		tokenDuration: tokenDuration,
+		keyset:               keyset,
+		tokenDuration:        tokenDuration,
+		refreshTokenDuration: refreshTokenDuration,
 
+		authenticator:  authenticator,
 		accountsClient: accountsClient,
+
+		requireInviteCode:   requireInviteCode,
+		registrationLimiter: registrationLimiter,
+
+		trustForwardedFor: trustForwardedFor,
 	}
 }
 
@@ -60,25 +86,57 @@ func (l LoginResource) WebService() *restful.WebService {
 		Writes([]*Token{}))
 
 	ws.Route(ws.POST("discord").To(l.discord).
-		Doc("Log in via Discord credentials.").
+		Doc("Log in via Discord credentials. If no account is linked and a preferred username is given, an account is registered, subject to the same limits and invite code requirement as other registrations.").
 		Reads(DiscordCredentials{}).
 		Writes([]*Token{}))
 
+	ws.Route(ws.POST("refresh").To(l.refresh).
+		Doc("Exchanges a refresh token for a new login token and refresh token.").
+		Reads(RefreshCredentials{}).
+		Writes(Token{}))
+
+	ws.Route(ws.POST("logout").To(l.logout).
+		Doc("Revokes the request's login token, along with the session of the refresh token, if given.").
+		Reads(RefreshCredentials{}))
+
 	return ws
 }
 
-func (l LoginResource) createToken(username string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+func (l LoginResource) signToken(username string) (string, error) {
+	rawID := make([]byte, 16)
+	if _, err := rand.Read(rawID); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return l.keyset.Sign(jwt.StandardClaims{
+		Id:        hex.EncodeToString(rawID),
 		Subject:   username,
-		ExpiresAt: time.Now().Add(l.tokenDuration).Unix(),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(l.tokenDuration).Unix(),
 	})
+}
 
-	tokenString, err := token.SignedString(l.tokenSecret)
+// createToken creates a login token for the account, along with a refresh token for a new session.
+func (l LoginResource) createToken(ctx context.Context, username string) (*Token, error) {
+	tokenString, err := l.signToken(username)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	sessionResp, err := l.accountsClient.CreateSession(ctx, &accountspb.CreateSessionRequest{
+		Username:   username,
+		ExpireTime: time.Now().Add(l.refreshTokenDuration).Unix(),
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return tokenString, nil
+	return &Token{
+		Username:     username,
+		Token:        tokenString,
+		RefreshToken: sessionResp.RefreshToken,
+	}, nil
 }
 
 func (l LoginResource) userpass(req *restful.Request, resp *restful.Response) {
@@ -93,11 +151,16 @@ func (l LoginResource) userpass(req *restful.Request, resp *restful.Response) {
 	if _, err := l.accountsClient.Authenticate(req.Request.Context(), &accountspb.AuthenticateRequest{
 		Username: creds.Username,
 		Password: creds.Password,
+		Source:   forwarded.Source(req.Request, l.trustForwardedFor),
+		Method:   "userpass",
 	}); err != nil {
 		switch grpc.Code(err) {
 		case codes.NotFound, codes.PermissionDenied:
 			resp.AddHeader("Content-Type", "text/plain")
 			resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		case codes.ResourceExhausted:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusTooManyRequests, "too many failed logins")
 		default:
 			glog.Errorf("Failed to authenticate: %v", err)
 			resp.AddHeader("Content-Type", "text/plain")
@@ -106,19 +169,15 @@ func (l LoginResource) userpass(req *restful.Request, resp *restful.Response) {
 		return
 	}
 
-	tokenString, err := l.createToken(creds.Username)
+	token, err := l.createToken(req.Request.Context(), creds.Username)
 	if err != nil {
 		glog.Errorf("Failed to create token: %v", err)
 		resp.AddHeader("Content-Type", "text/plain")
 		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
 	}
 
-	resp.WriteEntity([]*Token{
-		&Token{
-			Username: creds.Username,
-			Token:    tokenString,
-		},
-	})
+	resp.WriteEntity([]*Token{token})
 }
 
 func (l LoginResource) discord(req *restful.Request, resp *restful.Response) {
@@ -165,48 +224,163 @@ func (l LoginResource) discord(req *restful.Request, resp *restful.Response) {
 			return
 		}
 
-		// We can create an account!
+		// We can create an account! This is a registration like any other, so it is limited in the same way.
+		if !l.registrationLimiter.Allow(forwarded.Source(req.Request, l.trustForwardedFor)) {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusTooManyRequests, "too many registrations, try again later")
+			return
+		}
+
+		if err := validateName(creds.PreferredUsername); err != nil {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, fmt.Sprintf("bad request: %v", err))
+			return
+		}
+
+		if l.requireInviteCode && creds.InviteCode == "" {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusForbidden, "an invite code is required")
+			return
+		}
+
 		if _, err := l.accountsClient.Create(req.Request.Context(), &accountspb.CreateRequest{
 			Username:   creds.PreferredUsername,
 			Identifier: []string{fmt.Sprintf("discord/%s", user.ID)},
+			InviteCode: creds.InviteCode,
 		}); err != nil {
-			glog.Errorf("Failed to create account: %v", err)
-			resp.AddHeader("Content-Type", "text/plain")
-			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+			switch grpc.Code(err) {
+			case codes.InvalidArgument:
+				resp.AddHeader("Content-Type", "text/plain")
+				resp.WriteErrorString(http.StatusBadRequest, "bad request: invalid name")
+			case codes.AlreadyExists:
+				resp.AddHeader("Content-Type", "text/plain")
+				resp.WriteErrorString(http.StatusConflict, "account already exists")
+			case codes.FailedPrecondition:
+				resp.AddHeader("Content-Type", "text/plain")
+				resp.WriteErrorString(http.StatusForbidden, "invalid invite code")
+			default:
+				glog.Errorf("Failed to create account: %v", err)
+				resp.AddHeader("Content-Type", "text/plain")
+				resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+			}
 			return
 		}
 
-		tokenString, err := l.createToken(creds.PreferredUsername)
+		token, err := l.createToken(req.Request.Context(), creds.PreferredUsername)
 		if err != nil {
 			glog.Errorf("Failed to create token: %v", err)
 			resp.AddHeader("Content-Type", "text/plain")
 			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+			return
 		}
 
-		resp.WriteEntity([]*Token{
-			&Token{
-				Username: creds.PreferredUsername,
-				Token:    tokenString,
-			},
-		})
+		resp.WriteEntity([]*Token{token})
 		return
 	}
 
 	tokens := make([]*Token, 0)
 
 	for _, username := range listResp.Name {
-		tokenString, err := l.createToken(username)
+		token, err := l.createToken(req.Request.Context(), username)
 		if err != nil {
 			glog.Errorf("Failed to create token: %v", err)
 			resp.AddHeader("Content-Type", "text/plain")
 			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+			return
 		}
 
-		tokens = append(tokens, &Token{
-			Username: username,
-			Token:    tokenString,
-		})
+		tokens = append(tokens, token)
 	}
 
 	resp.WriteEntity(tokens)
 }
+
+func (l LoginResource) refresh(req *restful.Request, resp *restful.Response) {
+	creds := new(RefreshCredentials)
+	if err := req.ReadEntity(creds); err != nil {
+		glog.Errorf("Failed to read entity: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	refreshResp, err := l.accountsClient.RefreshSession(req.Request.Context(), &accountspb.RefreshSessionRequest{
+		RefreshToken: creds.RefreshToken,
+		ExpireTime:   time.Now().Add(l.refreshTokenDuration).Unix(),
+	})
+	if err != nil {
+		if grpc.Code(err) == codes.PermissionDenied {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		} else {
+			glog.Errorf("Failed to refresh session: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	tokenString, err := l.signToken(refreshResp.Username)
+	if err != nil {
+		glog.Errorf("Failed to create token: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp.WriteEntity(&Token{
+		Username:     refreshResp.Username,
+		Token:        tokenString,
+		RefreshToken: refreshResp.RefreshToken,
+	})
+}
+
+func (l LoginResource) logout(req *restful.Request, resp *restful.Response) {
+	claims, err := l.authenticator.LoginClaims(req)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if claims == nil {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	creds := new(RefreshCredentials)
+	if req.Request.ContentLength != 0 {
+		if err := req.ReadEntity(creds); err != nil {
+			glog.Errorf("Failed to read entity: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+			return
+		}
+	}
+
+	// Tokens issued before token IDs were added cannot be revoked individually, and expire on their own.
+	if claims.Id != "" {
+		if _, err := l.accountsClient.RevokeToken(req.Request.Context(), &accountspb.RevokeTokenRequest{
+			TokenId:    claims.Id,
+			ExpireTime: claims.ExpiresAt,
+		}); err != nil {
+			glog.Errorf("Failed to revoke token: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+			return
+		}
+	}
+
+	if creds.RefreshToken != "" {
+		if _, err := l.accountsClient.DeleteSession(req.Request.Context(), &accountspb.DeleteSessionRequest{
+			RefreshToken: creds.RefreshToken,
+		}); err != nil {
+			glog.Errorf("Failed to delete session: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+			return
+		}
+	}
+}
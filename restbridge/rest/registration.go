@@ -0,0 +1,107 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/porpoises/kobun4/executor/forwarded"
+
+	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
+)
+
+type Registration struct {
+	Name       string `json:"name"`
+	Password   string `json:"password"`
+	InviteCode string `json:"inviteCode,omitempty"`
+}
+
+// These mirror the executor's rules for account names, so users can be told what is wrong with theirs.
+const maxNameLength = 20
+
+var nameCharsRegexp = regexp.MustCompile(`^[a-z0-9_-]*$`)
+
+const minPasswordLength = 8
+
+func validateName(name string) error {
+	if name == "" {
+		return errors.New("name must not be empty")
+	}
+
+	if len(name) > maxNameLength {
+		return fmt.Errorf("name must be at most %d characters long", maxNameLength)
+	}
+
+	if !nameCharsRegexp.MatchString(name) {
+		return errors.New("name may only contain lowercase letters, digits, underscores and hyphens")
+	}
+
+	return nil
+}
+
+func (r AccountsResource) register(req *restful.Request, resp *restful.Response) {
+	if !r.registrationLimiter.Allow(forwarded.Source(req.Request, r.trustForwardedFor)) {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusTooManyRequests, "too many registrations, try again later")
+		return
+	}
+
+	registration := new(Registration)
+	if err := req.ReadEntity(registration); err != nil {
+		glog.Errorf("Failed to read entity: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if err := validateName(registration.Name); err != nil {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusBadRequest, fmt.Sprintf("bad request: %v", err))
+		return
+	}
+
+	if len(registration.Password) < minPasswordLength {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusBadRequest, fmt.Sprintf("bad request: password must be at least %d characters long", minPasswordLength))
+		return
+	}
+
+	if r.requireInviteCode && registration.InviteCode == "" {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusForbidden, "an invite code is required")
+		return
+	}
+
+	if _, err := r.accountsClient.Create(req.Request.Context(), &accountspb.CreateRequest{
+		Username:   registration.Name,
+		Password:   registration.Password,
+		InviteCode: registration.InviteCode,
+	}); err != nil {
+		switch grpc.Code(err) {
+		case codes.InvalidArgument:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: invalid name")
+		case codes.AlreadyExists:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusConflict, "account already exists")
+		case codes.FailedPrecondition:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusForbidden, "invalid invite code")
+		default:
+			glog.Errorf("Failed to create account: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	resp.WriteEntity(Account{
+		Name: registration.Name,
+	})
+}
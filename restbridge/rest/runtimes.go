@@ -0,0 +1,60 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
+)
+
+type Runtime struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type RuntimesResource struct {
+	scriptsClient scriptspb.ScriptsClient
+}
+
+func NewRuntimesResource(scriptsClient scriptspb.ScriptsClient) *RuntimesResource {
+	return &RuntimesResource{
+		scriptsClient: scriptsClient,
+	}
+}
+
+func (r RuntimesResource) WebService() *restful.WebService {
+	ws := new(restful.WebService)
+
+	ws.Path("/runtimes").
+		Doc("Runtime information.").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.GET("").To(r.list).
+		Doc("List runtimes scripts can run in.").
+		Writes([]*Runtime{}))
+
+	return ws
+}
+
+func (r RuntimesResource) list(req *restful.Request, resp *restful.Response) {
+	listResp, err := r.scriptsClient.ListRuntimes(req.Request.Context(), &scriptspb.ListRuntimesRequest{})
+	if err != nil {
+		glog.Errorf("Failed to list runtimes: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	runtimes := make([]*Runtime, len(listResp.Runtime))
+	for i, runtime := range listResp.Runtime {
+		runtimes[i] = &Runtime{
+			Name:        runtime.Name,
+			Description: runtime.Description,
+		}
+	}
+
+	resp.WriteEntity(runtimes)
+}
@@ -2,6 +2,7 @@ package rest
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -14,15 +15,218 @@ import (
 
 	"github.com/porpoises/kobun4/restbridge/auth"
 
+	accountspb "github.com/porpoises/kobun4/executor/accountsservice/v1pb"
 	scriptspb "github.com/porpoises/kobun4/executor/scriptsservice/v1pb"
 )
 
 type Script struct {
-	OwnerName   string `json:"ownerName"`
-	Name        string `json:"name"`
+	OwnerName    string       `json:"ownerName"`
+	Name         string       `json:"name"`
+	Description  string       `json:"description"`
+	Visibility   int          `json:"visibility"`
+	Parameters   []*Parameter `json:"parameters,omitempty"`
+	Runtime      string       `json:"runtime,omitempty"`
+	Dependencies []string     `json:"dependencies,omitempty"`
+	Secrets      []string     `json:"secrets,omitempty"`
+	Entrypoint   string       `json:"entrypoint,omitempty"`
+	Content      string       `json:"content,omitempty"`
+	Revision     uint64       `json:"revision,omitempty"`
+	CreateTime   int64        `json:"createTime,omitempty"`
+	UpdateTime   int64        `json:"updateTime,omitempty"`
+	ForkedFrom   string       `json:"forkedFrom,omitempty"`
+}
+
+type Parameter struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Help         string `json:"help,omitempty"`
+	Positional   bool   `json:"positional,omitempty"`
+	Required     bool   `json:"required,omitempty"`
+	Rest         bool   `json:"rest,omitempty"`
+	DefaultValue string `json:"defaultValue,omitempty"`
+}
+
+func parametersFromPb(params []*scriptspb.Parameter) []*Parameter {
+	parameters := make([]*Parameter, len(params))
+	for i, param := range params {
+		parameters[i] = &Parameter{
+			Name:         param.Name,
+			Type:         strings.ToLower(param.Type.String()),
+			Help:         param.Help,
+			Positional:   param.Positional,
+			Required:     param.Required,
+			Rest:         param.Rest,
+			DefaultValue: param.DefaultValue,
+		}
+	}
+	return parameters
+}
+
+var errBadParameterType = errors.New("bad parameter type")
+
+// parametersToPb converts parameters from their JSON form. An empty type means a string.
+func parametersToPb(parameters []*Parameter) ([]*scriptspb.Parameter, error) {
+	params := make([]*scriptspb.Parameter, len(parameters))
+	for i, parameter := range parameters {
+		typ, ok := scriptspb.Parameter_Type_value[strings.ToUpper(parameter.Type)]
+		if !ok && parameter.Type != "" {
+			return nil, errBadParameterType
+		}
+
+		params[i] = &scriptspb.Parameter{
+			Name:         parameter.Name,
+			Type:         scriptspb.Parameter_Type(typ),
+			Help:         parameter.Help,
+			Positional:   parameter.Positional,
+			Required:     parameter.Required,
+			Rest:         parameter.Rest,
+			DefaultValue: parameter.DefaultValue,
+		}
+	}
+	return params, nil
+}
+
+type ForkTarget struct {
+	OwnerName string `json:"ownerName"`
+	Name      string `json:"name"`
+}
+
+type OwnershipTransfer struct {
+	OwnerName string `json:"ownerName"`
+}
+
+type Revision struct {
+	ID          uint64 `json:"id"`
+	AuthorName  string `json:"authorName"`
+	CreateTime  int64  `json:"createTime"`
+	ContentHash string `json:"contentHash"`
 	Description string `json:"description"`
 	Visibility  int    `json:"visibility"`
 	Content     string `json:"content,omitempty"`
+	Diff        string `json:"diff,omitempty"`
+}
+
+type Execution struct {
+	ID                string `json:"id"`
+	OwnerName         string `json:"ownerName"`
+	Name              string `json:"name"`
+	BridgeName        string `json:"bridgeName"`
+	CommandName       string `json:"commandName"`
+	UserID            string `json:"userId"`
+	ChannelID         string `json:"channelId"`
+	GroupID           string `json:"groupId"`
+	NetworkID         string `json:"networkId"`
+	StartTime         int64  `json:"startTime"`
+	WaitStatus        uint32 `json:"waitStatus"`
+	TimeLimitExceeded bool   `json:"timeLimitExceeded"`
+	Cancelled         bool   `json:"cancelled"`
+	Error             string `json:"error,omitempty"`
+	RealNanos         uint64 `json:"realNanos"`
+	UserNanos         uint64 `json:"userNanos"`
+	SystemNanos       uint64 `json:"systemNanos"`
+	Stdout            string `json:"stdout,omitempty"`
+	Stderr            string `json:"stderr,omitempty"`
+}
+
+func executionFromPb(execution *scriptspb.Execution) *Execution {
+	e := &Execution{
+		ID:        execution.Id,
+		OwnerName: execution.OwnerName,
+		Name:      execution.Name,
+		StartTime: execution.StartTime,
+		Error:     execution.Error,
+		Stdout:    string(execution.Stdout),
+		Stderr:    string(execution.Stderr),
+	}
+
+	if execution.Context != nil {
+		e.BridgeName = execution.Context.BridgeName
+		e.CommandName = execution.Context.CommandName
+		e.UserID = execution.Context.UserId
+		e.ChannelID = execution.Context.ChannelId
+		e.GroupID = execution.Context.GroupId
+		e.NetworkID = execution.Context.NetworkId
+	}
+
+	if execution.Result != nil {
+		e.WaitStatus = execution.Result.WaitStatus
+		e.TimeLimitExceeded = execution.Result.TimeLimitExceeded
+		e.Cancelled = execution.Result.Cancelled
+		if execution.Result.Timings != nil {
+			e.RealNanos = execution.Result.Timings.RealNanos
+			e.UserNanos = execution.Result.Timings.UserNanos
+			e.SystemNanos = execution.Result.Timings.SystemNanos
+		}
+	}
+
+	return e
+}
+
+func revisionFromPb(revision *scriptspb.Revision) *Revision {
+	return &Revision{
+		ID:          revision.Id,
+		AuthorName:  revision.AuthorName,
+		CreateTime:  revision.CreateTime,
+		ContentHash: revision.ContentHash,
+		Description: revision.Meta.Description,
+		Visibility:  int(revision.Meta.Visibility),
+	}
+}
+
+var errBadSortOrder = errors.New("bad sort order")
+
+// parseSortOrder accepts either the numeric value or the case-insensitive name of a sort order. An empty string is the
+// default sort order.
+func parseSortOrder(raw string) (scriptspb.ListRequest_SortOrder, error) {
+	if raw == "" {
+		return 0, nil
+	}
+
+	if v, err := strconv.Atoi(raw); err == nil {
+		if _, ok := scriptspb.ListRequest_SortOrder_name[int32(v)]; !ok {
+			return 0, errBadSortOrder
+		}
+		return scriptspb.ListRequest_SortOrder(v), nil
+	}
+
+	v, ok := scriptspb.ListRequest_SortOrder_value[strings.ToUpper(raw)]
+	if !ok {
+		return 0, errBadSortOrder
+	}
+	return scriptspb.ListRequest_SortOrder(v), nil
+}
+
+func formatETag(revision uint64) string {
+	return fmt.Sprintf(`"%d"`, revision)
+}
+
+var (
+	errBadIfMatch  = errors.New("bad If-Match header")
+	errWeakIfMatch = errors.New("weak entity tag in If-Match header")
+)
+
+// parseIfMatch returns the revision required by an If-Match header, or 0 if any revision is acceptable. If-Match uses
+// strong comparison, so weak entity tags never match (RFC 7232, section 3.1).
+func parseIfMatch(header string) (uint64, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	if strings.HasPrefix(header, "W/") {
+		return 0, errWeakIfMatch
+	}
+
+	if len(header) < 2 || header[0] != '"' || header[len(header)-1] != '"' {
+		return 0, errBadIfMatch
+	}
+
+	revision, err := strconv.ParseUint(header[1:len(header)-1], 10, 64)
+	if err != nil {
+		return 0, errBadIfMatch
+	}
+
+	return revision, nil
 }
 
 type ScriptsResource struct {
@@ -47,11 +251,13 @@ func (r ScriptsResource) WebService() *restful.WebService {
 
 	ws.Route(ws.GET("").To(r.list).
 		Doc("List scripts.").
+		Param(ws.QueryParameter("order", "sort order: default, votes, recently_updated, newest or most_used")).
 		Writes([]*Script{}))
 
 	ws.Route(ws.GET("/{accountName}").To(r.listAccount).
 		Doc("Lists account scripts.").
 		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.QueryParameter("order", "sort order: default, votes, recently_updated, newest or most_used")).
 		Writes([]*Script{}))
 
 	ws.Route(ws.GET("/{accountName}/{scriptName}").To(r.read).
@@ -68,19 +274,109 @@ func (r ScriptsResource) WebService() *restful.WebService {
 		Doc("Updates a script.").
 		Param(ws.PathParameter("accountName", "account name")).
 		Param(ws.PathParameter("scriptName", "script name")).
+		Param(ws.HeaderParameter("If-Match", "ETag of the revision being updated")).
 		Reads(Script{}))
 
 	ws.Route(ws.DELETE("/{accountName}/{scriptName}").To(r.delete).
 		Doc("Deletes a script.").
 		Param(ws.PathParameter("accountName", "account name")).
 		Param(ws.PathParameter("scriptName", "script name")).
+		Param(ws.HeaderParameter("If-Match", "ETag of the revision being deleted")).
 		Reads(Script{}))
 
+	ws.Route(ws.GET("/{accountName}/{scriptName}/bundle").To(r.readBundle).
+		Doc("Downloads a script's bundle as a gzipped tar archive.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")).
+		Produces("application/gzip"))
+
+	ws.Route(ws.PUT("/{accountName}/{scriptName}/bundle").To(r.updateBundle).
+		Doc("Uploads a tar or zip archive as a script's bundle.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")).
+		Param(ws.QueryParameter("entrypoint", "path of the file in the bundle to execute")).
+		Consumes("application/x-tar", "application/gzip", "application/zip"))
+
+	ws.Route(ws.DELETE("/{accountName}/{scriptName}/bundle").To(r.deleteBundle).
+		Doc("Removes a script's bundle.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")))
+
+	ws.Route(ws.POST("/{accountName}/{scriptName}/fork").To(r.fork).
+		Doc("Forks a script into the authenticated account.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")).
+		Reads(ForkTarget{}).
+		Writes(Script{}))
+
+	ws.Route(ws.POST("/{accountName}/{scriptName}/transfer").To(r.offerTransfer).
+		Doc("Offers a script to another account, which must accept it before it is transferred.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")).
+		Reads(OwnershipTransfer{}))
+
+	ws.Route(ws.DELETE("/{accountName}/{scriptName}/transfer").To(r.cancelTransfer).
+		Doc("Withdraws a script's transfer offer.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")))
+
+	ws.Route(ws.POST("/{accountName}/{scriptName}/transfer/accept").To(r.acceptTransfer).
+		Doc("Accepts a script offered to the authenticated account. Its executions and schedules are deleted.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")).
+		Writes(Script{}))
+
+	ws.Route(ws.GET("/{accountName}/{scriptName}/revisions").To(r.listRevisions).
+		Doc("Lists a script's revisions.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")).
+		Writes([]*Revision{}))
+
+	ws.Route(ws.GET("/{accountName}/{scriptName}/revisions/{revisionId}").To(r.readRevision).
+		Doc("Reads a script revision.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")).
+		Param(ws.PathParameter("revisionId", "revision ID")).
+		Param(ws.QueryParameter("diff", "revision ID to diff against")).
+		Writes(Revision{}))
+
+	ws.Route(ws.POST("/{accountName}/{scriptName}/revisions/{revisionId}/rollback").To(r.rollback).
+		Doc("Rolls a script back to a revision.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")).
+		Param(ws.PathParameter("revisionId", "revision ID")).
+		Writes(Revision{}))
+
+	ws.Route(ws.GET("/{accountName}/{scriptName}/executions").To(r.listExecutions).
+		Doc("Lists a script's recent executions.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")).
+		Writes([]*Execution{}))
+
+	ws.Route(ws.GET("/{accountName}/{scriptName}/executions/{executionId}").To(r.readExecution).
+		Doc("Reads an execution.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")).
+		Param(ws.PathParameter("executionId", "execution ID")).
+		Writes(Execution{}))
+
+	ws.Route(ws.POST("/{accountName}/{scriptName}/executions/{executionId}/cancel").To(r.cancelExecution).
+		Doc("Cancels a running execution.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")).
+		Param(ws.PathParameter("executionId", "execution ID")))
+
+	ws.Route(ws.GET("/{accountName}/{scriptName}/running").To(r.listRunning).
+		Doc("Lists a script's running executions.").
+		Param(ws.PathParameter("accountName", "account name")).
+		Param(ws.PathParameter("scriptName", "script name")).
+		Writes([]*Execution{}))
+
 	return ws
 }
 
 func (r ScriptsResource) list(req *restful.Request, resp *restful.Response) {
-	username, err := r.authenticator.Authenticate(req, resp)
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_READ_SCRIPTS)
 	if err != nil {
 		glog.Errorf("Failed to authenticate: %v", err)
 		resp.AddHeader("Content-Type", "text/plain")
@@ -116,9 +412,11 @@ func (r ScriptsResource) list(req *restful.Request, resp *restful.Response) {
 		}
 	}
 
-	sortOrder, err := strconv.Atoi(req.QueryParameter("order"))
+	sortOrder, err := parseSortOrder(req.QueryParameter("order"))
 	if err != nil {
-		sortOrder = 0
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusBadRequest, "bad request: bad sort order")
+		return
 	}
 
 	listResp, err := r.scriptsClient.List(req.Request.Context(), &scriptspb.ListRequest{
@@ -127,7 +425,7 @@ func (r ScriptsResource) list(req *restful.Request, resp *restful.Response) {
 		ViewerName: username,
 		Offset:     offset,
 		Limit:      limit,
-		SortOrder:  scriptspb.ListRequest_SortOrder(sortOrder),
+		SortOrder:  sortOrder,
 	})
 
 	if err != nil {
@@ -144,6 +442,9 @@ func (r ScriptsResource) list(req *restful.Request, resp *restful.Response) {
 			Name:        entry.Name,
 			Description: entry.Meta.Description,
 			Visibility:  int(entry.Meta.Visibility),
+			CreateTime:  entry.Meta.CreateTime,
+			UpdateTime:  entry.Meta.UpdateTime,
+			ForkedFrom:  entry.Meta.ForkedFrom,
 		}
 	}
 
@@ -151,7 +452,7 @@ func (r ScriptsResource) list(req *restful.Request, resp *restful.Response) {
 }
 
 func (r ScriptsResource) listAccount(req *restful.Request, resp *restful.Response) {
-	username, err := r.authenticator.Authenticate(req, resp)
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_READ_SCRIPTS)
 	if err != nil {
 		glog.Errorf("Failed to authenticate: %v", err)
 		resp.AddHeader("Content-Type", "text/plain")
@@ -189,12 +490,20 @@ func (r ScriptsResource) listAccount(req *restful.Request, resp *restful.Respons
 		}
 	}
 
+	sortOrder, err := parseSortOrder(req.QueryParameter("order"))
+	if err != nil {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusBadRequest, "bad request: bad sort order")
+		return
+	}
+
 	listResp, err := r.scriptsClient.List(req.Request.Context(), &scriptspb.ListRequest{
 		OwnerName:  accountName,
 		Query:      req.QueryParameter("q"),
 		ViewerName: username,
 		Offset:     offset,
 		Limit:      limit,
+		SortOrder:  sortOrder,
 	})
 
 	if err != nil {
@@ -211,6 +520,9 @@ func (r ScriptsResource) listAccount(req *restful.Request, resp *restful.Respons
 			Name:        entry.Name,
 			Description: entry.Meta.Description,
 			Visibility:  int(entry.Meta.Visibility),
+			CreateTime:  entry.Meta.CreateTime,
+			UpdateTime:  entry.Meta.UpdateTime,
+			ForkedFrom:  entry.Meta.ForkedFrom,
 		}
 	}
 
@@ -220,7 +532,7 @@ func (r ScriptsResource) listAccount(req *restful.Request, resp *restful.Respons
 var errNotPublished = errors.New("not published")
 
 func (r ScriptsResource) read(req *restful.Request, resp *restful.Response) {
-	username, err := r.authenticator.Authenticate(req, resp)
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_READ_SCRIPTS)
 	if err != nil {
 		glog.Errorf("Failed to authenticate: %v", err)
 		resp.AddHeader("Content-Type", "text/plain")
@@ -232,6 +544,7 @@ func (r ScriptsResource) read(req *restful.Request, resp *restful.Response) {
 	scriptName := req.PathParameter("scriptName")
 
 	var meta *scriptspb.Meta
+	var revision uint64
 	var content string
 
 	var g errgroup.Group
@@ -246,6 +559,7 @@ func (r ScriptsResource) read(req *restful.Request, resp *restful.Response) {
 		}
 
 		meta = metaResp.Meta
+		revision = metaResp.Revision
 		if meta.Visibility == scriptspb.Visibility_UNPUBLISHED && username != accountName {
 			return errNotPublished
 		}
@@ -290,17 +604,27 @@ func (r ScriptsResource) read(req *restful.Request, resp *restful.Response) {
 		return
 	}
 
+	resp.AddHeader("ETag", formatETag(revision))
 	resp.WriteEntity(Script{
-		OwnerName:   accountName,
-		Name:        scriptName,
-		Description: meta.Description,
-		Visibility:  int(meta.Visibility),
-		Content:     content,
+		OwnerName:    accountName,
+		Name:         scriptName,
+		Description:  meta.Description,
+		Visibility:   int(meta.Visibility),
+		Parameters:   parametersFromPb(meta.Parameter),
+		Runtime:      meta.Runtime,
+		Dependencies: meta.Dependency,
+		Secrets:      meta.Secret,
+		Entrypoint:   meta.BundleEntrypoint,
+		Content:      content,
+		Revision:     revision,
+		CreateTime:   meta.CreateTime,
+		UpdateTime:   meta.UpdateTime,
+		ForkedFrom:   meta.ForkedFrom,
 	})
 }
 
 func (r ScriptsResource) create(req *restful.Request, resp *restful.Response) {
-	username, err := r.authenticator.Authenticate(req, resp)
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_WRITE_SCRIPTS)
 	if err != nil {
 		glog.Errorf("Failed to authenticate: %v", err)
 		resp.AddHeader("Content-Type", "text/plain")
@@ -322,19 +646,31 @@ func (r ScriptsResource) create(req *restful.Request, resp *restful.Response) {
 		return
 	}
 
+	params, err := parametersToPb(script.Parameters)
+	if err != nil {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusBadRequest, "bad request: bad parameter type")
+		return
+	}
+
 	if _, err := r.scriptsClient.Create(req.Request.Context(), &scriptspb.CreateRequest{
 		OwnerName: script.OwnerName,
 		Name:      script.Name,
 		Meta: &scriptspb.Meta{
 			Description: script.Description,
 			Visibility:  scriptspb.Visibility(script.Visibility),
+			Parameter:   params,
+			Runtime:     script.Runtime,
+			Dependency:  script.Dependencies,
+			Secret:      script.Secrets,
 		},
-		Content: []byte(strings.Replace(script.Content, "\r", "", -1)),
+		Content:    []byte(strings.Replace(script.Content, "\r", "", -1)),
+		AuthorName: username,
 	}); err != nil {
 		switch grpc.Code(err) {
 		case codes.InvalidArgument:
 			resp.AddHeader("Content-Type", "text/plain")
-			resp.WriteErrorString(http.StatusBadRequest, "script name invalid")
+			resp.WriteErrorString(http.StatusBadRequest, grpc.ErrorDesc(err))
 		case codes.AlreadyExists:
 			resp.AddHeader("Content-Type", "text/plain")
 			resp.WriteErrorString(http.StatusConflict, "script already exists")
@@ -350,7 +686,7 @@ func (r ScriptsResource) create(req *restful.Request, resp *restful.Response) {
 }
 
 func (r ScriptsResource) update(req *restful.Request, resp *restful.Response) {
-	username, err := r.authenticator.Authenticate(req, resp)
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_WRITE_SCRIPTS)
 	if err != nil {
 		glog.Errorf("Failed to authenticate: %v", err)
 		resp.AddHeader("Content-Type", "text/plain")
@@ -375,45 +711,125 @@ func (r ScriptsResource) update(req *restful.Request, resp *restful.Response) {
 		return
 	}
 
-	if _, err := r.scriptsClient.Delete(req.Request.Context(), &scriptspb.DeleteRequest{
+	expectedRevision, err := parseIfMatch(req.HeaderParameter("If-Match"))
+	if err != nil {
+		if err == errWeakIfMatch {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusPreconditionFailed, "weak entity tags cannot be used with If-Match")
+			return
+		}
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusBadRequest, "bad request: bad If-Match header")
+		return
+	}
+
+	params, err := parametersToPb(script.Parameters)
+	if err != nil {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusBadRequest, "bad request: bad parameter type")
+		return
+	}
+
+	updateResp, err := r.scriptsClient.Update(req.Request.Context(), &scriptspb.UpdateRequest{
 		OwnerName: script.OwnerName,
 		Name:      scriptName,
-	}); err != nil {
+		NewName:   script.Name,
+		Meta: &scriptspb.Meta{
+			Description: script.Description,
+			Visibility:  scriptspb.Visibility(script.Visibility),
+			Parameter:   params,
+			Runtime:     script.Runtime,
+			Dependency:  script.Dependencies,
+			Secret:      script.Secrets,
+		},
+		Content:          []byte(strings.Replace(script.Content, "\r", "", -1)),
+		AuthorName:       username,
+		ExpectedRevision: expectedRevision,
+	})
+	if err != nil {
 		switch grpc.Code(err) {
+		case codes.Aborted:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusPreconditionFailed, "script was modified")
 		case codes.InvalidArgument:
 			resp.AddHeader("Content-Type", "text/plain")
-			resp.WriteErrorString(http.StatusBadRequest, "script name invalid")
+			resp.WriteErrorString(http.StatusBadRequest, grpc.ErrorDesc(err))
 		case codes.NotFound:
 			resp.AddHeader("Content-Type", "text/plain")
 			resp.WriteErrorString(http.StatusNotFound, "script not found")
+		case codes.AlreadyExists:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusConflict, "script already exists")
 		default:
-			glog.Errorf("Failed to delete script: %v", err)
+			glog.Errorf("Failed to update script: %v", err)
 			resp.AddHeader("Content-Type", "text/plain")
 			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
 		}
 		return
 	}
 
-	if _, err := r.scriptsClient.Create(req.Request.Context(), &scriptspb.CreateRequest{
-		OwnerName: script.OwnerName,
-		Name:      script.Name,
-		Meta: &scriptspb.Meta{
-			Description: script.Description,
-			Visibility:  scriptspb.Visibility(script.Visibility),
-		},
-		Content: []byte(strings.Replace(script.Content, "\r", "", -1)),
-	}); err != nil {
-		glog.Errorf("Failed to create script: %v", err)
+	script.Revision = updateResp.Revision.Id
+	resp.AddHeader("ETag", formatETag(script.Revision))
+	resp.WriteEntity(script)
+}
+
+func (r ScriptsResource) delete(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_WRITE_SCRIPTS)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
 		resp.AddHeader("Content-Type", "text/plain")
 		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
 		return
 	}
 
-	resp.WriteEntity(script)
+	accountName := req.PathParameter("accountName")
+
+	if username != accountName {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	scriptName := req.PathParameter("scriptName")
+
+	expectedRevision, err := parseIfMatch(req.HeaderParameter("If-Match"))
+	if err != nil {
+		if err == errWeakIfMatch {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusPreconditionFailed, "weak entity tags cannot be used with If-Match")
+			return
+		}
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusBadRequest, "bad request: bad If-Match header")
+		return
+	}
+
+	if _, err := r.scriptsClient.Delete(req.Request.Context(), &scriptspb.DeleteRequest{
+		OwnerName:        accountName,
+		Name:             scriptName,
+		ExpectedRevision: expectedRevision,
+	}); err != nil {
+		switch grpc.Code(err) {
+		case codes.Aborted:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusPreconditionFailed, "script was modified")
+		case codes.InvalidArgument:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "script name invalid")
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "script not found")
+		default:
+			glog.Errorf("Failed to delete script: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
 }
 
-func (r ScriptsResource) delete(req *restful.Request, resp *restful.Response) {
-	username, err := r.authenticator.Authenticate(req, resp)
+func (r ScriptsResource) listRevisions(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_READ_SCRIPTS)
 	if err != nil {
 		glog.Errorf("Failed to authenticate: %v", err)
 		resp.AddHeader("Content-Type", "text/plain")
@@ -431,10 +847,44 @@ func (r ScriptsResource) delete(req *restful.Request, resp *restful.Response) {
 
 	scriptName := req.PathParameter("scriptName")
 
-	if _, err := r.scriptsClient.Delete(req.Request.Context(), &scriptspb.DeleteRequest{
+	var offset uint32
+	limit := maxLimit
+
+	if rawOffset := req.QueryParameter("offset"); rawOffset != "" {
+		v, err := strconv.ParseUint(rawOffset, 10, 32)
+		if err != nil {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: bad offset")
+			return
+		}
+
+		offset = uint32(v)
+	}
+
+	if rawLimit := req.QueryParameter("limit"); rawLimit != "" {
+		v, err := strconv.ParseUint(rawLimit, 10, 32)
+		if err != nil {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: bad limit")
+			return
+		}
+
+		limit = uint32(v)
+
+		if limit > maxLimit {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: limit too high")
+			return
+		}
+	}
+
+	listResp, err := r.scriptsClient.ListRevisions(req.Request.Context(), &scriptspb.ListRevisionsRequest{
 		OwnerName: accountName,
 		Name:      scriptName,
-	}); err != nil {
+		Offset:    offset,
+		Limit:     limit,
+	})
+	if err != nil {
 		switch grpc.Code(err) {
 		case codes.InvalidArgument:
 			resp.AddHeader("Content-Type", "text/plain")
@@ -443,7 +893,515 @@ func (r ScriptsResource) delete(req *restful.Request, resp *restful.Response) {
 			resp.AddHeader("Content-Type", "text/plain")
 			resp.WriteErrorString(http.StatusNotFound, "script not found")
 		default:
-			glog.Errorf("Failed to delete script: %v", err)
+			glog.Errorf("Failed to list revisions: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	revisions := make([]*Revision, len(listResp.Revision))
+	for i, revision := range listResp.Revision {
+		revisions[i] = revisionFromPb(revision)
+	}
+
+	resp.WriteEntity(revisions)
+}
+
+func (r ScriptsResource) readRevision(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_READ_SCRIPTS)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+
+	if username != accountName {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	scriptName := req.PathParameter("scriptName")
+
+	revisionID, err := strconv.ParseUint(req.PathParameter("revisionId"), 10, 64)
+	if err != nil {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusBadRequest, "bad request: bad revision ID")
+		return
+	}
+
+	var diffBaseRevisionID uint64
+	if rawDiff := req.QueryParameter("diff"); rawDiff != "" {
+		diffBaseRevisionID, err = strconv.ParseUint(rawDiff, 10, 64)
+		if err != nil {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: bad diff revision ID")
+			return
+		}
+	}
+
+	getResp, err := r.scriptsClient.GetRevision(req.Request.Context(), &scriptspb.GetRevisionRequest{
+		OwnerName:          accountName,
+		Name:               scriptName,
+		RevisionId:         revisionID,
+		DiffBaseRevisionId: diffBaseRevisionID,
+	})
+	if err != nil {
+		switch grpc.Code(err) {
+		case codes.InvalidArgument:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "script name invalid")
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "revision not found")
+		default:
+			glog.Errorf("Failed to get revision: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	revision := revisionFromPb(getResp.Revision)
+	revision.Content = string(getResp.Content)
+	revision.Diff = getResp.Diff
+
+	resp.WriteEntity(revision)
+}
+
+func (r ScriptsResource) rollback(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_WRITE_SCRIPTS)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+
+	if username != accountName {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	scriptName := req.PathParameter("scriptName")
+
+	revisionID, err := strconv.ParseUint(req.PathParameter("revisionId"), 10, 64)
+	if err != nil {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusBadRequest, "bad request: bad revision ID")
+		return
+	}
+
+	rollbackResp, err := r.scriptsClient.Rollback(req.Request.Context(), &scriptspb.RollbackRequest{
+		OwnerName:  accountName,
+		Name:       scriptName,
+		RevisionId: revisionID,
+		AuthorName: username,
+	})
+	if err != nil {
+		switch grpc.Code(err) {
+		case codes.InvalidArgument:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "script name invalid")
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "revision not found")
+		default:
+			glog.Errorf("Failed to roll back script: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	resp.WriteEntity(revisionFromPb(rollbackResp.Revision))
+}
+
+func (r ScriptsResource) fork(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_WRITE_SCRIPTS)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+	scriptName := req.PathParameter("scriptName")
+
+	target := new(ForkTarget)
+	if err := req.ReadEntity(&target); err != nil {
+		glog.Errorf("Failed to read entity: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if target.OwnerName == "" {
+		target.OwnerName = username
+	}
+
+	if target.Name == "" {
+		target.Name = scriptName
+	}
+
+	if username == "" || target.OwnerName != username {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if _, err := r.scriptsClient.Fork(req.Request.Context(), &scriptspb.ForkRequest{
+		OwnerName:       accountName,
+		Name:            scriptName,
+		TargetOwnerName: target.OwnerName,
+		TargetName:      target.Name,
+		AuthorName:      username,
+	}); err != nil {
+		switch grpc.Code(err) {
+		case codes.InvalidArgument:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "script name invalid")
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "script not found")
+		case codes.AlreadyExists:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusConflict, "script already exists")
+		default:
+			glog.Errorf("Failed to fork script: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	resp.WriteEntity(Script{
+		OwnerName:  target.OwnerName,
+		Name:       target.Name,
+		ForkedFrom: accountName + "/" + scriptName,
+	})
+}
+
+func (r ScriptsResource) offerTransfer(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_WRITE_SCRIPTS)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+
+	if username != accountName {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	scriptName := req.PathParameter("scriptName")
+
+	transfer := new(OwnershipTransfer)
+	if err := req.ReadEntity(&transfer); err != nil {
+		glog.Errorf("Failed to read entity: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if _, err := r.scriptsClient.OfferTransfer(req.Request.Context(), &scriptspb.OfferTransferRequest{
+		OwnerName:    accountName,
+		Name:         scriptName,
+		NewOwnerName: transfer.OwnerName,
+	}); err != nil {
+		switch grpc.Code(err) {
+		case codes.InvalidArgument:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "account name invalid")
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "script or account not found")
+		default:
+			glog.Errorf("Failed to offer script transfer: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+}
+
+func (r ScriptsResource) cancelTransfer(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_WRITE_SCRIPTS)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+
+	if username != accountName {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if _, err := r.scriptsClient.CancelTransfer(req.Request.Context(), &scriptspb.CancelTransferRequest{
+		OwnerName: accountName,
+		Name:      req.PathParameter("scriptName"),
+	}); err != nil {
+		switch grpc.Code(err) {
+		case codes.InvalidArgument:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "script name invalid")
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "script or transfer offer not found")
+		default:
+			glog.Errorf("Failed to cancel script transfer: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+}
+
+func (r ScriptsResource) acceptTransfer(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_WRITE_SCRIPTS)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if username == "" {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	scriptName := req.PathParameter("scriptName")
+
+	if _, err := r.scriptsClient.TransferOwnership(req.Request.Context(), &scriptspb.TransferOwnershipRequest{
+		OwnerName:    req.PathParameter("accountName"),
+		Name:         scriptName,
+		NewOwnerName: username,
+	}); err != nil {
+		switch grpc.Code(err) {
+		case codes.InvalidArgument:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "script name invalid")
+		case codes.NotFound, codes.FailedPrecondition:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "script or transfer offer not found")
+		case codes.AlreadyExists:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusConflict, "script already exists")
+		case codes.Aborted:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusConflict, "script was modified since it was offered")
+		default:
+			glog.Errorf("Failed to transfer script: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	resp.WriteEntity(Script{
+		OwnerName: username,
+		Name:      scriptName,
+	})
+}
+
+func (r ScriptsResource) listExecutions(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_EXECUTE)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+
+	if username != accountName {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	scriptName := req.PathParameter("scriptName")
+
+	var offset uint32
+	limit := maxLimit
+
+	if rawOffset := req.QueryParameter("offset"); rawOffset != "" {
+		v, err := strconv.ParseUint(rawOffset, 10, 32)
+		if err != nil {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: bad offset")
+			return
+		}
+
+		offset = uint32(v)
+	}
+
+	if rawLimit := req.QueryParameter("limit"); rawLimit != "" {
+		v, err := strconv.ParseUint(rawLimit, 10, 32)
+		if err != nil {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: bad limit")
+			return
+		}
+
+		limit = uint32(v)
+
+		if limit > maxLimit {
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusBadRequest, "bad request: limit too high")
+			return
+		}
+	}
+
+	listResp, err := r.scriptsClient.ListExecutions(req.Request.Context(), &scriptspb.ListExecutionsRequest{
+		OwnerName: accountName,
+		Name:      scriptName,
+		Offset:    offset,
+		Limit:     limit,
+	})
+	if err != nil {
+		glog.Errorf("Failed to list executions: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	executions := make([]*Execution, len(listResp.Execution))
+	for i, execution := range listResp.Execution {
+		executions[i] = executionFromPb(execution)
+	}
+
+	resp.WriteEntity(executions)
+}
+
+func (r ScriptsResource) readExecution(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_EXECUTE)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+
+	if username != accountName {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	scriptName := req.PathParameter("scriptName")
+
+	getResp, err := r.scriptsClient.GetExecution(req.Request.Context(), &scriptspb.GetExecutionRequest{
+		Id: req.PathParameter("executionId"),
+	})
+	if err != nil {
+		switch grpc.Code(err) {
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "execution not found")
+		default:
+			glog.Errorf("Failed to get execution: %v", err)
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	if getResp.Execution.OwnerName != accountName || getResp.Execution.Name != scriptName {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusNotFound, "execution not found")
+		return
+	}
+
+	resp.WriteEntity(executionFromPb(getResp.Execution))
+}
+
+func (r ScriptsResource) listRunning(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_EXECUTE)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+
+	if username != accountName {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	listResp, err := r.scriptsClient.ListRunning(req.Request.Context(), &scriptspb.ListRunningRequest{
+		OwnerName: accountName,
+		Name:      req.PathParameter("scriptName"),
+	})
+	if err != nil {
+		glog.Errorf("Failed to list running executions: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	executions := make([]*Execution, len(listResp.Execution))
+	for i, execution := range listResp.Execution {
+		executions[i] = executionFromPb(execution)
+	}
+
+	resp.WriteEntity(executions)
+}
+
+func (r ScriptsResource) cancelExecution(req *restful.Request, resp *restful.Response) {
+	username, err := r.authenticator.Authenticate(req, resp, accountspb.Scope_EXECUTE)
+	if err != nil {
+		glog.Errorf("Failed to authenticate: %v", err)
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	accountName := req.PathParameter("accountName")
+
+	if username != accountName {
+		resp.AddHeader("Content-Type", "text/plain")
+		resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if _, err := r.scriptsClient.Cancel(req.Request.Context(), &scriptspb.CancelRequest{
+		ExecutionId: req.PathParameter("executionId"),
+		OwnerName:   accountName,
+	}); err != nil {
+		switch grpc.Code(err) {
+		case codes.NotFound:
+			resp.AddHeader("Content-Type", "text/plain")
+			resp.WriteErrorString(http.StatusNotFound, "execution not running")
+		default:
+			glog.Errorf("Failed to cancel execution: %v", err)
 			resp.AddHeader("Content-Type", "text/plain")
 			resp.WriteErrorString(http.StatusInternalServerError, "internal server error")
 		}